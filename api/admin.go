@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+type adminActorKey struct{}
+
+// requireAdmin guards /admin routes with an admin API key sent as a bearer
+// token, and records the name the key belongs to as the request's actor.
+// Admin routes are disabled when no key is configured.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.adminKeys) == 0 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		var actor string
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+			for name, key := range s.adminKeys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+					actor = name
+				}
+			}
+		}
+		if actor == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminActorKey{}, actor)))
+	})
+}
+
+// adminActor names the admin whose key authenticated the request, for the
+// audit log
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(adminActorKey{}).(string)
+	return actor
+}
+
+func (s *Server) adminRoutes(admin *mux.Router) {
+	admin.HandleFunc("/webhooks/endpoints", s.handleCreateWebhookEndpoint()).Methods("POST")
+	admin.HandleFunc("/webhooks/endpoints", s.handleListWebhookEndpoints()).Methods("GET")
+	admin.HandleFunc("/webhooks/deliveries", s.handleListWebhookDeliveries()).Methods("GET")
+	admin.HandleFunc("/webhooks/deliveries/{id}/attempts", s.handleListDeliveryAttempts()).Methods("GET")
+	admin.HandleFunc("/webhooks/deliveries/{id}/redeliver", s.handleRedeliver()).Methods("POST")
+	admin.HandleFunc("/ledger/accounts/{code}/balance", s.handleAccountBalance()).Methods("GET")
+	admin.HandleFunc("/ledger/merchants/{id}/balance", s.handleMerchantBalance()).Methods("GET")
+	admin.HandleFunc("/ledger/payments/{id}/entries", s.handleListPaymentEntries()).Methods("GET")
+	admin.HandleFunc("/reconciliation/reports", s.handleImportSettlement()).Methods("POST")
+	admin.HandleFunc("/reconciliation/reports", s.handleListReconciliationReports()).Methods("GET")
+	admin.HandleFunc("/reconciliation/reports/{id}", s.handleGetReconciliationReport()).Methods("GET")
+	admin.HandleFunc("/processors", s.handleListProcessors()).Methods("GET")
+	admin.HandleFunc("/processors/{id}/enable", s.handleSwitchProcessor(true)).Methods("POST")
+	admin.HandleFunc("/processors/{id}/disable", s.handleSwitchProcessor(false)).Methods("POST")
+	admin.HandleFunc("/processors/breakers", s.handleListBreakers()).Methods("GET")
+	admin.HandleFunc("/processors/stats", s.handleProcessorStats()).Methods("GET")
+	admin.HandleFunc("/routing/simulate", s.handleSimulateRouting()).Methods("POST")
+	admin.HandleFunc("/routing/rules", s.handleListRoutingRules()).Methods("GET")
+	admin.HandleFunc("/routing/rules", s.handleAddRoutingRule()).Methods("POST")
+	admin.HandleFunc("/routing/rules/reorder", s.handleReorderRoutingRules()).Methods("POST")
+	admin.HandleFunc("/routing/rules/{name}", s.handleUpdateRoutingRule()).Methods("PUT")
+	admin.HandleFunc("/routing/rules/{name}", s.handleDeleteRoutingRule()).Methods("DELETE")
+	admin.HandleFunc("/audit", s.handleListAuditLog()).Methods("GET")
+}
+
+type createEndpointRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+func (s *Server) handleCreateWebhookEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createEndpointRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+			return
+		}
+		if len(req.EventTypes) == 0 {
+			http.Error(w, "event_types must not be empty", http.StatusBadRequest)
+			return
+		}
+
+		endpoint, err := s.dispatcher.CreateEndpoint(r.Context(), req.URL, req.EventTypes)
+		if err != nil {
+			s.logger.Error("Failed to create webhook endpoint", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// The secret is only ever returned here
+		writeJSON(w, http.StatusCreated, endpoint)
+	}
+}
+
+func (s *Server) handleListWebhookEndpoints() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoints, err := s.dispatcher.ListEndpoints(r.Context())
+		if err != nil {
+			s.logger.Error("Failed to list webhook endpoints", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, endpoint := range endpoints {
+			endpoint.Secret = ""
+		}
+		writeJSON(w, http.StatusOK, listResponse{Data: endpoints})
+	}
+}
+
+func (s *Server) handleListWebhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := repository.DeliveryFilter{
+			Status:     model.DeliveryStatus(q.Get("status")),
+			EndpointID: q.Get("endpoint_id"),
+			Limit:      maxPageSize,
+		}
+		if v := q.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit < 1 || limit > maxPageSize {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		deliveries, err := s.dispatcher.ListDeliveries(r.Context(), filter)
+		if err != nil {
+			s.logger.Error("Failed to list webhook deliveries", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse{Data: deliveries})
+	}
+}
+
+func (s *Server) handleListDeliveryAttempts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attempts, err := s.dispatcher.ListAttempts(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, repository.ErrWebhookDeliveryNotFound) {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to list delivery attempts", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse{Data: attempts})
+	}
+}
+
+func (s *Server) handleRedeliver() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delivery, err := s.dispatcher.Redeliver(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, repository.ErrWebhookDeliveryNotFound) {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to redeliver webhook", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, delivery)
+	}
+}
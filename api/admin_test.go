@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminTakesActorFromKey(t *testing.T) {
+	s := &Server{adminKeys: map[string]string{"alice": "key-a", "bob": "key-b"}}
+
+	var actor string
+	handler := s.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = adminActor(r)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		actorHeader   string
+		wantStatus    int
+		wantActor     string
+	}{
+		{"first key", "Bearer key-a", "", http.StatusOK, "alice"},
+		{"second key", "Bearer key-b", "", http.StatusOK, "bob"},
+		{"actor header ignored", "Bearer key-b", "alice", http.StatusOK, "bob"},
+		{"unknown key", "Bearer key-c", "alice", http.StatusUnauthorized, ""},
+		{"empty token", "Bearer ", "", http.StatusUnauthorized, ""},
+		{"no credential", "", "alice", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actor = ""
+			req := httptest.NewRequest(http.MethodPost, "/admin/routing/rules", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.actorHeader != "" {
+				req.Header.Set("X-Admin-Actor", tt.actorHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if actor != tt.wantActor {
+				t.Fatalf("actor = %q, want %q", actor, tt.wantActor)
+			}
+		})
+	}
+}
+
+func TestRequireAdminDisabledWithoutKeys(t *testing.T) {
+	s := &Server{}
+	handler := s.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler called without admin keys")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// parsePaymentFilter reads the GET /payments query parameters. Metadata is
+// filtered with metadata[key]=value.
+func parsePaymentFilter(r *http.Request) (repository.PaymentFilter, error) {
+	q := r.URL.Query()
+	filter := repository.PaymentFilter{
+		Status:        model.PaymentStatus(q.Get("status")),
+		Currency:      q.Get("currency"),
+		ProcessorID:   q.Get("processor"),
+		StartingAfter: q.Get("starting_after"),
+		Limit:         defaultPageSize,
+	}
+
+	var err error
+	if filter.MinAmount, err = parseInt(q.Get("min_amount"), "min_amount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = parseInt(q.Get("max_amount"), "max_amount"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedAfter, err = parseTime(q.Get("created_after"), "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTime(q.Get("created_before"), "created_before"); err != nil {
+		return filter, err
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	switch sort := q.Get("sort"); sort {
+	case "", repository.SortByCreatedAt, repository.SortByAmount:
+		filter.SortBy = sort
+	default:
+		return filter, fmt.Errorf("unsupported sort %q", sort)
+	}
+	switch order := q.Get("order"); order {
+	case "", repository.SortAsc, repository.SortDesc:
+		filter.SortOrder = order
+	default:
+		return filter, fmt.Errorf("unsupported order %q", order)
+	}
+
+	for param, values := range q {
+		if strings.HasPrefix(param, "metadata[") && strings.HasSuffix(param, "]") {
+			if filter.Metadata == nil {
+				filter.Metadata = make(map[string]string)
+			}
+			filter.Metadata[param[len("metadata["):len(param)-1]] = values[0]
+		}
+	}
+
+	return filter, nil
+}
+
+func parseInt(v, name string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+func parseTime(v, name string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotentRequestTimeout bounds a request made with a key. A processor
+	// call takes at most three 15s attempts plus backoff, and an
+	// authorization cascades over a rule's processors.
+	idempotentRequestTimeout = 5 * time.Minute
+	// idempotencyLease is how long a key stays locked to its request before
+	// a retry may take it over; well beyond any request that is still running
+	idempotencyLease     = 3 * idempotentRequestTimeout
+	maxIdempotencyKeyLen = 255
+	maxRequestBodyBytes  = 1 << 20
+)
+
+// responseRecorder captures what a handler writes so it can be replayed
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a mutating handler safe to retry. Requests carrying an
+// Idempotency-Key are executed at most once; replays get the stored response.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestFingerprint := fingerprint(r, body)
+		record, claimed, err := s.idempotency.Claim(r.Context(), key, requestFingerprint, idempotencyKeyTTL, idempotencyLease)
+		if err != nil {
+			s.logger.Error("Failed to claim idempotency key", zap.String("key", key), zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case record.Fingerprint != requestFingerprint:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case record.Status == repository.IdempotencyInProgress:
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				if record.ResponseContentType != "" {
+					w.Header().Set("Content-Type", record.ResponseContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.ResponseCode)
+				w.Write(record.ResponseBody)
+			}
+			return
+		}
+
+		// The request must finish within the lease so no retry takes the
+		// key over while it is still charging
+		ctx, cancel := context.WithTimeout(r.Context(), idempotentRequestTimeout)
+		defer cancel()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// Store the outcome even if the client has already gone away
+		ctx = context.WithoutCancel(r.Context())
+		err = s.idempotency.Complete(ctx, key, record.LockToken, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		if errors.Is(err, repository.ErrIdempotencyLockLost) {
+			s.logger.Warn("Idempotency key was taken over before the response was stored", zap.String("key", key))
+		} else if err != nil {
+			s.logger.Error("Failed to store idempotent response", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// fingerprint identifies a request so a key cannot be reused for another one
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
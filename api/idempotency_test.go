@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+// memoryIdempotency follows the DbIdempotencyRepository contract, without
+// expiry. now is the clock leases are measured against.
+type memoryIdempotency struct {
+	mu      sync.Mutex
+	records map[string]*repository.IdempotencyRecord
+	now     time.Time
+	tokens  int
+}
+
+func newMemoryIdempotency() *memoryIdempotency {
+	return &memoryIdempotency{records: make(map[string]*repository.IdempotencyRecord), now: time.Now()}
+}
+
+func (m *memoryIdempotency) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+func (m *memoryIdempotency) Claim(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (*repository.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if record, ok := m.records[key]; ok {
+		stale := record.Status == repository.IdempotencyInProgress &&
+			record.Fingerprint == fingerprint && record.LockedAt.Before(m.now.Add(-lease))
+		if !stale {
+			copied := *record
+			return &copied, false, nil
+		}
+	}
+
+	m.tokens++
+	record := &repository.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		Status:      repository.IdempotencyInProgress,
+		LockToken:   fmt.Sprintf("lock-%d", m.tokens),
+		LockedAt:    m.now,
+	}
+	m.records[key] = record
+	copied := *record
+	return &copied, true, nil
+}
+
+func (m *memoryIdempotency) Complete(ctx context.Context, key, lockToken string, responseCode int, contentType string, responseBody []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record := m.records[key]
+	if record.LockToken != lockToken || record.Status != repository.IdempotencyInProgress {
+		return repository.ErrIdempotencyLockLost
+	}
+	record.Status = repository.IdempotencyCompleted
+	record.ResponseCode = responseCode
+	record.ResponseContentType = contentType
+	record.ResponseBody = responseBody
+	return nil
+}
+
+func sendIdempotent(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	req.Header.Set(idempotencyHeader, "key-1")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestIdempotentReplayKeepsContentType(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name: "json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusCreated, map[string]string{"id": "pay_1"})
+			},
+			wantStatus: http.StatusCreated,
+			wantType:   "application/json",
+		},
+		{
+			name: "plain text error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantType:   "text/plain; charset=utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				logger:      zap.NewNop(),
+				idempotency: newMemoryIdempotency(),
+			}
+			calls := 0
+			handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				tt.handler(w, r)
+			})
+
+			first := sendIdempotent(handler, `{"amount":1000}`)
+			replay := sendIdempotent(handler, `{"amount":1000}`)
+
+			if calls != 1 {
+				t.Fatalf("handler ran %d times, want 1", calls)
+			}
+			if replay.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatal("second response was not a replay")
+			}
+			if replay.Code != tt.wantStatus || replay.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("replay = %d %q, want %d %q", replay.Code, replay.Header().Get("Content-Type"), tt.wantStatus, tt.wantType)
+			}
+			if replay.Body.String() != first.Body.String() {
+				t.Errorf("replayed body = %q, want %q", replay.Body.String(), first.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdempotentKeyHeldWithinLease(t *testing.T) {
+	idempotency := newMemoryIdempotency()
+	s := &Server{logger: zap.NewNop(), idempotency: idempotency}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "pay_1"})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sendIdempotent(handler, `{"amount":1000}`)
+	}()
+	<-started
+
+	// A retry while the original is still charging must not run again
+	idempotency.advance(idempotencyLease / 2)
+	if rec := sendIdempotent(handler, `{"amount":1000}`); rec.Code != http.StatusConflict {
+		t.Errorf("retry within the lease: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotentStaleOwnerCannotOverwrite(t *testing.T) {
+	idempotency := newMemoryIdempotency()
+	s := &Server{logger: zap.NewNop(), idempotency: idempotency}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+			writeJSON(w, http.StatusCreated, map[string]string{"id": "stale"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "current"})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sendIdempotent(handler, `{"amount":1000}`)
+	}()
+	<-started
+
+	// The original holder looks crashed once its lease has run out
+	idempotency.advance(idempotencyLease + time.Second)
+	if rec := sendIdempotent(handler, `{"amount":1000}`); rec.Code != http.StatusCreated {
+		t.Fatalf("takeover: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	close(release)
+	<-done
+
+	replay := sendIdempotent(handler, `{"amount":1000}`)
+	if !strings.Contains(replay.Body.String(), "current") {
+		t.Errorf("replayed %q, want the response of the request holding the key", replay.Body.String())
+	}
+}
+
+func TestIdempotentRejectsOversizedBody(t *testing.T) {
+	s := &Server{logger: zap.NewNop(), idempotency: newMemoryIdempotency()}
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called with a truncated body")
+	})
+
+	body := `{"metadata":{"note":"` + strings.Repeat("x", maxRequestBodyBytes) + `"}}`
+	if rec := sendIdempotent(handler, body); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
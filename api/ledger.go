@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/thoraf20/payment-processor/ledger"
+	"go.uber.org/zap"
+)
+
+type balanceResponse struct {
+	Account  string    `json:"account"`
+	Currency string    `json:"currency"`
+	AsOf     time.Time `json:"as_of"`
+	Balance  int64     `json:"balance"`
+}
+
+// handleAccountBalance returns an account's balance in one currency,
+// optionally as of a past point in time
+func (s *Server) handleAccountBalance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeBalance(w, r, mux.Vars(r)["code"])
+	}
+}
+
+func (s *Server) handleMerchantBalance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeBalance(w, r, ledger.MerchantBalance(mux.Vars(r)["id"]).Code)
+	}
+}
+
+func (s *Server) writeBalance(w http.ResponseWriter, r *http.Request, code string) {
+	q := r.URL.Query()
+	currency := strings.ToUpper(q.Get("currency"))
+	if currency == "" {
+		http.Error(w, "currency is required", http.StatusBadRequest)
+		return
+	}
+	asOf, err := parseTime(q.Get("as_of"), "as_of")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if asOf.IsZero() {
+		asOf = time.Now().UTC()
+	}
+
+	balance, err := s.ledger.Balance(r.Context(), code, currency, asOf)
+	if errors.Is(err, ledger.ErrAccountNotFound) {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to get balance", zap.String("account", code), zap.Error(err))
+		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, balanceResponse{Account: code, Currency: currency, AsOf: asOf, Balance: balance})
+}
+
+func (s *Server) handleListPaymentEntries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := s.ledger.Entries(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			s.logger.Error("Failed to list journal entries", zap.Error(err))
+			http.Error(w, "Failed to list journal entries", http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []*ledger.Entry{}
+		}
+		writeJSON(w, http.StatusOK, listResponse{Data: entries})
+	}
+}
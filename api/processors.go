@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/thoraf20/payment-processor/engine"
+	"go.uber.org/zap"
+)
+
+// handleListProcessors reports every registered processor with its health
+func (s *Server) handleListProcessors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, listResponse{Data: s.processors.ProcessorStatuses()})
+	}
+}
+
+// handleSwitchProcessor is the kill switch: a disabled processor takes no
+// new payments on any instance
+func (s *Server) handleSwitchProcessor(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		err := s.rules.SetProcessorEnabled(r.Context(), adminActor(r), id, enabled)
+		if errors.Is(err, engine.ErrUnknownProcessor) {
+			http.Error(w, "Processor not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to switch processor", zap.String("processor", id), zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListBreakers reports each processor's circuit breaker state
+func (s *Server) handleListBreakers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, listResponse{Data: s.processors.BreakerStatuses()})
+	}
+}
+
+// handleProcessorStats reports the rolling statistics scored routing uses
+func (s *Server) handleProcessorStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, listResponse{Data: s.processors.ProcessorStats()})
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thoraf20/payment-processor/reconcile"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+// maxSettlementFileBytes bounds an uploaded settlement report
+const maxSettlementFileBytes = 32 << 20
+
+// handleImportSettlement reconciles a settlement CSV sent as the request
+// body, e.g. POST /admin/reconciliation/reports?processor=stripe
+func (s *Server) handleImportSettlement() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		processorID := q.Get("processor")
+		if _, ok := reconcile.Parsers[processorID]; !ok {
+			http.Error(w, "processor must be one of stripe, flutterwave", http.StatusBadRequest)
+			return
+		}
+		var period reconcile.Period
+		var err error
+		if period.Start, err = parseTime(q.Get("from"), "from"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if period.End, err = parseTime(q.Get("to"), "to"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source := q.Get("source")
+		if source == "" {
+			source = "api upload"
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxSettlementFileBytes)
+		report, err := s.reconciler.Import(r.Context(), processorID, source, body, period)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Settlement file too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if errors.Is(err, reconcile.ErrMissingColumn) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.logger.Error("Failed to reconcile settlement file", zap.String("processor", processorID), zap.Error(err))
+			http.Error(w, "Failed to reconcile settlement file: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, report)
+	}
+}
+
+func (s *Server) handleListReconciliationReports() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := maxPageSize
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxPageSize {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		reports, err := s.reconciler.Reports(r.Context(), q.Get("processor"), limit)
+		if err != nil {
+			s.logger.Error("Failed to list reconciliation reports", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse{Data: reports})
+	}
+}
+
+func (s *Server) handleGetReconciliationReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := s.reconciler.Report(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, repository.ErrReconciliationReportNotFound) {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to get reconciliation report", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/routing"
+	"go.uber.org/zap"
+)
+
+const maxSimulatedPayments = 1000
+
+// simulateRequest takes either a candidate payment or stored payment IDs.
+// Rules, in the routing rules file format, are compared with the live
+// rules; without them only the live rules are evaluated.
+type simulateRequest struct {
+	Payment    *model.Payment `json:"payment"`
+	PaymentIDs []string       `json:"payment_ids"`
+	Rules      *routing.File  `json:"rules"`
+}
+
+// simulatedRoute is one rule set's decision for a payment
+type simulatedRoute struct {
+	Processor string                 `json:"processor,omitempty"`
+	Rule      string                 `json:"rule,omitempty"`
+	Decision  *model.RoutingDecision `json:"decision,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+type simulatedPayment struct {
+	PaymentID string          `json:"payment_id,omitempty"`
+	Live      simulatedRoute  `json:"live"`
+	Candidate *simulatedRoute `json:"candidate,omitempty"`
+	Changed   bool            `json:"changed"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// simulationSummary aggregates a batch: payments per processor under each
+// rule set and how many moved between processors
+type simulationSummary struct {
+	Payments  int            `json:"payments"`
+	Changed   int            `json:"changed"`
+	Live      map[string]int `json:"live"`
+	Candidate map[string]int `json:"candidate,omitempty"`
+	// Moves counts payments by "from -> to" processor
+	Moves map[string]int `json:"moves,omitempty"`
+}
+
+type simulateResponse struct {
+	Results []simulatedPayment `json:"results"`
+	Summary *simulationSummary `json:"summary,omitempty"`
+}
+
+// handleSimulateRouting shows where payments would be routed, under the
+// live rules and a candidate rule set, without calling any processor
+func (s *Server) handleSimulateRouting() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req simulateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if (req.Payment == nil) == (len(req.PaymentIDs) == 0) {
+			http.Error(w, "Send either payment or payment_ids", http.StatusBadRequest)
+			return
+		}
+		if len(req.PaymentIDs) > maxSimulatedPayments {
+			http.Error(w, "Too many payment_ids", http.StatusBadRequest)
+			return
+		}
+
+		var candidate *routing.RuleSet
+		if req.Rules != nil {
+			var err error
+			if candidate, err = req.Rules.Compile(); err != nil {
+				http.Error(w, "Invalid rules: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			if err := s.processors.ValidateRules(candidate.Rules, candidate.Default); err != nil {
+				http.Error(w, "Invalid rules: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		if req.Payment != nil {
+			writeJSON(w, http.StatusOK, simulateResponse{
+				Results: []simulatedPayment{s.simulate(req.Payment, candidate)},
+			})
+			return
+		}
+
+		resp := simulateResponse{Summary: &simulationSummary{Live: map[string]int{}}}
+		if candidate != nil {
+			resp.Summary.Candidate = map[string]int{}
+			resp.Summary.Moves = map[string]int{}
+		}
+		for _, id := range req.PaymentIDs {
+			payment, err := s.paymentEngine.GetPayment(r.Context(), id)
+			if err != nil {
+				if !errors.Is(err, repository.ErrPaymentNotFound) {
+					s.logger.Error("Failed to load payment for simulation", zap.String("payment_id", id), zap.Error(err))
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				resp.Results = append(resp.Results, simulatedPayment{PaymentID: id, Error: "payment not found"})
+				continue
+			}
+
+			result := s.simulate(payment, candidate)
+			resp.Results = append(resp.Results, result)
+			resp.Summary.Payments++
+			resp.Summary.Live[result.Live.Processor]++
+			if result.Candidate != nil {
+				resp.Summary.Candidate[result.Candidate.Processor]++
+				if result.Changed {
+					resp.Summary.Changed++
+					resp.Summary.Moves[result.Live.Processor+" -> "+result.Candidate.Processor]++
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (s *Server) simulate(payment *model.Payment, candidate *routing.RuleSet) simulatedPayment {
+	result := simulatedPayment{
+		PaymentID: payment.ID,
+		Live:      simulatedRouteFor(s.processors.Simulate(payment, nil)),
+	}
+	if candidate != nil {
+		route := simulatedRouteFor(s.processors.Simulate(payment, candidate))
+		result.Candidate = &route
+		result.Changed = route.Processor != result.Live.Processor
+	}
+	return result
+}
+
+func simulatedRouteFor(decision *model.RoutingDecision, err error) simulatedRoute {
+	if err != nil {
+		return simulatedRoute{Error: err.Error()}
+	}
+	return simulatedRoute{
+		Processor: decision.Processors[0],
+		Rule:      decision.Rule,
+		Decision:  decision,
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/routing"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleListRoutingRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.rules.Rules())
+	}
+}
+
+func (s *Server) handleAddRoutingRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rule routing.RuleConfig
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.rules.AddRule(r.Context(), adminActor(r), rule); err != nil {
+			s.writeRoutingError(w, "Failed to add routing rule", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.rules.Rules())
+	}
+}
+
+func (s *Server) handleUpdateRoutingRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rule routing.RuleConfig
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.rules.UpdateRule(r.Context(), adminActor(r), mux.Vars(r)["name"], rule); err != nil {
+			s.writeRoutingError(w, "Failed to update routing rule", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.rules.Rules())
+	}
+}
+
+func (s *Server) handleDeleteRoutingRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.rules.DeleteRule(r.Context(), adminActor(r), mux.Vars(r)["name"]); err != nil {
+			s.writeRoutingError(w, "Failed to delete routing rule", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.rules.Rules())
+	}
+}
+
+// reorderRequest lists every rule name in the new evaluation order
+type reorderRequest struct {
+	Names []string `json:"names"`
+}
+
+func (s *Server) handleReorderRoutingRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req reorderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.rules.ReorderRules(r.Context(), adminActor(r), req.Names); err != nil {
+			s.writeRoutingError(w, "Failed to reorder routing rules", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.rules.Rules())
+	}
+}
+
+func (s *Server) handleListAuditLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := maxPageSize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxPageSize {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		entries, err := s.rules.AuditLog(r.Context(), limit)
+		if err != nil {
+			s.logger.Error("Failed to list audit log", zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, listResponse{Data: entries})
+	}
+}
+
+// writeRoutingError maps rule management errors to HTTP statuses
+func (s *Server) writeRoutingError(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, routing.ErrRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, routing.ErrRuleExists),
+		errors.Is(err, routing.ErrFileManaged),
+		errors.Is(err, repository.ErrRuleSetConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, routing.ErrInvalidRules):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		s.logger.Error(msg, zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -2,12 +2,24 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thoraf20/payment-processor/dispatch"
 	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/ledger"
 	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/reconcile"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/routing"
+	"github.com/thoraf20/payment-processor/vault"
+	"github.com/thoraf20/payment-processor/webhooks"
 	"go.uber.org/zap"
 )
 
@@ -15,20 +27,49 @@ type Server struct {
 	router *mux.Router
 	logger *zap.Logger
 	paymentEngine *engine.PaymentEngine
-
+	processors    *engine.ProcessorRouter
+	idempotency   repository.IdempotencyRepository
+	vault         *vault.Vault
+	webhooks      *webhooks.Receiver
+	dispatcher    *dispatch.Dispatcher
+	ledger        *ledger.Ledger
+	reconciler    *reconcile.Reconciler
+	rules         *routing.Manager
+	adminKeys     map[string]string
 }
 
 // ServeHTTP implements http.Handler.
-func (s *Server) ServeHTTP(http.ResponseWriter, *http.Request) {
-	panic("unimplemented")
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
 }
 
-func NewServer(logger *zap.Logger, paymentEngine *engine.PaymentEngine) *Server {
+func NewServer(
+	logger *zap.Logger,
+	paymentEngine *engine.PaymentEngine,
+	processorRouter *engine.ProcessorRouter,
+	idempotency repository.IdempotencyRepository,
+	cardVault *vault.Vault,
+	webhookReceiver *webhooks.Receiver,
+	dispatcher *dispatch.Dispatcher,
+	paymentLedger *ledger.Ledger,
+	reconciler *reconcile.Reconciler,
+	routingManager *routing.Manager,
+	adminKeys map[string]string,
+) *Server {
 	r := mux.NewRouter()
 	s := &Server{
 		router:        r,
 		logger:        logger,
 		paymentEngine: paymentEngine,
+		processors:    processorRouter,
+		idempotency:   idempotency,
+		vault:         cardVault,
+		webhooks:      webhookReceiver,
+		dispatcher:    dispatcher,
+		ledger:        paymentLedger,
+		reconciler:    reconciler,
+		rules:         routingManager,
+		adminKeys:     adminKeys,
 	}
 	
 	s.routes()
@@ -36,24 +77,86 @@ func NewServer(logger *zap.Logger, paymentEngine *engine.PaymentEngine) *Server
 }
 
 func (s *Server) routes() {
-	s.router.HandleFunc("/payments", s.handleCreatePayment()).Methods("POST")
+	s.router.HandleFunc("/payments", s.idempotent(s.handleCreatePayment())).Methods("POST")
+	s.router.HandleFunc("/payments", s.handleListPayments()).Methods("GET")
 	s.router.HandleFunc("/payments/{id}", s.handleGetPayment()).Methods("GET")
-	s.router.HandleFunc("/payments/{id}/refund", s.handleRefund()).Methods("POST")
+	s.router.HandleFunc("/payments/{id}/capture", s.idempotent(s.handleCapture())).Methods("POST")
+	s.router.HandleFunc("/payments/{id}/void", s.idempotent(s.handleVoid())).Methods("POST")
+	s.router.HandleFunc("/payments/{id}/refund", s.idempotent(s.handleRefund())).Methods("POST")
+	s.router.HandleFunc("/payments/{id}/refunds", s.handleListRefunds()).Methods("GET")
+	s.router.HandleFunc("/webhooks/{provider}", s.handleProviderWebhook()).Methods("POST")
+	s.router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	admin := s.router.PathPrefix("/admin").Subrouter()
+	admin.Use(s.requireAdmin)
+	s.adminRoutes(admin)
+}
+
+// createPaymentRequest is the body accepted by POST /payments. Amounts,
+// fees, attempts and processor references are owned by the server and
+// cannot be set by the client.
+type createPaymentRequest struct {
+	Amount        int64               `json:"amount"`
+	Currency      string              `json:"currency"`
+	PaymentMethod model.PaymentMethod `json:"payment_method"`
+	Metadata      model.Metadata      `json:"metadata"`
+	MerchantID    string              `json:"merchant_id"`
+}
+
+// createPaymentResponse is the created payment. Card tokens are never part
+// of a payment's JSON; the token of a card tokenized by the request is
+// handed back here, once, so the card can be charged again.
+type createPaymentResponse struct {
+	*model.Payment
+	CardToken string `json:"card_token,omitempty"`
 }
 
 // Implement handlers using the paymentEngine
 func (s *Server) handleCreatePayment() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse request
-		var payment model.Payment
-		if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		var req createPaymentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			s.logger.Error("Failed to decode request", zap.Error(err))
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		payment := model.Payment{
+			Amount:        req.Amount,
+			Currency:      req.Currency,
+			PaymentMethod: req.PaymentMethod,
+			Metadata:      req.Metadata,
+			MerchantID:    req.MerchantID,
+		}
+
+		// Swap raw card data for a vault token before anything else sees it
+		cardToken, err := s.tokenizeCard(r.Context(), &payment)
+		if err != nil {
+			if errors.Is(err, vault.ErrInvalidCard) || errors.Is(err, vault.ErrTokenNotFound) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.logger.Error("Failed to tokenize card", zap.Error(err))
+			http.Error(w, "Payment processing failed", http.StatusInternalServerError)
+			return
+		}
 
 		// Process payment
 		createdPayment, err := s.paymentEngine.CreatePayment(r.Context(), &payment)
+		if errors.Is(err, engine.ErrCircuitOpen) {
+			s.logger.Warn("No processor available", zap.Error(err))
+			http.Error(w, "Payment processor unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, engine.ErrNoEligibleProcessor) || errors.Is(err, engine.ErrInvalidAmount) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		var decline *model.DeclineError
+		if errors.As(err, &decline) {
+			writeDecline(w, decline)
+			return
+		}
 		if err != nil {
 			s.logger.Error("Failed to create payment", zap.Error(err))
 			http.Error(w, "Payment processing failed", http.StatusInternalServerError)
@@ -63,18 +166,280 @@ func (s *Server) handleCreatePayment() http.HandlerFunc {
 		// Return response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(createdPayment)
+		json.NewEncoder(w).Encode(createPaymentResponse{Payment: createdPayment, CardToken: cardToken})
+	}
+}
+
+// tokenizeCard replaces the card number and CVV in a card payment method
+// with a vault token and the card's non-sensitive attributes. A card charged
+// again by its token must belong to the payment's merchant. The token is
+// returned when this request created it.
+func (s *Server) tokenizeCard(ctx context.Context, payment *model.Payment) (string, error) {
+	details := payment.PaymentMethod.Details
+	if payment.PaymentMethod.Type != "card" {
+		return "", nil
+	}
+
+	if details["number"] == nil {
+		tokenID, _ := details["token"].(string)
+		if tokenID == "" {
+			return "", fmt.Errorf("%w: card number or token is required", vault.ErrInvalidCard)
+		}
+		// Only what the vault recorded is kept; client-supplied details are
+		// dropped
+		token, err := s.vault.Lookup(ctx, payment.MerchantID, tokenID)
+		if err != nil {
+			return "", err
+		}
+		payment.PaymentMethod.Details = cardDetails(token)
+		return "", nil
+	}
+
+	cvv := details["cvv"]
+	if cvv == nil {
+		cvv = details["cvc"]
+	}
+	card := vault.Card{
+		Number:   fmt.Sprint(details["number"]),
+		ExpMonth: fmt.Sprint(details["exp_month"]),
+		ExpYear:  fmt.Sprint(details["exp_year"]),
+	}
+	if cvv != nil {
+		card.CVV = fmt.Sprint(cvv)
+	}
+
+	token, err := s.vault.Tokenize(ctx, payment.MerchantID, card)
+	if err != nil {
+		return "", err
+	}
+	payment.PaymentMethod.Details = cardDetails(token)
+	return token.Token, nil
+}
+
+// cardDetails are the payment method details kept for a vaulted card. The
+// BIN may be kept alongside the last four digits; routing rules match on
+// its issuing country.
+func cardDetails(token *vault.Token) model.PaymentMethodDetails {
+	return model.PaymentMethodDetails{
+		"bin":         token.Bin,
+		"token":       token.Token,
+		"last4":       token.Last4,
+		"brand":       token.Brand,
+		"exp_month":   token.ExpMonth,
+		"exp_year":    token.ExpYear,
+		"fingerprint": token.Fingerprint,
+	}
+}
+
+func (s *Server) handleListPayments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parsePaymentFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Fetch one extra row to learn whether another page exists
+		limit := filter.Limit
+		filter.Limit++
+
+		payments, err := s.paymentEngine.ListPayments(r.Context(), filter)
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			http.Error(w, "starting_after does not reference a payment", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.writeEngineError(w, "Failed to list payments", err)
+			return
+		}
+
+		hasMore := len(payments) > limit
+		if hasMore {
+			payments = payments[:limit]
+		}
+
+		writeJSON(w, http.StatusOK, listResponse{Data: payments, HasMore: hasMore})
 	}
 }
 
 func (s *Server) handleGetPayment() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get Payment logic
+		payment, err := s.paymentEngine.GetPayment(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			s.writeEngineError(w, "Failed to get payment", err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payment)
+	}
+}
+
+// amountRequest is the body accepted by capture. A missing amount
+// captures the full authorized amount.
+type amountRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+func (s *Server) handleCapture() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req amountRequest
+		if err := decodeOptionalJSON(r, &req); err != nil {
+			s.logger.Error("Failed to decode request", zap.Error(err))
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		payment, err := s.paymentEngine.Capture(r.Context(), mux.Vars(r)["id"], req.Amount)
+		if err != nil {
+			s.writeEngineError(w, "Failed to capture payment", err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payment)
 	}
 }
 
+func (s *Server) handleVoid() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payment, err := s.paymentEngine.Void(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			s.writeEngineError(w, "Failed to void payment", err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payment)
+	}
+}
+
+// refundRequest is the body accepted by the refund endpoint. A missing
+// amount refunds everything not yet refunded.
+type refundRequest struct {
+	Amount int64  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
 func (s *Server) handleRefund() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Payment refund logic
+		var req refundRequest
+		if err := decodeOptionalJSON(r, &req); err != nil {
+			s.logger.Error("Failed to decode request", zap.Error(err))
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		refund, err := s.paymentEngine.Refund(r.Context(), mux.Vars(r)["id"], req.Amount, req.Reason)
+		if err != nil {
+			s.writeEngineError(w, "Failed to refund payment", err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, refund)
+	}
+}
+
+func (s *Server) handleListRefunds() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refunds, err := s.paymentEngine.ListRefunds(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			s.writeEngineError(w, "Failed to list refunds", err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse{Data: refunds})
+	}
+}
+
+// listResponse wraps collections returned by the API
+type listResponse struct {
+	Data    interface{} `json:"data"`
+	HasMore bool        `json:"has_more"`
+}
+
+func (s *Server) handleProviderWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Signatures cover the exact bytes received
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		provider := mux.Vars(r)["provider"]
+		err = s.webhooks.Receive(r.Context(), provider, r.Header, body)
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusOK)
+		case errors.Is(err, webhooks.ErrUnknownProvider):
+			http.Error(w, "Unknown provider", http.StatusNotFound)
+		case errors.Is(err, webhooks.ErrInvalidSignature):
+			s.logger.Warn("Rejected webhook", zap.String("provider", provider), zap.Error(err))
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		default:
+			// A non-2xx response makes the provider redeliver
+			s.logger.Error("Failed to process webhook", zap.String("provider", provider), zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// declineResponse is the body of a 402 for a declined charge
+type declineResponse struct {
+	Error       string `json:"error"`
+	Processor   string `json:"processor"`
+	DeclineCode string `json:"decline_code"`
+	// Soft declines may succeed if retried later
+	Soft bool `json:"soft"`
+}
+
+func writeDecline(w http.ResponseWriter, decline *model.DeclineError) {
+	writeJSON(w, http.StatusPaymentRequired, declineResponse{
+		Error:       "payment declined",
+		Processor:   decline.Processor,
+		DeclineCode: decline.Code,
+		Soft:        decline.Soft,
+	})
+}
+
+// writeEngineError maps engine errors onto HTTP status codes
+func (s *Server) writeEngineError(w http.ResponseWriter, msg string, err error) {
+	var decline *model.DeclineError
+	switch {
+	case errors.As(err, &decline):
+		writeDecline(w, decline)
+	case errors.Is(err, repository.ErrPaymentNotFound):
+		http.Error(w, "Payment not found", http.StatusNotFound)
+	case errors.Is(err, engine.ErrInvalidAmount):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, model.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, engine.ErrCircuitOpen):
+		s.logger.Warn(msg, zap.Error(err))
+		http.Error(w, "Payment processor unavailable", http.StatusServiceUnavailable)
+	case errors.Is(err, engine.ErrProcessor):
+		s.logger.Error(msg, zap.Error(err))
+		http.Error(w, "Payment processor error", http.StatusBadGateway)
+	default:
+		s.logger.Error(msg, zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeOptionalJSON decodes the request body into v, accepting an empty body
+func decodeOptionalJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
 	}
+	return err
 }
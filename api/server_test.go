@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+// stubPayments keeps payments in memory; payment creation only saves them
+type stubPayments struct {
+	repository.PaymentRepository
+	mu       sync.Mutex
+	payments map[string]model.Payment
+}
+
+func (s *stubPayments) Save(ctx context.Context, payment *model.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments[payment.ID] = *payment
+	return nil
+}
+
+// approvingProcessor completes every authorization
+type approvingProcessor struct {
+	engine.PaymentProcessor
+	err error
+}
+
+func (p *approvingProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
+	if p.err != nil {
+		return p.err
+	}
+	payment.ProcessorID = "stub"
+	return payment.TransitionTo(model.StatusCompleted)
+}
+
+func newPaymentServer(processor engine.PaymentProcessor) (*Server, *stubPayments) {
+	payments := &stubPayments{payments: make(map[string]model.Payment)}
+	return &Server{
+		logger:        zap.NewNop(),
+		paymentEngine: engine.NewPaymentEngine(processor, payments, nil),
+	}, payments
+}
+
+func postPayment(s *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.handleCreatePayment()(rec, req)
+	return rec
+}
+
+func TestCreatePaymentIgnoresServerOwnedFields(t *testing.T) {
+	s, payments := newPaymentServer(&approvingProcessor{})
+
+	rec := postPayment(s, `{
+		"amount": 1000,
+		"currency": "USD",
+		"payment_method": {"type": "bank_transfer"},
+		"status": "refunded",
+		"captured_amount": 999999,
+		"refunded_amount": -5000,
+		"processor_fee": -100,
+		"estimated_fee": 7,
+		"processor_payment_id": "pi_forged",
+		"attempts": [{"processor_id": "forged"}],
+		"routing": {"rule": "forged"}
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	var created model.Payment
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	stored := payments.payments[created.ID]
+	if stored.CapturedAmount != 1000 || stored.RefundedAmount != 0 || stored.RefundableAmount() != 1000 {
+		t.Errorf("captured %d refunded %d, want 1000 and 0", stored.CapturedAmount, stored.RefundedAmount)
+	}
+	if stored.ProcessorFee != 0 || stored.EstimatedFee != 0 || stored.ProcessorPaymentID != "" ||
+		len(stored.Attempts) != 0 || stored.Routing != nil {
+		t.Errorf("stored payment kept client-supplied server fields: %+v", stored)
+	}
+}
+
+func TestCreatePaymentRejectsNonPositiveAmount(t *testing.T) {
+	for _, amount := range []string{"0", "-1000"} {
+		s, payments := newPaymentServer(&approvingProcessor{})
+
+		rec := postPayment(s, `{"amount": `+amount+`, "currency": "USD", "payment_method": {"type": "bank_transfer"}}`)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("amount %s: status = %d, want %d", amount, rec.Code, http.StatusUnprocessableEntity)
+		}
+		if len(payments.payments) != 0 {
+			t.Errorf("amount %s: payment was saved", amount)
+		}
+	}
+}
+
+func TestCreatePaymentDeclined(t *testing.T) {
+	s, _ := newPaymentServer(&approvingProcessor{
+		err: &model.DeclineError{Processor: "stripe", Code: "insufficient_funds", Message: "Your card has insufficient funds."},
+	})
+
+	rec := postPayment(s, `{"amount": 1000, "currency": "USD", "payment_method": {"type": "bank_transfer"}}`)
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPaymentRequired)
+	}
+
+	var resp declineResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DeclineCode != "insufficient_funds" || resp.Processor != "stripe" || resp.Soft {
+		t.Errorf("decline response = %+v", resp)
+	}
+}
+
+func TestCreatePaymentRequiresCardNumberOrToken(t *testing.T) {
+	s, payments := newPaymentServer(&approvingProcessor{})
+
+	rec := postPayment(s, `{"amount": 1000, "currency": "USD", "payment_method": {"type": "card", "details": {"authorization_code": "AUTH_x"}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(payments.payments) != 0 {
+		t.Error("payment was saved")
+	}
+}
@@ -13,10 +13,19 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/thoraf20/payment-processor/api"
 	"github.com/thoraf20/payment-processor/config"
+	"github.com/thoraf20/payment-processor/dispatch"
 	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/events"
+	"github.com/thoraf20/payment-processor/fees"
+	"github.com/thoraf20/payment-processor/ledger"
 	"github.com/thoraf20/payment-processor/logger"
+	"github.com/thoraf20/payment-processor/migrations"
 	"github.com/thoraf20/payment-processor/processors"
+	"github.com/thoraf20/payment-processor/reconcile"
 	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/routing"
+	"github.com/thoraf20/payment-processor/vault"
+	"github.com/thoraf20/payment-processor/webhooks"
 	"go.uber.org/zap"
 )
 
@@ -52,33 +61,145 @@ func main() {
 		log.Fatal("Failed to ping database", zap.Error(err))
 	}
 
-	// Initialize processor router
-	router := engine.NewProcessorRouter()
-
-	// Register processors
-	router.RegisterProcessor("stripe", &processors.StripeProcessor{})
-	router.RegisterProcessor("flutterwave", &processors.FlutterwaveProcessor{})
-	// router.RegisterProcessor("paystack", paystackProcessor)
+	// Schema migrations
+	migrator, err := migrations.NewMigrator(db, log)
+	if err != nil {
+		log.Fatal("Failed to load migrations", zap.Error(err))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), migrator, os.Args[2:]); err != nil {
+			log.Fatal("Migration failed", zap.Error(err))
+		}
+		return
+	}
+	if cfg.AutoMigrate {
+		if _, err := migrator.Up(context.Background()); err != nil {
+			log.Fatal("Failed to apply migrations", zap.Error(err))
+		}
+	}
 
 	// Initialize repositories
 	paymentRepo := repository.NewPaymentRepository(db, log)
+	refundRepo := repository.NewRefundRepository(db, log)
+	idempotencyRepo := repository.NewIdempotencyRepository(db, log)
 
 	// Verify the repository implements all methods
 	var _ repository.PaymentRepository = (*repository.DbPaymentRepository)(nil)
 
+	// Settlement reconciliation, also available as a CLI subcommand
+	reconciler := reconcile.NewReconciler(paymentRepo, repository.NewReconciliationRepository(db, log), log)
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := runReconcile(context.Background(), reconciler, os.Args[2:]); err != nil {
+			log.Fatal("Reconciliation failed", zap.Error(err))
+		}
+		return
+	}
+
+	// Initialize card vault
+	cardVault, err := vault.New(db, cfg.VaultKEK, log)
+	if err != nil {
+		log.Fatal("Failed to initialize card vault", zap.Error(err))
+	}
+
 	// Initialize payment processors
-	stripeProcessor := processors.NewStripeProcessor(cfg.StripeAPIKey)
-	// paystackProcessor := processors.NewPaystackProcessor(cfg.PayStackAPIKey, paymentRepo)
+	stripeProcessor := processors.NewStripeProcessor(cfg.StripeAPIKey, paymentRepo, cardVault)
+	flutterwaveProcessor := processors.NewFlutterwaveProcessor(cfg.FlutterWaveAPIKey, paymentRepo, cardVault, log)
+	paystackProcessor := processors.NewPaystackProcessor(cfg.PayStackAPIKey, cfg.PaystackBaseURL, paymentRepo, cardVault, log)
 
-	// Create processor router (if you have multiple processors)
+	// Initialize processor router
 	processorRouter := engine.NewProcessorRouter()
 	processorRouter.Repo = paymentRepo
+	processorRouter.BreakerConfig = engine.BreakerConfig{
+		FailureRatio: cfg.CircuitFailureRatio,
+		MinRequests:  cfg.CircuitMinRequests,
+		Window:       cfg.CircuitWindow,
+		CoolDown:     cfg.CircuitCoolDown,
+	}
+	processorRouter.Scoring = engine.ScoringConfig{
+		Alpha:          cfg.RoutingEWMAAlpha,
+		MinSamples:     cfg.RoutingMinSamples,
+		ApprovalWeight: cfg.RoutingApprovalWeight,
+		ErrorWeight:    cfg.RoutingErrorWeight,
+		LatencyWeight:  cfg.RoutingLatencyWeight,
+	}
+	processorRouter.Logger = log
+	if cfg.FeeScheduleFile != "" {
+		schedule, err := fees.LoadFile(cfg.FeeScheduleFile)
+		if err != nil {
+			log.Fatal("Failed to load fee schedule", zap.Error(err))
+		}
+		processorRouter.Fees = schedule
+	}
+
+	// Register processors
+	if err := processorRouter.RegisterProcessor("stripe", stripeProcessor); err != nil {
+		log.Fatal("Failed to register processor", zap.Error(err))
+	}
+	if err := processorRouter.RegisterProcessor("flutterwave", flutterwaveProcessor); err != nil {
+		log.Fatal("Failed to register processor", zap.Error(err))
+	}
+	if err := processorRouter.RegisterProcessor("paystack", paystackProcessor); err != nil {
+		log.Fatal("Failed to register processor", zap.Error(err))
+	}
+
+	// Routing rules from config replace the built-in ones
+	var rulesWatcher *routing.Watcher
+	if cfg.RoutingRulesFile != "" {
+		rulesWatcher = routing.NewWatcher(cfg.RoutingRulesFile, processorRouter, cfg.RoutingRulesPollInterval, log)
+		if err := rulesWatcher.Load(); err != nil {
+			log.Fatal("Failed to load routing rules", zap.Error(err))
+		}
+	}
+
+	// Rules and processor switches changed through the admin API are
+	// stored and synced across instances
+	routingManager := routing.NewManager(repository.NewRoutingRepository(db, log), processorRouter, cfg.RoutingRulesFile != "", log)
+	if err := routingManager.Sync(context.Background()); err != nil {
+		log.Error("Failed to sync routing configuration", zap.Error(err))
+	}
 
 	// Initialize payment engine
-	paymentEngine := engine.NewPaymentEngine(stripeProcessor, paymentRepo)
+	paymentEngine := engine.NewPaymentEngine(processorRouter, paymentRepo, refundRepo)
+
+	// Outbound merchant webhooks
+	dispatcher := dispatch.NewDispatcher(repository.NewWebhookRepository(db, log), dispatch.Config{
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BaseBackoff:  cfg.WebhookBaseBackoff,
+		MaxBackoff:   cfg.WebhookMaxBackoff,
+		PollInterval: cfg.WebhookPollInterval,
+	}, log)
+
+	// Domain events: the relay publishes the outbox onto the in-process bus
+	eventBus := events.NewBus()
+	eventBus.Subscribe(func(ctx context.Context, event events.Event) error {
+		return dispatcher.Enqueue(ctx, event.ID, event.Type, event.Payload)
+	})
+	if cfg.EventsFile != "" {
+		sink, err := events.NewFileSink(cfg.EventsFile)
+		if err != nil {
+			log.Fatal("Failed to open events file", zap.Error(err))
+		}
+		defer sink.Close()
+		eventBus.Subscribe(sink.Publish)
+	}
+	// Double-entry ledger, posted from the same event stream
+	paymentLedger := ledger.New(db, log)
+	eventBus.Subscribe(ledger.NewPoster(paymentLedger, paymentRepo, log).HandleEvent)
+
+	relay := events.NewRelay(repository.NewOutboxRepository(db, log), eventBus, cfg.OutboxPollInterval, log)
+
+	// Inbound provider webhooks
+	webhookReceiver := webhooks.NewReceiver(repository.NewProviderEventRepository(db, log), paymentEngine, log)
+	webhookReceiver.Register("paystack", webhooks.NewPaystackVerifier(cfg.PayStackAPIKey))
+	if cfg.StripeWebhookSecret != "" {
+		webhookReceiver.Register("stripe", webhooks.NewStripeVerifier(cfg.StripeWebhookSecret, cfg.StripeWebhookTolerance))
+	}
+	if cfg.FlutterwaveWebhookHash != "" {
+		webhookReceiver.Register("flutterwave", webhooks.NewFlutterwaveVerifier(cfg.FlutterwaveWebhookHash))
+	}
 
 	// Initialize HTTP server with all dependencies
-	server := api.NewServer(log, paymentEngine)
+	server := api.NewServer(log, paymentEngine, processorRouter, idempotencyRepo, cardVault, webhookReceiver, dispatcher, paymentLedger, reconciler, routingManager, cfg.AdminKeys())
 
 	// Create HTTP server with timeouts
 	httpServer := &http.Server{
@@ -89,6 +210,16 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Background workers stop when the server shuts down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go dispatcher.Run(workerCtx)
+	go relay.Run(workerCtx)
+	if rulesWatcher != nil {
+		go rulesWatcher.Run(workerCtx)
+	}
+	go routingManager.Run(workerCtx, cfg.RoutingSyncInterval)
+
 	// Start HTTP server in a goroutine
 	go func() {
 		log.Info("Starting server", zap.String("port", cfg.HTTPPort))
@@ -103,6 +234,7 @@ func main() {
 	<-quit
 
 	log.Info("Shutting down server...")
+	stopWorkers()
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
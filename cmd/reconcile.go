@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/reconcile"
+)
+
+const reconcileUsage = "usage: reconcile import -processor stripe|flutterwave [-from RFC3339] [-to RFC3339] FILE | reconcile list [PROCESSOR] | reconcile show ID"
+
+// runReconcile handles the `reconcile` subcommand
+func runReconcile(ctx context.Context, reconciler *reconcile.Reconciler, args []string) error {
+	if len(args) == 0 {
+		return errors.New(reconcileUsage)
+	}
+
+	switch args[0] {
+	case "import":
+		flags := flag.NewFlagSet("reconcile import", flag.ContinueOnError)
+		processorID := flags.String("processor", "", "processor that issued the file")
+		from := flags.String("from", "", "start of the settlement period")
+		to := flags.String("to", "", "end of the settlement period")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *processorID == "" || flags.NArg() != 1 {
+			return errors.New(reconcileUsage)
+		}
+
+		var period reconcile.Period
+		var err error
+		if period.Start, err = parseFlagTime(*from); err != nil {
+			return err
+		}
+		if period.End, err = parseFlagTime(*to); err != nil {
+			return err
+		}
+
+		path := flags.Arg(0)
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		report, err := reconciler.Import(ctx, *processorID, filepath.Base(path), file, period)
+		if err != nil {
+			return err
+		}
+		printReport(report)
+
+	case "list":
+		processorID := ""
+		if len(args) > 1 {
+			processorID = args[1]
+		}
+		reports, err := reconciler.Reports(ctx, processorID, 50)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPROCESSOR\tSOURCE\tCREATED AT\tROWS\tMATCHED\tMISSING\tEXTRA\tAMOUNT\tSTATUS")
+		for _, r := range reports {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n", r.ID, r.ProcessorID, r.Source,
+				r.CreatedAt.Format(time.RFC3339), r.Rows, r.Matched, r.Missing, r.Extra, r.AmountMismatched, r.StatusMismatched)
+		}
+		w.Flush()
+
+	case "show":
+		if len(args) != 2 {
+			return errors.New(reconcileUsage)
+		}
+		report, err := reconciler.Report(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		printReport(report)
+
+	default:
+		return errors.New(reconcileUsage)
+	}
+
+	return nil
+}
+
+func printReport(report *model.ReconciliationReport) {
+	fmt.Printf("report %s: %s %s, %s to %s\n", report.ID, report.ProcessorID, report.Source,
+		report.PeriodStart.Format(time.RFC3339), report.PeriodEnd.Format(time.RFC3339))
+	fmt.Printf("rows %d, matched %d, missing %d, extra %d, amount mismatched %d, status mismatched %d\n",
+		report.Rows, report.Matched, report.Missing, report.Extra, report.AmountMismatched, report.StatusMismatched)
+	if len(report.Discrepancies) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nKIND\tPAYMENT\tREFERENCE\tCURRENCY\tEXPECTED\tACTUAL\tDETAIL")
+	for _, d := range report.Discrepancies {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d %s\t%d %s\t%s\n", d.Kind, d.PaymentID, d.Reference, d.Currency,
+			d.ExpectedAmount, d.ExpectedStatus, d.ActualAmount, d.ActualStatus, d.Detail)
+	}
+	w.Flush()
+}
+
+func parseFlagTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: must be RFC 3339", v)
+	}
+	return t, nil
+}
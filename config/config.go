@@ -2,21 +2,79 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	HTTPPort         			string `envconfig:"HTTP_PORT" default:"8081"`
-	DatabaseURL     			string `envconfig:"DATABASE_URL" required:"true"`
-	StripeAPIKey     			string `envconfig:"STRIPE_API_KEY" required:"true"`
-	FlutterWaveAPIKey     string `envconfig:"FLUTTERWAVE_API_KEY" required:"true"`
-	FlutterwaveBaseURL 		string `envconfig:"FLUTTERWAVE_BASE_URL" default:"https://api.flutterwave.com/v3"`
-	PayStackAPIKey     		string `envconfig:"PAYSTACK_API_KEY" required:"true"`
+	HTTPPort           string `envconfig:"HTTP_PORT" default:"8081"`
+	DatabaseURL        string `envconfig:"DATABASE_URL" required:"true"`
+	StripeAPIKey       string `envconfig:"STRIPE_API_KEY" required:"true"`
+	FlutterWaveAPIKey  string `envconfig:"FLUTTERWAVE_API_KEY" required:"true"`
+	FlutterwaveBaseURL string `envconfig:"FLUTTERWAVE_BASE_URL" default:"https://api.flutterwave.com/v3"`
+	PayStackAPIKey     string `envconfig:"PAYSTACK_API_KEY" required:"true"`
+	PaystackBaseURL    string `envconfig:"PAYSTACK_BASE_URL" default:"https://api.paystack.co"`
 
+	// Inbound webhook secrets. Paystack signs with the API key.
+	StripeWebhookSecret    string        `envconfig:"STRIPE_WEBHOOK_SECRET"`
+	StripeWebhookTolerance time.Duration `envconfig:"STRIPE_WEBHOOK_TOLERANCE" default:"5m"`
+	FlutterwaveWebhookHash string        `envconfig:"FLUTTERWAVE_WEBHOOK_HASH"`
 
-	Environment      			string `envconfig:"ENVIRONMENT" default:"development"`
-	LogLevel         			string `envconfig:"LOG_LEVEL" default:"info"`
+	// VaultKEK is the base64 encoded 32 byte key-encryption key for stored cards
+	VaultKEK string `envconfig:"VAULT_KEK" required:"true"`
+
+	// AutoMigrate applies pending schema migrations on startup
+	AutoMigrate bool `envconfig:"AUTO_MIGRATE" default:"false"`
+
+	// AdminAPIKey enables the /admin API; requests send it as a bearer token.
+	// AdminAPIKeys gives each admin a named key ("alice:key1,bob:key2"), so
+	// the audit log records who made a change.
+	AdminAPIKey  string            `envconfig:"ADMIN_API_KEY"`
+	AdminAPIKeys map[string]string `envconfig:"ADMIN_API_KEYS"`
+
+	// Outbound merchant webhooks
+	WebhookMaxAttempts  int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	WebhookBaseBackoff  time.Duration `envconfig:"WEBHOOK_BASE_BACKOFF" default:"30s"`
+	WebhookMaxBackoff   time.Duration `envconfig:"WEBHOOK_MAX_BACKOFF" default:"6h"`
+	WebhookPollInterval time.Duration `envconfig:"WEBHOOK_POLL_INTERVAL" default:"5s"`
+
+	// Transactional outbox relay. EventsFile additionally appends every
+	// published event to a JSONL file.
+	OutboxPollInterval time.Duration `envconfig:"OUTBOX_POLL_INTERVAL" default:"1s"`
+	EventsFile         string        `envconfig:"EVENTS_FILE"`
+
+	// Per-processor circuit breakers. A circuit opens once FailureRatio of
+	// at least MinRequests calls in Window fail, and allows a trial call
+	// after CoolDown.
+	CircuitFailureRatio float64       `envconfig:"CIRCUIT_FAILURE_RATIO" default:"0.5"`
+	CircuitMinRequests  int           `envconfig:"CIRCUIT_MIN_REQUESTS" default:"10"`
+	CircuitWindow       time.Duration `envconfig:"CIRCUIT_WINDOW" default:"1m"`
+	CircuitCoolDown     time.Duration `envconfig:"CIRCUIT_COOL_DOWN" default:"30s"`
+
+	// Declarative routing rules (YAML or JSON). The file is reloaded when
+	// it changes or on SIGHUP.
+	RoutingRulesFile         string        `envconfig:"ROUTING_RULES_FILE"`
+	RoutingRulesPollInterval time.Duration `envconfig:"ROUTING_RULES_POLL_INTERVAL" default:"10s"`
+
+	// How often each instance picks up rules and processor switches
+	// changed through the admin API
+	RoutingSyncInterval time.Duration `envconfig:"ROUTING_SYNC_INTERVAL" default:"10s"`
+
+	// Scored routing: EWMA smoothing, the samples a segment needs before
+	// its statistics count, and the score's weights
+	RoutingEWMAAlpha      float64 `envconfig:"ROUTING_EWMA_ALPHA" default:"0.1"`
+	RoutingMinSamples     int     `envconfig:"ROUTING_MIN_SAMPLES" default:"20"`
+	RoutingApprovalWeight float64 `envconfig:"ROUTING_APPROVAL_WEIGHT" default:"1"`
+	RoutingErrorWeight    float64 `envconfig:"ROUTING_ERROR_WEIGHT" default:"1"`
+	RoutingLatencyWeight  float64 `envconfig:"ROUTING_LATENCY_WEIGHT" default:"0.1"`
+
+	// FeeSchedule is a YAML or JSON file of processor fees, used by
+	// cheapest routing rules and to estimate each payment's fee
+	FeeScheduleFile string `envconfig:"FEE_SCHEDULE_FILE"`
+
+	Environment string `envconfig:"ENVIRONMENT" default:"development"`
+	LogLevel    string `envconfig:"LOG_LEVEL" default:"info"`
 }
 
 func Load() (*Config, error) {
@@ -27,4 +85,17 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// AdminKeys maps each admin's name to their API key. ADMIN_API_KEY is the
+// key of an admin named "admin".
+func (c *Config) AdminKeys() map[string]string {
+	keys := make(map[string]string, len(c.AdminAPIKeys)+1)
+	if c.AdminAPIKey != "" {
+		keys["admin"] = c.AdminAPIKey
+	}
+	for name, key := range c.AdminAPIKeys {
+		keys[name] = key
+	}
+	return keys
+}
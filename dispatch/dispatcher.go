@@ -0,0 +1,270 @@
+// Package dispatch delivers payment events to merchant webhook endpoints.
+// Deliveries are persisted first and sent by a background worker, which
+// retries failures with exponential backoff and jitter and parks a delivery
+// as dead once it runs out of attempts.
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	SignatureHeader = "Webhook-Signature"
+	batchSize       = 50
+	deliveryTimeout = 10 * time.Second
+	// lease must outlast a batch of deliveries so no other worker resends them
+	lease = batchSize * deliveryTimeout
+)
+
+type Config struct {
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+}
+
+type Dispatcher struct {
+	repo       repository.WebhookRepository
+	cfg        Config
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+func NewDispatcher(repo repository.WebhookRepository, cfg Config, logger *zap.Logger) *Dispatcher {
+	return &Dispatcher{
+		repo: repo,
+		cfg:  cfg,
+		httpClient: &http.Client{
+			Timeout: deliveryTimeout,
+		},
+		logger: logger.With(zap.String("component", "webhook_dispatcher")),
+	}
+}
+
+// CreateEndpoint registers a merchant endpoint with a freshly generated
+// signing secret
+func (d *Dispatcher) CreateEndpoint(ctx context.Context, url string, eventTypes []string) (*model.WebhookEndpoint, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	endpoint := &model.WebhookEndpoint{
+		ID:         uuid.New().String(),
+		URL:        url,
+		Secret:     "whsec_" + hex.EncodeToString(secret),
+		EventTypes: eventTypes,
+		Enabled:    true,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := d.repo.CreateEndpoint(ctx, endpoint); err != nil {
+		return nil, fmt.Errorf("failed to create endpoint: %w", err)
+	}
+	return endpoint, nil
+}
+
+// Enqueue queues an event for every endpoint subscribed to its type
+func (d *Dispatcher) Enqueue(ctx context.Context, eventID, eventType string, data interface{}) error {
+	endpoints, err := d.repo.SubscribedEndpoints(ctx, eventType)
+	if err != nil {
+		return fmt.Errorf("failed to load endpoints: %w", err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":   eventID,
+		"type": eventType,
+		"data": data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, endpoint := range endpoints {
+		delivery := &model.WebhookDelivery{
+			ID:            uuid.New().String(),
+			EndpointID:    endpoint.ID,
+			EventID:       eventID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        model.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := d.repo.CreateDelivery(ctx, delivery); err != nil {
+			return fmt.Errorf("failed to queue delivery: %w", err)
+		}
+	}
+	return nil
+}
+
+// Run sends due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	deliveries, err := d.repo.ClaimDue(ctx, batchSize, lease)
+	if err != nil {
+		d.logger.Error("Failed to claim deliveries", zap.Error(err))
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			d.logger.Error("Failed to record delivery attempt", zap.String("delivery_id", delivery.ID), zap.Error(err))
+		}
+	}
+}
+
+// deliver makes one attempt and schedules the next one if it failed
+func (d *Dispatcher) deliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	endpoint, err := d.repo.GetEndpoint(ctx, delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	attempt := &model.DeliveryAttempt{
+		DeliveryID:  delivery.ID,
+		Attempt:     delivery.Attempts,
+		AttemptedAt: time.Now().UTC(),
+	}
+
+	statusCode, sendErr := d.send(ctx, endpoint, delivery)
+	attempt.StatusCode = statusCode
+	attempt.DurationMs = time.Since(attempt.AttemptedAt).Milliseconds()
+	delivery.UpdatedAt = time.Now().UTC()
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = model.DeliverySucceeded
+		delivery.LastError = ""
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		attempt.Error = sendErr.Error()
+		delivery.Status = model.DeliveryDead
+		delivery.LastError = sendErr.Error()
+		d.logger.Warn("Webhook delivery moved to dead letter",
+			zap.String("delivery_id", delivery.ID),
+			zap.String("endpoint_id", endpoint.ID),
+			zap.Int("attempts", delivery.Attempts),
+			zap.Error(sendErr),
+		)
+	default:
+		attempt.Error = sendErr.Error()
+		delivery.Status = model.DeliveryPending
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = delivery.UpdatedAt.Add(d.backoff(delivery.Attempts))
+	}
+
+	return d.repo.RecordAttempt(context.WithoutCancel(ctx), delivery, attempt)
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint *model.WebhookEndpoint, delivery *model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", delivery.ID)
+	req.Header.Set("Webhook-Event", delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the delay per attempt up to MaxBackoff and picks a random
+// point in the upper half of it so retries from an outage spread out
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.BaseBackoff
+	for i := 1; i < attempt && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	jitter, err := rand.Int(rand.Reader, big.NewInt(half))
+	if err != nil {
+		return delay
+	}
+	return time.Duration(half + jitter.Int64())
+}
+
+// Sign produces the Webhook-Signature header value: the timestamp and an
+// HMAC-SHA256 of "timestamp.payload" under the endpoint secret. Receivers
+// should recompute it and reject stale timestamps.
+func Sign(secret string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) ListEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error) {
+	return d.repo.ListEndpoints(ctx)
+}
+
+func (d *Dispatcher) ListDeliveries(ctx context.Context, filter repository.DeliveryFilter) ([]*model.WebhookDelivery, error) {
+	return d.repo.ListDeliveries(ctx, filter)
+}
+
+func (d *Dispatcher) ListAttempts(ctx context.Context, deliveryID string) ([]*model.DeliveryAttempt, error) {
+	if _, err := d.repo.GetDelivery(ctx, deliveryID); err != nil {
+		return nil, err
+	}
+	return d.repo.ListAttempts(ctx, deliveryID)
+}
+
+// Redeliver schedules a delivery, including a dead one, for immediate sending
+func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID string) (*model.WebhookDelivery, error) {
+	return d.repo.Redeliver(ctx, deliveryID)
+}
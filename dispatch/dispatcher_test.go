@@ -0,0 +1,124 @@
+package dispatch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1","type":"payment.completed"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(payload)))
+	want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	got := Sign("whsec_test", at, payload)
+	if got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+
+	// The timestamp, payload and secret are all covered
+	for name, other := range map[string]string{
+		"timestamp": Sign("whsec_test", at.Add(time.Second), payload),
+		"payload":   Sign("whsec_test", at, append(payload, ' ')),
+		"secret":    Sign("whsec_other", at, payload),
+	} {
+		if other[len("t=1700000000,"):] == got[len("t=1700000000,"):] {
+			t.Errorf("changing the %s did not change the signature", name)
+		}
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := &Dispatcher{cfg: Config{BaseBackoff: time.Second, MaxBackoff: time.Minute}}
+	for attempt := 1; attempt <= 100; attempt++ {
+		want := time.Minute
+		if attempt <= 6 {
+			want = time.Second << (attempt - 1)
+		}
+		for i := 0; i < 10; i++ {
+			if got := d.backoff(attempt); got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+// memoryWebhooks serves a single endpoint and keeps the last recorded attempt
+type memoryWebhooks struct {
+	repository.WebhookRepository
+	endpoint *model.WebhookEndpoint
+	attempt  *model.DeliveryAttempt
+}
+
+func (m *memoryWebhooks) GetEndpoint(ctx context.Context, id string) (*model.WebhookEndpoint, error) {
+	return m.endpoint, nil
+}
+
+func (m *memoryWebhooks) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.DeliveryAttempt) error {
+	m.attempt = attempt
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	status := http.StatusOK
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	repo := &memoryWebhooks{endpoint: &model.WebhookEndpoint{ID: "we_1", URL: server.URL, Secret: "whsec_test"}}
+	d := NewDispatcher(repo, Config{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute}, zap.NewNop())
+	delivery := &model.WebhookDelivery{ID: "wd_1", EndpointID: "we_1", Payload: []byte(`{}`), Status: model.DeliveryPending}
+
+	tests := []struct {
+		status     int
+		wantStatus model.DeliveryStatus
+	}{
+		{http.StatusInternalServerError, model.DeliveryPending},
+		{http.StatusGone, model.DeliveryPending},
+		{http.StatusBadGateway, model.DeliveryDead},
+	}
+	for i, tt := range tests {
+		status = tt.status
+		if err := d.deliver(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Attempts != i+1 || delivery.Status != tt.wantStatus {
+			t.Errorf("attempt %d: delivery = %d attempts, %s, want %s", i+1, delivery.Attempts, delivery.Status, tt.wantStatus)
+		}
+		if repo.attempt.StatusCode != tt.status || repo.attempt.Error == "" {
+			t.Errorf("attempt %d: recorded %+v", i+1, repo.attempt)
+		}
+		if tt.wantStatus == model.DeliveryPending && !delivery.NextAttemptAt.After(delivery.UpdatedAt) {
+			t.Errorf("attempt %d: next attempt not scheduled", i+1)
+		}
+	}
+
+	if !regexp.MustCompile(`^t=\d+,v1=[0-9a-f]{64}$`).MatchString(signature) {
+		t.Errorf("%s = %q", SignatureHeader, signature)
+	}
+
+	retried := &model.WebhookDelivery{ID: "wd_2", EndpointID: "we_1", Payload: []byte(`{}`), LastError: "endpoint responded with 500"}
+	status = http.StatusNoContent
+	if err := d.deliver(context.Background(), retried); err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != model.DeliverySucceeded || retried.LastError != "" {
+		t.Errorf("delivery = %s %q, want succeeded", retried.Status, retried.LastError)
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// ErrNoEligibleProcessor is returned when none of the processors routing
+// could choose can handle the payment, e.g. its currency or payment method
+var ErrNoEligibleProcessor = errors.New("no eligible processor for the payment")
+
+// Payment metadata asking for features only some processors offer
+const (
+	// MetadataCaptureMethod "manual" needs a separate capture
+	MetadataCaptureMethod = "capture_method"
+	// MetadataThreeDSecure "required" needs 3-D Secure
+	MetadataThreeDSecure = "three_d_secure"
+)
+
+// Capabilities describe what a processor can handle. Empty lists and zero
+// amounts mean no restriction.
+type Capabilities struct {
+	Currencies     []string `json:"currencies,omitempty"`
+	PaymentMethods []string `json:"payment_methods,omitempty"`
+	MinAmount      int64    `json:"min_amount,omitempty"`
+	MaxAmount      int64    `json:"max_amount,omitempty"`
+	// SeparateCapture is authorizing now and capturing later
+	SeparateCapture bool `json:"separate_capture"`
+	PartialRefunds  bool `json:"partial_refunds"`
+	ThreeDSecure    bool `json:"three_d_secure"`
+}
+
+// CapabilityProvider is implemented by processors that declare their
+// capabilities. Processors without it are assumed to handle any payment.
+type CapabilityProvider interface {
+	Capabilities() Capabilities
+}
+
+// Supports returns why the payment cannot be handled, or "" when it can
+func (c Capabilities) Supports(payment *model.Payment) string {
+	if len(c.Currencies) > 0 && !containsFold(c.Currencies, payment.Currency) {
+		return fmt.Sprintf("currency %s not supported", payment.Currency)
+	}
+	if len(c.PaymentMethods) > 0 && !containsFold(c.PaymentMethods, payment.PaymentMethod.Type) {
+		return fmt.Sprintf("payment method %q not supported", payment.PaymentMethod.Type)
+	}
+	if c.MinAmount > 0 && payment.Amount < c.MinAmount {
+		return fmt.Sprintf("amount %d below minimum %d", payment.Amount, c.MinAmount)
+	}
+	if c.MaxAmount > 0 && payment.Amount > c.MaxAmount {
+		return fmt.Sprintf("amount %d above maximum %d", payment.Amount, c.MaxAmount)
+	}
+	if strings.EqualFold(payment.Metadata[MetadataCaptureMethod], "manual") && !c.SeparateCapture {
+		return "separate capture not supported"
+	}
+	if strings.EqualFold(payment.Metadata[MetadataThreeDSecure], "required") && !c.ThreeDSecure {
+		return "3-D Secure not supported"
+	}
+	return ""
+}
+
+// ineligible maps each registered processor that cannot handle the
+// payment to the reason. The caller holds r.mu.
+func (r *ProcessorRouter) ineligible(payment *model.Payment) map[string]string {
+	var reasons map[string]string
+	for id, processor := range r.processors {
+		provider, ok := processor.(CapabilityProvider)
+		if !ok {
+			continue
+		}
+		if reason := provider.Capabilities().Supports(payment); reason != "" {
+			if reasons == nil {
+				reasons = make(map[string]string)
+			}
+			reasons[id] = reason
+		}
+	}
+	return reasons
+}
+
+// ineligibleError describes why each processor was ruled out
+func ineligibleError(reasons map[string]string) error {
+	ids := make([]string, 0, len(reasons))
+	for id := range reasons {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	details := make([]string, len(ids))
+	for i, id := range ids {
+		details[i] = id + ": " + reasons[id]
+	}
+	return fmt.Errorf("%w: %s", ErrNoEligibleProcessor, strings.Join(details, "; "))
+}
+
+// checkPartialRefund rejects refunding part of a payment on a processor
+// that only refunds in full
+func (r *ProcessorRouter) checkPartialRefund(payment *model.Payment, refund *model.Refund) error {
+	r.mu.RLock()
+	provider, ok := r.processors[payment.ProcessorID].(CapabilityProvider)
+	r.mu.RUnlock()
+
+	if !ok || provider.Capabilities().PartialRefunds || refund.Amount >= payment.CapturedAmount {
+		return nil
+	}
+	return fmt.Errorf("%w: %s does not support partial refunds", ErrInvalidAmount, payment.ProcessorID)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// capableProcessor declares caps and counts charges and refunds
+type capableProcessor struct {
+	chargingProcessor
+	caps Capabilities
+}
+
+func (p *capableProcessor) Capabilities() Capabilities { return p.caps }
+
+func capabilityPayment(currency string, amount int64, method string, metadata model.Metadata) *model.Payment {
+	return &model.Payment{
+		ID:            "pay_1",
+		Amount:        amount,
+		Currency:      currency,
+		Status:        model.StatusPending,
+		PaymentMethod: model.PaymentMethod{Type: method},
+		Metadata:      metadata,
+	}
+}
+
+func TestCapabilitiesSupports(t *testing.T) {
+	caps := Capabilities{
+		Currencies:     []string{"NGN", "USD"},
+		PaymentMethods: []string{"card"},
+		MinAmount:      100,
+		MaxAmount:      1_000_000,
+	}
+	tests := []struct {
+		name    string
+		caps    Capabilities
+		payment *model.Payment
+		want    string
+	}{
+		{"supported", caps, capabilityPayment("NGN", 1000, "card", nil), ""},
+		{"currency case", caps, capabilityPayment("usd", 1000, "CARD", nil), ""},
+		{"currency", caps, capabilityPayment("GHS", 1000, "card", nil), "currency GHS not supported"},
+		{"method", caps, capabilityPayment("NGN", 1000, "bank_transfer", nil), `payment method "bank_transfer" not supported`},
+		{"minimum", caps, capabilityPayment("NGN", 100, "card", nil), ""},
+		{"below minimum", caps, capabilityPayment("NGN", 99, "card", nil), "amount 99 below minimum 100"},
+		{"maximum", caps, capabilityPayment("NGN", 1_000_000, "card", nil), ""},
+		{"above maximum", caps, capabilityPayment("NGN", 1_000_001, "card", nil), "amount 1000001 above maximum 1000000"},
+		{
+			"manual capture",
+			caps,
+			capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataCaptureMethod: "Manual"}),
+			"separate capture not supported",
+		},
+		{
+			"manual capture supported",
+			Capabilities{SeparateCapture: true},
+			capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataCaptureMethod: "manual"}),
+			"",
+		},
+		{
+			"automatic capture",
+			caps,
+			capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataCaptureMethod: "automatic"}),
+			"",
+		},
+		{
+			"3-D Secure",
+			caps,
+			capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataThreeDSecure: "required"}),
+			"3-D Secure not supported",
+		},
+		{
+			"3-D Secure supported",
+			Capabilities{ThreeDSecure: true},
+			capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataThreeDSecure: "required"}),
+			"",
+		},
+		{"no restrictions", Capabilities{}, capabilityPayment("XOF", 1, "ussd", nil), ""},
+	}
+	for _, tt := range tests {
+		if got := tt.caps.Supports(tt.payment); got != tt.want {
+			t.Errorf("%s: Supports() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func capabilityRouter(t *testing.T, processors map[string]PaymentProcessor, rule []string) *ProcessorRouter {
+	t.Helper()
+	router := NewProcessorRouter()
+	for id, processor := range processors {
+		if err := router.RegisterProcessor(id, processor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := router.ReplaceRules([]RoutingRule{{
+		Name:         "cards",
+		Condition:    func(*model.Payment) bool { return true },
+		ProcessorIDs: rule,
+	}}, rule[0]); err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestRoutingSkipsIneligibleProcessors(t *testing.T) {
+	router := capabilityRouter(t, map[string]PaymentProcessor{
+		"stripe":   &capableProcessor{caps: Capabilities{Currencies: []string{"USD"}}},
+		"paystack": &capableProcessor{caps: Capabilities{Currencies: []string{"NGN"}}},
+		// Processors that declare nothing can handle anything
+		"legacy": &refundCounter{},
+	}, []string{"stripe", "paystack", "legacy"})
+
+	decision, err := router.Simulate(capabilityPayment("NGN", 1000, "card", nil), nil)
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if !reflect.DeepEqual(decision.Processors, []string{"paystack", "legacy"}) {
+		t.Errorf("processors = %v, want [paystack legacy]", decision.Processors)
+	}
+	if want := map[string]string{"stripe": "currency NGN not supported"}; !reflect.DeepEqual(decision.Ineligible, want) {
+		t.Errorf("ineligible = %v, want %v", decision.Ineligible, want)
+	}
+}
+
+func TestNoEligibleProcessor(t *testing.T) {
+	stripe := &capableProcessor{caps: Capabilities{Currencies: []string{"USD"}, ThreeDSecure: true}}
+	paystack := &capableProcessor{caps: Capabilities{Currencies: []string{"NGN"}}}
+	router := capabilityRouter(t, map[string]PaymentProcessor{"stripe": stripe, "paystack": paystack}, []string{"stripe", "paystack"})
+
+	payment := capabilityPayment("NGN", 1000, "card", model.Metadata{MetadataThreeDSecure: "required"})
+	err := router.Authorize(context.Background(), payment)
+	if !errors.Is(err, ErrNoEligibleProcessor) {
+		t.Fatalf("Authorize() error = %v, want %v", err, ErrNoEligibleProcessor)
+	}
+	want := "processor selection failed: no eligible processor for the payment: " +
+		"paystack: 3-D Secure not supported; stripe: currency NGN not supported"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if stripe.calls+paystack.calls != 0 {
+		t.Error("an ineligible processor was called")
+	}
+}
+
+func TestPartialRefundRejected(t *testing.T) {
+	fullOnly := &capableProcessor{caps: Capabilities{PartialRefunds: false}}
+	partial := &capableProcessor{caps: Capabilities{PartialRefunds: true}}
+	router := NewProcessorRouter()
+	router.RegisterProcessor("full", fullOnly)
+	router.RegisterProcessor("partial", partial)
+	router.RegisterProcessor("legacy", &refundCounter{})
+
+	payment := func(id, processorID string) model.Payment {
+		return model.Payment{ID: id, Amount: 1000, CapturedAmount: 1000, Currency: "USD", Status: model.StatusCompleted, ProcessorID: processorID}
+	}
+	router.Repo = newMemoryPayments(payment("pay_full", "full"), payment("pay_partial", "partial"), payment("pay_legacy", "legacy"))
+
+	tests := []struct {
+		paymentID string
+		amount    int64
+		wantErr   bool
+	}{
+		{"pay_full", 500, true},
+		{"pay_full", 1000, false},
+		{"pay_partial", 500, false},
+		{"pay_legacy", 500, false},
+	}
+	for _, tt := range tests {
+		err := router.Refund(context.Background(), &model.Refund{PaymentID: tt.paymentID, Amount: tt.amount})
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidAmount) {
+				t.Errorf("refund %d of %s: error = %v, want %v", tt.amount, tt.paymentID, err, ErrInvalidAmount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("refund %d of %s: error = %v", tt.amount, tt.paymentID, err)
+		}
+	}
+	if got := fullOnly.refunded.Load(); got != 1000 {
+		t.Errorf("full-only processor refunded %d, want only the full refund of 1000", got)
+	}
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/thoraf20/payment-processor/observability"
+	"github.com/thoraf20/payment-processor/retry"
+)
+
+// ErrCircuitOpen is returned instead of calling a processor whose circuit
+// is open
+var ErrCircuitOpen = errors.New("processor circuit is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig tunes every processor's circuit breaker
+type BreakerConfig struct {
+	// FailureRatio of calls in a window that opens the circuit
+	FailureRatio float64
+	// MinRequests in a window before the ratio is considered
+	MinRequests int
+	// Window is how long failures are counted before the counts reset
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before a trial call
+	CoolDown time.Duration
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureRatio: 0.5,
+	MinRequests:  10,
+	Window:       time.Minute,
+	CoolDown:     30 * time.Second,
+}
+
+// BreakerStatus is a breaker's state as reported by the admin API
+type BreakerStatus struct {
+	ProcessorID string       `json:"processor_id"`
+	State       BreakerState `json:"state"`
+	Requests    int          `json:"requests"`
+	Failures    int          `json:"failures"`
+	OpenedAt    *time.Time   `json:"opened_at,omitempty"`
+}
+
+// CircuitBreaker stops calls to a processor that keeps failing. Once open
+// it lets a single trial call through after the cool-down; the trial's
+// outcome closes or re-opens the circuit.
+type CircuitBreaker struct {
+	name string
+	cfg  BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	// probeAt is when the current trial call was let through. A trial
+	// that never reports back is superseded after another cool-down.
+	probeAt time.Time
+}
+
+func NewCircuitBreaker(name string, cfg BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{name: name, cfg: cfg, state: BreakerClosed, windowStart: time.Now()}
+	observability.SetCircuitState(name, string(BreakerClosed))
+	return b
+}
+
+// Allow reports whether a call may be made now. In the half-open state
+// only one trial call is allowed at a time.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cfg.CoolDown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probeAt = time.Now()
+		return true
+	case BreakerHalfOpen:
+		if time.Since(b.probeAt) < b.cfg.CoolDown {
+			return false
+		}
+		b.probeAt = time.Now()
+		return true
+	}
+	return true
+}
+
+// Available reports whether Allow would let a call through, without
+// taking the trial call
+func (b *CircuitBreaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return time.Since(b.openedAt) >= b.cfg.CoolDown
+	case BreakerHalfOpen:
+		return time.Since(b.probeAt) >= b.cfg.CoolDown
+	}
+	return true
+}
+
+// Record feeds a call's outcome to the breaker
+func (b *CircuitBreaker) Record(err error) {
+	failed := countsAsFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.open()
+		} else {
+			b.setState(BreakerClosed)
+			b.reset()
+		}
+	case BreakerClosed:
+		if time.Since(b.windowStart) > b.cfg.Window {
+			b.reset()
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio {
+			b.open()
+		}
+	}
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		ProcessorID: b.name,
+		State:       b.state,
+		Requests:    b.requests,
+		Failures:    b.failures,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(BreakerOpen)
+}
+
+func (b *CircuitBreaker) reset() {
+	b.requests, b.failures = 0, 0
+	b.windowStart = time.Now()
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	observability.SetCircuitState(b.name, string(state))
+}
+
+// countsAsFailure separates an unhealthy processor from one that is up
+// and answering, even if the answer is a decline
+func countsAsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return retry.IsRetryable(err)
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/retry"
+)
+
+func testBreaker() *CircuitBreaker {
+	return NewCircuitBreaker("stripe", BreakerConfig{FailureRatio: 0.5, MinRequests: 4, Window: time.Minute, CoolDown: time.Minute})
+}
+
+// coolDown moves the breaker's clocks back as if the cool-down had passed
+func coolDown(b *CircuitBreaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openedAt = b.openedAt.Add(-b.cfg.CoolDown)
+	b.probeAt = b.probeAt.Add(-b.cfg.CoolDown)
+}
+
+func openBreaker(t *testing.T, b *CircuitBreaker) {
+	t.Helper()
+	for i := 0; i < b.cfg.MinRequests; i++ {
+		b.Record(errUnavailable)
+	}
+	if state := b.Status().State; state != BreakerOpen {
+		t.Fatalf("state = %s, want %s", state, BreakerOpen)
+	}
+}
+
+func TestBreakerOpensAtFailureRatio(t *testing.T) {
+	b := testBreaker()
+
+	// Too few requests to judge, however many failed
+	for i := 0; i < 3; i++ {
+		b.Record(errUnavailable)
+	}
+	if state := b.Status().State; state != BreakerClosed {
+		t.Fatalf("state after 3 failures = %s, want %s", state, BreakerClosed)
+	}
+	b.Record(nil)
+	if state := b.Status().State; state != BreakerOpen {
+		t.Fatalf("state at 3 of 4 failed = %s, want %s", state, BreakerOpen)
+	}
+	if b.Allow() || b.Available() {
+		t.Error("open breaker let a call through")
+	}
+}
+
+func TestBreakerStaysClosedBelowRatio(t *testing.T) {
+	b := testBreaker()
+	for _, err := range []error{errUnavailable, nil, nil, nil, errUnavailable} {
+		b.Record(err)
+	}
+	if state := b.Status().State; state != BreakerClosed {
+		t.Errorf("state at 2 of 5 failed = %s, want %s", state, BreakerClosed)
+	}
+}
+
+func TestBreakerIgnoresHealthyFailures(t *testing.T) {
+	b := testBreaker()
+	healthy := []error{
+		errDeclined,
+		&retry.StatusError{StatusCode: http.StatusBadRequest},
+		context.Canceled,
+		errors.New("invalid currency"),
+	}
+	for _, err := range append(healthy, healthy...) {
+		b.Record(err)
+	}
+	if status := b.Status(); status.State != BreakerClosed || status.Failures != 0 {
+		t.Errorf("status = %+v, want closed with no failures", status)
+	}
+}
+
+func TestBreakerWindowResets(t *testing.T) {
+	b := testBreaker()
+	for i := 0; i < 3; i++ {
+		b.Record(errUnavailable)
+	}
+	b.windowStart = b.windowStart.Add(-2 * b.cfg.Window)
+
+	// The old failures no longer count towards the ratio
+	b.Record(errUnavailable)
+	if status := b.Status(); status.State != BreakerClosed || status.Requests != 1 {
+		t.Errorf("status = %+v, want closed with a fresh window", status)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name  string
+		trial error
+		want  BreakerState
+	}{
+		{"trial succeeds", nil, BreakerClosed},
+		{"trial declined", errDeclined, BreakerClosed},
+		{"trial fails", errUnavailable, BreakerOpen},
+	}
+	for _, tt := range tests {
+		b := testBreaker()
+		openBreaker(t, b)
+		coolDown(b)
+
+		if !b.Available() {
+			t.Fatalf("%s: breaker unavailable after the cool-down", tt.name)
+		}
+		if !b.Allow() {
+			t.Fatalf("%s: trial call not allowed after the cool-down", tt.name)
+		}
+		if state := b.Status().State; state != BreakerHalfOpen {
+			t.Fatalf("%s: state = %s, want %s", tt.name, state, BreakerHalfOpen)
+		}
+		// Only one trial at a time
+		if b.Allow() || b.Available() {
+			t.Errorf("%s: second call allowed during the trial", tt.name)
+		}
+
+		b.Record(tt.trial)
+		status := b.Status()
+		if status.State != tt.want {
+			t.Errorf("%s: state = %s, want %s", tt.name, status.State, tt.want)
+		}
+		if tt.want == BreakerClosed && (status.Requests != 0 || status.Failures != 0 || !b.Allow()) {
+			t.Errorf("%s: closed breaker kept its old counts: %+v", tt.name, status)
+		}
+		if tt.want == BreakerOpen && b.Allow() {
+			t.Errorf("%s: re-opened breaker let a call through before its cool-down", tt.name)
+		}
+	}
+}
+
+func TestBreakerSupersedesLostTrial(t *testing.T) {
+	b := testBreaker()
+	openBreaker(t, b)
+	coolDown(b)
+	if !b.Allow() {
+		t.Fatal("trial call not allowed")
+	}
+
+	// The trial never reports back; another is let through a cool-down later
+	coolDown(b)
+	if !b.Allow() {
+		t.Error("lost trial blocked the breaker")
+	}
+	if b.Allow() {
+		t.Error("two trials allowed at once")
+	}
+}
+
+func TestRouterSkipsOpenCircuit(t *testing.T) {
+	first := &chargingProcessor{err: errUnavailable}
+	second := &chargingProcessor{}
+	router := capabilityRouter(t, map[string]PaymentProcessor{"first": first, "second": second}, []string{"first", "second"})
+	openBreaker(t, router.breakers["first"])
+
+	payment := capabilityPayment("USD", 1000, "card", nil)
+	if err := router.Authorize(context.Background(), payment); err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if first.calls != 0 || second.calls != 1 {
+		t.Errorf("calls = %d and %d, want the open processor skipped", first.calls, second.calls)
+	}
+	if payment.Status != model.StatusCompleted {
+		t.Errorf("status = %s, want %s", payment.Status, model.StatusCompleted)
+	}
+}
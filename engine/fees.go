@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// FeeEstimator predicts what a processor will charge for a payment. It
+// returns false when it has no fee for that processor and payment.
+type FeeEstimator interface {
+	EstimateFee(processorID string, payment *model.Payment) (int64, bool)
+}
+
+// cheapest ranks ids by estimated fee, cheapest first. Processors without
+// an estimate go last; ties keep the rule's order.
+func cheapest(estimator FeeEstimator, ids []string, payment *model.Payment) []model.FeeEstimate {
+	estimates := make([]model.FeeEstimate, len(ids))
+	for i, id := range ids {
+		estimates[i] = model.FeeEstimate{ProcessorID: id}
+		if estimator != nil {
+			estimates[i].Fee, estimates[i].Known = estimator.EstimateFee(id, payment)
+		}
+	}
+	sort.SliceStable(estimates, func(i, j int) bool {
+		a, b := estimates[i], estimates[j]
+		if a.Known != b.Known {
+			return a.Known
+		}
+		return a.Fee < b.Fee
+	})
+	return estimates
+}
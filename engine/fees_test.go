@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// fixedFees estimates the fee listed for each processor, and nothing for
+// processors not listed
+type fixedFees map[string]int64
+
+func (f fixedFees) EstimateFee(processorID string, payment *model.Payment) (int64, bool) {
+	fee, ok := f[processorID]
+	return fee, ok
+}
+
+func known(id string, fee int64) model.FeeEstimate {
+	return model.FeeEstimate{ProcessorID: id, Fee: fee, Known: true}
+}
+
+func unknown(id string) model.FeeEstimate {
+	return model.FeeEstimate{ProcessorID: id}
+}
+
+func TestCheapest(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimator FeeEstimator
+		ids       []string
+		want      []model.FeeEstimate
+	}{
+		{
+			"by fee",
+			fixedFees{"stripe": 320, "paystack": 150, "flutterwave": 200},
+			[]string{"stripe", "paystack", "flutterwave"},
+			[]model.FeeEstimate{known("paystack", 150), known("flutterwave", 200), known("stripe", 320)},
+		},
+		{
+			"unknown fees last",
+			fixedFees{"stripe": 320, "paystack": 0},
+			[]string{"flutterwave", "stripe", "adyen", "paystack"},
+			[]model.FeeEstimate{known("paystack", 0), known("stripe", 320), unknown("flutterwave"), unknown("adyen")},
+		},
+		{
+			"ties keep rule order",
+			fixedFees{"stripe": 100, "paystack": 100, "flutterwave": 100},
+			[]string{"paystack", "stripe", "flutterwave"},
+			[]model.FeeEstimate{known("paystack", 100), known("stripe", 100), known("flutterwave", 100)},
+		},
+		{
+			"no estimator",
+			nil,
+			[]string{"stripe", "paystack"},
+			[]model.FeeEstimate{unknown("stripe"), unknown("paystack")},
+		},
+	}
+	for _, tt := range tests {
+		got := cheapest(tt.estimator, tt.ids, &model.Payment{Amount: 10000, Currency: "NGN"})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: cheapest() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
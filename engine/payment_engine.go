@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,22 +14,37 @@ import (
 type PaymentProcessor interface {
 	Authorize(ctx context.Context, payment *model.Payment) error
 	Capture(ctx context.Context, paymentID string, amount int64) error
-	Refund(ctx context.Context, paymentID string, amount int64) error
+	// Refund submits the refund to the provider and records the provider's
+	// refund ID and status on it
+	Refund(ctx context.Context, refund *model.Refund) error
+	Void(ctx context.Context, paymentID string) error
 }
 
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+	// ErrProcessor wraps failures reported by the payment provider
+	ErrProcessor = errors.New("processor error")
+)
+
 type PaymentEngine struct {
 	processor PaymentProcessor
 	repo      repository.PaymentRepository
+	refunds   repository.RefundRepository
 }
 
-func NewPaymentEngine(processor PaymentProcessor, repo repository.PaymentRepository) *PaymentEngine {
+func NewPaymentEngine(processor PaymentProcessor, repo repository.PaymentRepository, refunds repository.RefundRepository) *PaymentEngine {
 	return &PaymentEngine{
 		processor: processor,
 		repo:      repo,
+		refunds:   refunds,
 	}
 }
 
 func (e *PaymentEngine) CreatePayment(ctx context.Context, payment *model.Payment) (*model.Payment, error) {
+	if payment.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount %d must be positive", ErrInvalidAmount, payment.Amount)
+	}
+
 	payment.ID = uuid.New().String()
 	payment.Status = model.StatusPending
 	payment.CreatedAt = time.Now().UTC()
@@ -39,21 +55,227 @@ func (e *PaymentEngine) CreatePayment(ctx context.Context, payment *model.Paymen
 	}
 	
 	if err := e.processor.Authorize(ctx, payment); err != nil {
-		payment.Status = model.StatusFailed
-		_ = e.repo.Save(ctx, payment)
+		if transitionErr := payment.TransitionTo(model.StatusFailed); transitionErr == nil {
+			_ = e.repo.Save(ctx, payment)
+		}
 		return nil, fmt.Errorf("authorization failed: %w", err)
 	}
 	
-	if payment.Status == model.StatusAuthorized {
-		return payment, nil
+	// The processor has set the resulting status; a payment that is still
+	// pending is waiting on the provider to report the outcome
+	if payment.Status == model.StatusCompleted && payment.CapturedAmount == 0 {
+		payment.CapturedAmount = payment.Amount
 	}
-	
-	payment.Status = model.StatusCompleted
+	payment.UpdatedAt = time.Now().UTC()
 	if err := e.repo.Save(ctx, payment); err != nil {
-		return nil, fmt.Errorf("failed to save completed payment: %w", err)
+		return nil, fmt.Errorf("failed to save authorized payment: %w", err)
 	}
 	
 	return payment, nil
 }
 
-// Implement other methods...
\ No newline at end of file
+func (e *PaymentEngine) GetPayment(ctx context.Context, id string) (*model.Payment, error) {
+	return e.repo.Get(ctx, id)
+}
+
+// Capture captures an authorized payment. An amount of zero captures the
+// full authorized amount.
+func (e *PaymentEngine) Capture(ctx context.Context, id string, amount int64) (*model.Payment, error) {
+	payment, err := e.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount == 0 {
+		amount = payment.Amount
+	}
+	if amount <= 0 || amount > payment.Amount {
+		return nil, fmt.Errorf("%w: capture amount %d must be between 1 and the authorized amount %d", ErrInvalidAmount, amount, payment.Amount)
+	}
+	if err := model.ValidateTransition(payment.Status, model.StatusCaptured); err != nil {
+		return nil, err
+	}
+
+	if err := e.processor.Capture(ctx, id, amount); err != nil {
+		return nil, fmt.Errorf("%w: capture failed: %w", ErrProcessor, err)
+	}
+
+	if err := payment.TransitionTo(model.StatusCaptured); err != nil {
+		return nil, err
+	}
+	payment.CapturedAmount = amount
+
+	if err := e.repo.Save(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to save captured payment: %w", err)
+	}
+	return payment, nil
+}
+
+// Void releases an authorization that has not been captured
+func (e *PaymentEngine) Void(ctx context.Context, id string) (*model.Payment, error) {
+	payment, err := e.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := model.ValidateTransition(payment.Status, model.StatusVoided); err != nil {
+		return nil, err
+	}
+
+	if err := e.processor.Void(ctx, id); err != nil {
+		return nil, fmt.Errorf("%w: void failed: %w", ErrProcessor, err)
+	}
+
+	if err := payment.TransitionTo(model.StatusVoided); err != nil {
+		return nil, err
+	}
+
+	if err := e.repo.Save(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to save voided payment: %w", err)
+	}
+	return payment, nil
+}
+
+// Refund returns captured funds. Several partial refunds may be made until
+// the captured amount is exhausted; an amount of zero refunds the remainder.
+// The amount is reserved on the payment before the processor is called, so
+// concurrent refunds cannot together refund more than was captured.
+func (e *PaymentEngine) Refund(ctx context.Context, id string, amount int64, reason string) (*model.Refund, error) {
+	payment, err := e.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	refundable := payment.RefundableAmount()
+	if amount == 0 {
+		amount = refundable
+	}
+	if amount <= 0 || amount > refundable {
+		return nil, fmt.Errorf("%w: refund amount %d must be between 1 and the refundable amount %d", ErrInvalidAmount, amount, refundable)
+	}
+	status := model.StatusPartiallyRefunded
+	if amount == refundable {
+		status = model.StatusRefunded
+	}
+	if err := model.ValidateTransition(payment.Status, status); err != nil {
+		return nil, err
+	}
+
+	payment, err = e.repo.ReserveRefund(ctx, id, amount)
+	if errors.Is(err, repository.ErrRefundExceedsCaptured) {
+		return nil, fmt.Errorf("%w: refund amount %d exceeds the refundable amount", ErrInvalidAmount, amount)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve refund: %w", err)
+	}
+
+	now := time.Now().UTC()
+	refund := &model.Refund{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		Reason:    reason,
+		Status:    model.RefundPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := e.refunds.Save(ctx, refund); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to save refund: %w", err), e.repo.ReleaseRefund(ctx, id, amount))
+	}
+
+	if err := e.processor.Refund(ctx, refund); err != nil {
+		refund.Status = model.RefundFailed
+		refund.UpdatedAt = time.Now().UTC()
+		_ = e.refunds.Save(ctx, refund)
+		return nil, errors.Join(fmt.Errorf("%w: refund failed: %w", ErrProcessor, err), e.repo.ReleaseRefund(ctx, id, amount))
+	}
+
+	// A refund the provider is still settling keeps its reservation so it
+	// cannot be refunded twice
+	if _, err := e.updateRefundStatus(ctx, id); err != nil {
+		return nil, err
+	}
+
+	refund.UpdatedAt = time.Now().UTC()
+	if err := e.refunds.Save(ctx, refund); err != nil {
+		return nil, fmt.Errorf("failed to save refund: %w", err)
+	}
+	return refund, nil
+}
+
+// updateRefundStatus moves the payment to refunded or partially refunded
+// according to its stored refunded amount. A concurrent refund may move it
+// first, in which case the payment is read again.
+func (e *PaymentEngine) updateRefundStatus(ctx context.Context, id string) (*model.Payment, error) {
+	for attempt := 0; ; attempt++ {
+		payment, err := e.repo.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		status := model.StatusPartiallyRefunded
+		if payment.RefundableAmount() == 0 {
+			status = model.StatusRefunded
+		}
+		if payment.Status == status || payment.Status == model.StatusRefunded {
+			return payment, nil
+		}
+		if err := payment.TransitionTo(status); err != nil {
+			return nil, err
+		}
+
+		err = e.repo.Save(ctx, payment)
+		if err == nil {
+			return payment, nil
+		}
+		if !errors.Is(err, model.ErrInvalidTransition) || attempt == 2 {
+			return nil, fmt.Errorf("failed to save refunded payment: %w", err)
+		}
+	}
+}
+
+// ListRefunds returns the refunds made against a payment, oldest first
+func (e *PaymentEngine) ListRefunds(ctx context.Context, paymentID string) ([]*model.Refund, error) {
+	if _, err := e.repo.Get(ctx, paymentID); err != nil {
+		return nil, err
+	}
+	return e.refunds.ListByPayment(ctx, paymentID)
+}
+
+// ListPayments returns the payments matching the filter
+func (e *PaymentEngine) ListPayments(ctx context.Context, filter repository.PaymentFilter) ([]*model.Payment, error) {
+	return e.repo.List(ctx, filter)
+}
+
+// ApplyProviderEvent moves a payment to the status reported by a provider
+// webhook. Events that imply no status change are ignored.
+func (e *PaymentEngine) ApplyProviderEvent(ctx context.Context, event *model.ProviderEvent) error {
+	if event.Status == "" {
+		return nil
+	}
+
+	payment, err := e.repo.GetByProcessorReference(ctx, event.Provider, event.Reference)
+	if err != nil {
+		return err
+	}
+
+	// A late "succeeded" for a payment we already captured adds nothing
+	if event.Status == model.StatusCompleted && payment.CapturedAmount > 0 {
+		return nil
+	}
+
+	if event.Status == model.StatusCompleted {
+		err = payment.Complete()
+	} else {
+		err = payment.TransitionTo(event.Status)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := e.repo.Save(ctx, payment); err != nil {
+		return fmt.Errorf("failed to save payment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+)
+
+// memoryPayments is an in-memory PaymentRepository with the same
+// guarantees as the database one
+type memoryPayments struct {
+	mu       sync.Mutex
+	payments map[string]model.Payment
+}
+
+func newMemoryPayments(payments ...model.Payment) *memoryPayments {
+	m := &memoryPayments{payments: make(map[string]model.Payment)}
+	for _, p := range payments {
+		m.payments[p.ID] = p
+	}
+	return m
+}
+
+func (m *memoryPayments) Save(ctx context.Context, payment *model.Payment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.payments[payment.ID]
+	if exists {
+		if err := model.ValidateTransition(current.Status, payment.Status); err != nil {
+			return err
+		}
+	}
+	saved := *payment
+	// Like the database, Save leaves the refunded amount alone
+	saved.RefundedAmount = current.RefundedAmount
+	m.payments[payment.ID] = saved
+	return nil
+}
+
+func (m *memoryPayments) Get(ctx context.Context, id string) (*model.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[id]
+	if !ok {
+		return nil, repository.ErrPaymentNotFound
+	}
+	return &payment, nil
+}
+
+func (m *memoryPayments) GetByProcessorReference(ctx context.Context, processorID, reference string) (*model.Payment, error) {
+	return nil, repository.ErrPaymentNotFound
+}
+
+func (m *memoryPayments) List(ctx context.Context, filter repository.PaymentFilter) ([]*model.Payment, error) {
+	return nil, nil
+}
+
+func (m *memoryPayments) ReserveRefund(ctx context.Context, paymentID string, amount int64) (*model.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[paymentID]
+	if !ok {
+		return nil, repository.ErrPaymentNotFound
+	}
+	if payment.RefundedAmount+amount > payment.CapturedAmount {
+		return nil, repository.ErrRefundExceedsCaptured
+	}
+	payment.RefundedAmount += amount
+	m.payments[paymentID] = payment
+	return &payment, nil
+}
+
+func (m *memoryPayments) ReleaseRefund(ctx context.Context, paymentID string, amount int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment := m.payments[paymentID]
+	payment.RefundedAmount -= amount
+	m.payments[paymentID] = payment
+	return nil
+}
+
+func (m *memoryPayments) RecordProcessorFee(ctx context.Context, paymentID string, fee int64) error {
+	return nil
+}
+
+type memoryRefunds struct {
+	mu      sync.Mutex
+	refunds map[string]model.Refund
+}
+
+func (m *memoryRefunds) Save(ctx context.Context, refund *model.Refund) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refunds[refund.ID] = *refund
+	return nil
+}
+
+func (m *memoryRefunds) Get(ctx context.Context, id string) (*model.Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refund, ok := m.refunds[id]
+	if !ok {
+		return nil, repository.ErrRefundNotFound
+	}
+	return &refund, nil
+}
+
+func (m *memoryRefunds) ListByPayment(ctx context.Context, paymentID string) ([]*model.Refund, error) {
+	return nil, nil
+}
+
+// refundCounter counts the refunds that reach the processor
+type refundCounter struct {
+	refunded atomic.Int64
+	fail     bool
+}
+
+func (p *refundCounter) Authorize(ctx context.Context, payment *model.Payment) error { return nil }
+func (p *refundCounter) Capture(ctx context.Context, paymentID string, amount int64) error {
+	return nil
+}
+func (p *refundCounter) Void(ctx context.Context, paymentID string) error { return nil }
+
+func (p *refundCounter) Refund(ctx context.Context, refund *model.Refund) error {
+	// Widen the window between the refundable check and the write
+	time.Sleep(5 * time.Millisecond)
+	if p.fail {
+		return errors.New("provider unavailable")
+	}
+	p.refunded.Add(refund.Amount)
+	refund.Status = model.RefundSucceeded
+	return nil
+}
+
+func capturedPayment() model.Payment {
+	return model.Payment{
+		ID:             "pay_1",
+		Amount:         10000,
+		Currency:       "USD",
+		Status:         model.StatusCaptured,
+		CapturedAmount: 10000,
+	}
+}
+
+func TestConcurrentRefundsNeverExceedCaptured(t *testing.T) {
+	payments := newMemoryPayments(capturedPayment())
+	processor := &refundCounter{}
+	engine := NewPaymentEngine(processor, payments, &memoryRefunds{refunds: make(map[string]model.Refund)})
+
+	const workers = 10
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := engine.Refund(context.Background(), "pay_1", 3000, "")
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, ErrInvalidAmount):
+				t.Errorf("Refund() error = %v, want ErrInvalidAmount", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := succeeded.Load(); got != 3 {
+		t.Errorf("%d refunds succeeded, want 3", got)
+	}
+	if got := processor.refunded.Load(); got != 9000 {
+		t.Errorf("processor refunded %d, want 9000", got)
+	}
+	payment, _ := payments.Get(context.Background(), "pay_1")
+	if payment.RefundedAmount != 9000 {
+		t.Errorf("RefundedAmount = %d, want 9000", payment.RefundedAmount)
+	}
+	if payment.Status != model.StatusPartiallyRefunded {
+		t.Errorf("Status = %s, want %s", payment.Status, model.StatusPartiallyRefunded)
+	}
+}
+
+func TestConcurrentRefundsOfTheRemainder(t *testing.T) {
+	payments := newMemoryPayments(capturedPayment())
+	processor := &refundCounter{}
+	engine := NewPaymentEngine(processor, payments, &memoryRefunds{refunds: make(map[string]model.Refund)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.Refund(context.Background(), "pay_1", 5000, "")
+		}()
+	}
+	wg.Wait()
+
+	if got := processor.refunded.Load(); got != 10000 {
+		t.Errorf("processor refunded %d, want 10000", got)
+	}
+	payment, _ := payments.Get(context.Background(), "pay_1")
+	if payment.RefundedAmount != 10000 || payment.Status != model.StatusRefunded {
+		t.Errorf("payment refunded %d in status %s, want 10000 in %s", payment.RefundedAmount, payment.Status, model.StatusRefunded)
+	}
+}
+
+func TestFailedRefundReleasesReservation(t *testing.T) {
+	payments := newMemoryPayments(capturedPayment())
+	engine := NewPaymentEngine(&refundCounter{fail: true}, payments, &memoryRefunds{refunds: make(map[string]model.Refund)})
+
+	if _, err := engine.Refund(context.Background(), "pay_1", 4000, ""); !errors.Is(err, ErrProcessor) {
+		t.Fatalf("Refund() error = %v, want ErrProcessor", err)
+	}
+	payment, _ := payments.Get(context.Background(), "pay_1")
+	if payment.RefundedAmount != 0 || payment.Status != model.StatusCaptured {
+		t.Errorf("payment refunded %d in status %s, want 0 in %s", payment.RefundedAmount, payment.Status, model.StatusCaptured)
+	}
+}
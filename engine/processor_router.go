@@ -4,19 +4,41 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/observability"
 	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/retry"
+	"github.com/thoraf20/payment-processor/vault"
+	"go.uber.org/zap"
 )
 
+// ErrUnknownProcessor is returned for a processor ID that is not registered
+var ErrUnknownProcessor = errors.New("processor not registered")
+
 // ProcessorRouter directs payments to appropriate processors
 type ProcessorRouter struct {
-	processors       map[string]PaymentProcessor
+	processors map[string]PaymentProcessor
+	breakers   map[string]*CircuitBreaker
+	// disabled processors take no new payments; payments they already
+	// handle can still be captured, voided and refunded
+	disabled         map[string]bool
 	routingRules     []RoutingRule
 	defaultProcessor string
 	mu               sync.RWMutex
 	Repo             repository.PaymentRepository
+	// BreakerConfig applies to processors registered after it is set
+	BreakerConfig BreakerConfig
+	// Scoring tunes processor statistics and scored rules
+	Scoring ScoringConfig
+	// Fees, when set, drive cheapest rules and the payment's estimated fee
+	Fees   FeeEstimator
+	Logger *zap.Logger
+	stats  *statsTracker
 }
 
 // RoutingRule defines criteria for processor selection
@@ -24,13 +46,44 @@ type RoutingRule struct {
 	Name        string
 	Condition   func(p *model.Payment) bool
 	ProcessorID string
-	Priority    int // Higher priority executes first
+	// ProcessorIDs, when set, replaces ProcessorID with an ordered list.
+	// A payment that fails softly on one processor cascades to the next.
+	ProcessorIDs []string
+	// Splits, when set, divide the rule's payments between processors by
+	// weight. The other splits serve as failover for the assigned one.
+	Splits []Split
+	// SplitBy is SplitByPayment (the default) or SplitByCustomer
+	SplitBy string
+	// Strategy orders the rule's processors: StrategyOrdered (the
+	// default), StrategyScored or StrategyCheapest
+	Strategy string
+	Priority int // Higher priority executes first
+	// Expression is the source of Condition for rules loaded from config
+	Expression string
+}
+
+// Processors returns every processor the rule may use, in cascade order
+// for unweighted rules
+func (rule RoutingRule) Processors() []string {
+	if len(rule.Splits) > 0 {
+		ids := make([]string, len(rule.Splits))
+		for i, split := range rule.Splits {
+			ids[i] = split.ProcessorID
+		}
+		return ids
+	}
+	if len(rule.ProcessorIDs) > 0 {
+		return rule.ProcessorIDs
+	}
+	return []string{rule.ProcessorID}
 }
 
 // NewProcessorRouter creates a configured router
 func NewProcessorRouter() *ProcessorRouter {
 	return &ProcessorRouter{
 		processors: make(map[string]PaymentProcessor),
+		breakers:   make(map[string]*CircuitBreaker),
+		disabled:   make(map[string]bool),
 		routingRules: []RoutingRule{
 			{
 				Name:        "fallback",
@@ -40,6 +93,10 @@ func NewProcessorRouter() *ProcessorRouter {
 			},
 		},
 		defaultProcessor: "stripe",
+		BreakerConfig:    DefaultBreakerConfig,
+		Scoring:          DefaultScoringConfig,
+		Logger:           zap.NewNop(),
+		stats:            newStatsTracker(),
 	}
 }
 
@@ -53,17 +110,84 @@ func (r *ProcessorRouter) RegisterProcessor(id string, processor PaymentProcesso
 	}
 
 	r.processors[id] = processor
+	r.breakers[id] = NewCircuitBreaker(id, r.BreakerConfig)
 	return nil
 }
 
+// SetProcessorEnabled switches a processor on or off for new payments
+func (r *ProcessorRouter) SetProcessorEnabled(id string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.processors[id]; !exists {
+		return fmt.Errorf("%w: %q", ErrUnknownProcessor, id)
+	}
+	if enabled {
+		delete(r.disabled, id)
+	} else {
+		r.disabled[id] = true
+	}
+	return nil
+}
+
+// ProcessorStatus is a registered processor's health as reported by the
+// admin API
+type ProcessorStatus struct {
+	ID      string        `json:"id"`
+	Enabled bool          `json:"enabled"`
+	Breaker BreakerStatus `json:"breaker"`
+	// Capabilities are set for processors that declare them
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	// Stats are over all of the processor's traffic, once it has any
+	Stats *ProcessorStats `json:"stats,omitempty"`
+}
+
+// ProcessorStatuses reports every registered processor, by ID
+func (r *ProcessorRouter) ProcessorStatuses() []ProcessorStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	overall := make(map[string]ProcessorStats)
+	for _, s := range r.stats.snapshot() {
+		if s.Segment == "" {
+			overall[s.ProcessorID] = s
+		}
+	}
+
+	statuses := make([]ProcessorStatus, 0, len(r.processors))
+	for id := range r.processors {
+		status := ProcessorStatus{
+			ID:      id,
+			Enabled: !r.disabled[id],
+			Breaker: r.breakers[id].Status(),
+		}
+		if s, ok := overall[id]; ok {
+			status.Stats = &s
+		}
+		if provider, ok := r.processors[id].(CapabilityProvider); ok {
+			capabilities := provider.Capabilities()
+			status.Capabilities = &capabilities
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
 // AddRoutingRule adds a new routing rule
 func (r *ProcessorRouter) AddRoutingRule(rule RoutingRule) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Validate processor exists
-	if _, exists := r.processors[rule.ProcessorID]; !exists {
-		return fmt.Errorf("processor %q not registered", rule.ProcessorID)
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+
+	// Validate processors exist
+	for _, id := range rule.Processors() {
+		if _, exists := r.processors[id]; !exists {
+			return fmt.Errorf("processor %q not registered", id)
+		}
 	}
 
 	// Insert rule in priority order
@@ -83,64 +207,446 @@ func (r *ProcessorRouter) AddRoutingRule(rule RoutingRule) error {
 	return nil
 }
 
+// ReplaceRules swaps in a new rule set in one step. Every processor the
+// rules name must be registered, otherwise the current rules stay in
+// place. An empty defaultProcessor keeps the current default. Payments
+// already being routed finish on the rules they started with.
+func (r *ProcessorRouter) ReplaceRules(rules []RoutingRule, defaultProcessor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkRules(rules, defaultProcessor); err != nil {
+		return err
+	}
+	r.routingRules = sortRules(rules)
+	if defaultProcessor != "" {
+		r.defaultProcessor = defaultProcessor
+	}
+	return nil
+}
+
+// ValidateRules checks a rule set as ReplaceRules would, without applying it
+func (r *ProcessorRouter) ValidateRules(rules []RoutingRule, defaultProcessor string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.checkRules(rules, defaultProcessor)
+}
+
+// checkRules validates a rule set against the registered processors. The
+// caller holds r.mu.
+func (r *ProcessorRouter) checkRules(rules []RoutingRule, defaultProcessor string) error {
+	var unknown []string
+	for _, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			return err
+		}
+		for _, id := range rule.Processors() {
+			if _, exists := r.processors[id]; !exists {
+				unknown = append(unknown, fmt.Sprintf("%q (rule %q)", id, rule.Name))
+			}
+		}
+	}
+	if defaultProcessor != "" {
+		if _, exists := r.processors[defaultProcessor]; !exists {
+			unknown = append(unknown, fmt.Sprintf("%q (default)", defaultProcessor))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("processors not registered: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// sortRules returns a copy of rules in evaluation order
+func sortRules(rules []RoutingRule) []RoutingRule {
+	sorted := make([]RoutingRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	return sorted
+}
+
+func validateRule(rule RoutingRule) error {
+	if err := validateSplits(rule); err != nil {
+		return err
+	}
+	return validateStrategy(rule)
+}
+
+// DefaultProcessor is tried when no rule matches
+func (r *ProcessorRouter) DefaultProcessor() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaultProcessor
+}
+
+// Rules returns the routing rules in evaluation order
+func (r *ProcessorRouter) Rules() []RoutingRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rules := make([]RoutingRule, len(r.routingRules))
+	copy(rules, r.routingRules)
+	return rules
+}
+
 // GetProcessor selects the appropriate processor
 func (r *ProcessorRouter) GetProcessor(payment *model.Payment) (PaymentProcessor, error) {
+	_, processor, err := r.selectProcessor(payment)
+	return processor, err
+}
+
+// selectProcessor returns the first processor route would try, together
+// with the ID it was registered under
+func (r *ProcessorRouter) selectProcessor(payment *model.Payment) (string, PaymentProcessor, error) {
+	route, err := r.route(payment)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id := route.Processors[0]
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return id, r.processors[id], nil
+}
+
+// route evaluates the routing rules in priority order and returns the
+// matching rule and its processors in cascade order. Weighted rules put the
+// payment's assigned split first, scored rules rank their processors by
+// recent performance and cheapest rules by estimated fee. Processors with
+// an open circuit are passed over, falling through to the next matching
+// rule when none of a rule's processors is available. The first processor
+// returned has been admitted by its breaker.
+func (r *ProcessorRouter) route(payment *model.Payment) (*model.RoutingDecision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.evaluate(payment, r.routingRules, r.defaultProcessor, (*CircuitBreaker).Allow)
+}
+
+// RuleSet is a complete set of routing rules with its default processor
+type RuleSet struct {
+	Default string
+	Rules   []RoutingRule
+}
+
+// Simulate routes the payment as route would, without calling any
+// processor or taking a breaker's trial call. A nil candidate evaluates the
+// live rules; otherwise the candidate's rules replace them, even when it
+// has none, with its default or the live default when that is empty.
+func (r *ProcessorRouter) Simulate(payment *model.Payment, candidate *RuleSet) (*model.RoutingDecision, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	if candidate == nil {
+		return r.evaluate(payment, r.routingRules, r.defaultProcessor, (*CircuitBreaker).Available)
+	}
+	if err := r.checkRules(candidate.Rules, candidate.Default); err != nil {
+		return nil, err
+	}
+	defaultProcessor := candidate.Default
+	if defaultProcessor == "" {
+		defaultProcessor = r.defaultProcessor
+	}
+	return r.evaluate(payment, sortRules(candidate.Rules), defaultProcessor, (*CircuitBreaker).Available)
+}
+
+// evaluate runs rules against the payment. admit decides whether a
+// processor's breaker lets it take the payment. The caller holds r.mu.
+func (r *ProcessorRouter) evaluate(payment *model.Payment, rules []RoutingRule, defaultProcessor string, admit func(*CircuitBreaker) bool) (*model.RoutingDecision, error) {
+	// Processors that cannot handle the payment are ruled out before any
+	// rule is looked at
+	ineligible := r.ineligible(payment)
+	if len(ineligible) > 0 && len(ineligible) == len(r.processors) {
+		return nil, ineligibleError(ineligible)
+	}
+	skip := func(id string) bool {
+		_, cannot := ineligible[id]
+		return r.disabled[id] || cannot
+	}
+
+	circuitOpen := false
+	available := func(ids []string) []string {
+		for i, id := range ids {
+			if _, exists := r.processors[id]; !exists || skip(id) {
+				continue
+			}
+			if !admit(r.breakers[id]) {
+				circuitOpen = true
+				continue
+			}
+			// Disabled and ineligible processors are not cascaded to either
+			rest := []string{id}
+			for _, next := range ids[i+1:] {
+				if !skip(next) {
+					rest = append(rest, next)
+				}
+			}
+			return rest
+		}
+		return nil
+	}
+
 	// Check rules in priority order
-	for _, rule := range r.routingRules {
-		if rule.Condition(payment) {
-			if processor, exists := r.processors[rule.ProcessorID]; exists {
-				return processor, nil
+	for _, rule := range rules {
+		if !rule.Condition(payment) {
+			continue
+		}
+
+		decision := &model.RoutingDecision{Rule: rule.Name, Strategy: StrategyOrdered}
+		ids := rule.Processors()
+		switch {
+		case len(rule.Splits) > 0:
+			ids = rule.splitOrder(payment)
+		case rule.Strategy == StrategyScored:
+			decision.Strategy = StrategyScored
+			decision.Scores = r.stats.score(ids, payment, r.Scoring)
+			ids = make([]string, len(decision.Scores))
+			for i, score := range decision.Scores {
+				ids[i] = score.ProcessorID
+			}
+		case rule.Strategy == StrategyCheapest:
+			decision.Strategy = StrategyCheapest
+			decision.Fees = cheapest(r.Fees, ids, payment)
+			ids = make([]string, len(decision.Fees))
+			for i, estimate := range decision.Fees {
+				ids[i] = estimate.ProcessorID
 			}
 		}
+
+		if ids = available(ids); ids != nil {
+			decision.Processors = ids
+			decision.Ineligible = ineligible
+			if len(rule.Splits) > 0 {
+				decision.Split = ids[0]
+			}
+			return decision, nil
+		}
 	}
 
 	// Fallback to default
-	if defaultProc, exists := r.processors[r.defaultProcessor]; exists {
-		return defaultProc, nil
+	if ids := available([]string{defaultProcessor}); ids != nil {
+		return &model.RoutingDecision{Rule: "default", Strategy: StrategyOrdered, Processors: ids, Ineligible: ineligible}, nil
 	}
 
+	if circuitOpen {
+		return nil, fmt.Errorf("%w: every matching processor is unavailable", ErrCircuitOpen)
+	}
+	if len(ineligible) > 0 {
+		return nil, ineligibleError(ineligible)
+	}
 	return nil, errors.New("no suitable processor available")
 }
 
-// Implement PaymentProcessor interface by routing calls
-func (r *ProcessorRouter) Authorize(ctx context.Context, payment *model.Payment) error {
-	processor, err := r.GetProcessor(payment)
-	if err != nil {
-		return fmt.Errorf("processor selection failed: %w", err)
-	}
+// BreakerStatuses reports every processor's circuit breaker, by ID
+func (r *ProcessorRouter) BreakerStatuses() []BreakerStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	return processor.Authorize(ctx, payment)
+	statuses := make([]BreakerStatus, 0, len(r.breakers))
+	for _, breaker := range r.breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ProcessorID < statuses[j].ProcessorID
+	})
+	return statuses
 }
 
-func (r *ProcessorRouter) Capture(ctx context.Context, paymentID string, amount int64) error {
-	// Need to get original payment to determine processor
+// recordedProcessor returns the processor that authorized the payment.
+// Follow-up operations must never be re-routed, so they fail fast while
+// its circuit is open.
+func (r *ProcessorRouter) recordedProcessor(ctx context.Context, paymentID string) (PaymentProcessor, *CircuitBreaker, error) {
 	payment, err := r.Repo.Get(ctx, paymentID)
 	if err != nil {
-		return fmt.Errorf("failed to get payment: %w", err)
+		return nil, nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	return r.processorFor(payment)
+}
+
+// processorFor returns the processor recorded on the payment
+func (r *ProcessorRouter) processorFor(payment *model.Payment) (PaymentProcessor, *CircuitBreaker, error) {
+	if payment.ProcessorID == "" {
+		return nil, nil, fmt.Errorf("payment %s has no recorded processor", payment.ID)
 	}
 
-	processor, err := r.GetProcessor(payment)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	processor, exists := r.processors[payment.ProcessorID]
+	if !exists {
+		return nil, nil, fmt.Errorf("processor %q that handled payment %s is no longer registered", payment.ProcessorID, payment.ID)
+	}
+	breaker := r.breakers[payment.ProcessorID]
+	if !breaker.Allow() {
+		return nil, nil, fmt.Errorf("%w: %s", ErrCircuitOpen, payment.ProcessorID)
+	}
+	return processor, breaker, nil
+}
+
+// Implement PaymentProcessor interface by routing calls. Authorize
+// cascades down the matched rule's processors while they fail softly, and
+// records every attempt on the payment.
+func (r *ProcessorRouter) Authorize(ctx context.Context, payment *model.Payment) (err error) {
+	route, err := r.route(payment)
 	if err != nil {
 		return fmt.Errorf("processor selection failed: %w", err)
 	}
+	payment.Routing = route
+	r.logDecision(payment, route)
+
+	// The vault hands out the CVV once; the session keeps the card for
+	// every processor the authorization cascades to
+	ctx, releaseCards := vault.WithSession(ctx)
+	defer releaseCards()
+	if route.Split != "" {
+		defer func() {
+			observability.ObserveSplitPayment(route.Rule, route.Split, authorizeOutcome(payment, err))
+		}()
+	}
+
+	for i, id := range route.Processors {
+		r.mu.RLock()
+		processor, exists := r.processors[id]
+		breaker := r.breakers[id]
+		r.mu.RUnlock()
 
-	return processor.Capture(ctx, paymentID, amount)
+		// route has already admitted the first processor
+		if !exists || (i > 0 && !breaker.Allow()) {
+			continue
+		}
+
+		// Clear the references a previous processor left behind
+		if len(payment.Attempts) > 0 {
+			payment.ExternalID = ""
+			payment.ProcessorPaymentID = ""
+		}
+		payment.ProcessorID = id
+		payment.EstimatedFee = 0
+		if r.Fees != nil {
+			payment.EstimatedFee, _ = r.Fees.EstimateFee(id, payment)
+		}
+
+		start := time.Now()
+		err = processor.Authorize(ctx, payment)
+		breaker.Record(err)
+		r.stats.record(id, payment, time.Since(start), err, r.Scoring.Alpha)
+		payment.Attempts = append(payment.Attempts, newAttempt(id, start, err))
+
+		if err == nil || !canCascade(err) {
+			return err
+		}
+	}
+	return err
 }
 
-func (r *ProcessorRouter) Refund(ctx context.Context, paymentID string, amount int64) error {
-	payment, err := r.Repo.Get(ctx, paymentID)
+func (r *ProcessorRouter) logDecision(payment *model.Payment, decision *model.RoutingDecision) {
+	fields := []zap.Field{
+		zap.String("payment_id", payment.ID),
+		zap.String("rule", decision.Rule),
+		zap.String("strategy", decision.Strategy),
+		zap.Strings("processors", decision.Processors),
+	}
+	if decision.Split != "" {
+		fields = append(fields, zap.String("split", decision.Split))
+	}
+	if len(decision.Scores) > 0 {
+		fields = append(fields, zap.Any("scores", decision.Scores))
+	}
+	if len(decision.Fees) > 0 {
+		fields = append(fields, zap.Any("fees", decision.Fees))
+	}
+	r.Logger.Info("Routing decision", fields...)
+}
+
+// ProcessorStats reports the rolling statistics behind scored routing
+func (r *ProcessorRouter) ProcessorStats() []ProcessorStats {
+	return r.stats.snapshot()
+}
 
+// canCascade reports whether a failed authorization may be tried on
+// another processor: soft declines, and failures where the processor
+// certainly did not charge the card, i.e. the connection was never made or
+// it answered 429. Hard declines never cascade, and neither do timeouts or
+// 5xx responses, as the first processor may have charged.
+func canCascade(err error) bool {
+	var decline *model.DeclineError
+	if errors.As(err, &decline) {
+		return decline.Soft
+	}
+
+	c := retry.Classify(err)
+	return c.Retryable && c.Unsent
+}
+
+// authorizeOutcome labels an authorization result for metrics
+func authorizeOutcome(payment *model.Payment, err error) string {
+	var decline *model.DeclineError
+	switch {
+	case err == nil && payment.Status == model.StatusPending:
+		return "pending"
+	case err == nil:
+		return "approved"
+	case errors.As(err, &decline):
+		return "declined"
+	default:
+		return "error"
+	}
+}
+
+func newAttempt(processorID string, start time.Time, err error) model.ProcessorAttempt {
+	attempt := model.ProcessorAttempt{
+		ProcessorID: processorID,
+		Succeeded:   err == nil,
+		DurationMs:  time.Since(start).Milliseconds(),
+		AttemptedAt: start.UTC(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		var decline *model.DeclineError
+		if errors.As(err, &decline) {
+			attempt.DeclineCode = decline.Code
+		}
+	}
+	return attempt
+}
+
+func (r *ProcessorRouter) Capture(ctx context.Context, paymentID string, amount int64) error {
+	processor, breaker, err := r.recordedProcessor(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	err = processor.Capture(ctx, paymentID, amount)
+	breaker.Record(err)
+	return err
+}
+
+func (r *ProcessorRouter) Refund(ctx context.Context, refund *model.Refund) error {
+	payment, err := r.Repo.Get(ctx, refund.PaymentID)
 	if err != nil {
 		return fmt.Errorf("failed to get payment: %w", err)
 	}
+	if err := r.checkPartialRefund(payment, refund); err != nil {
+		return err
+	}
+	processor, breaker, err := r.processorFor(payment)
+	if err != nil {
+		return err
+	}
 
-	processor, err := r.GetProcessor(payment)
+	err = processor.Refund(ctx, refund)
+	breaker.Record(err)
+	return err
+}
+
+func (r *ProcessorRouter) Void(ctx context.Context, paymentID string) error {
+	processor, breaker, err := r.recordedProcessor(ctx, paymentID)
 	if err != nil {
-		return fmt.Errorf("processor selection failed: %w", err)
+		return err
 	}
 
-	return processor.Refund(ctx, paymentID, amount)
+	err = processor.Void(ctx, paymentID)
+	breaker.Record(err)
+	return err
 }
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/retry"
+)
+
+func simulationRouter(t *testing.T) *ProcessorRouter {
+	t.Helper()
+
+	router := NewProcessorRouter()
+	for _, id := range []string{"stripe", "paystack"} {
+		if err := router.RegisterProcessor(id, &refundCounter{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+func TestSimulateLiveRules(t *testing.T) {
+	router := simulationRouter(t)
+
+	decision, err := router.Simulate(&model.Payment{Amount: 100, Currency: "USD"}, nil)
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if decision.Rule != "fallback" || decision.Processors[0] != "stripe" {
+		t.Errorf("Simulate() = rule %q to %v, want fallback to stripe", decision.Rule, decision.Processors)
+	}
+}
+
+func TestSimulateCandidateWithoutRules(t *testing.T) {
+	router := simulationRouter(t)
+
+	// A candidate with no rules must not fall back to the live rules
+	decision, err := router.Simulate(&model.Payment{Amount: 100, Currency: "USD"}, &RuleSet{Default: "paystack"})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if decision.Rule != "default" || decision.Processors[0] != "paystack" {
+		t.Errorf("Simulate() = rule %q to %v, want default to paystack", decision.Rule, decision.Processors)
+	}
+}
+
+func TestSimulateCandidateUsesLiveDefault(t *testing.T) {
+	router := simulationRouter(t)
+
+	decision, err := router.Simulate(&model.Payment{Amount: 100, Currency: "USD"}, &RuleSet{})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if decision.Rule != "default" || decision.Processors[0] != "stripe" {
+		t.Errorf("Simulate() = rule %q to %v, want default to stripe", decision.Rule, decision.Processors)
+	}
+}
+
+func TestSimulateRejectsUnknownProcessor(t *testing.T) {
+	router := simulationRouter(t)
+
+	if _, err := router.Simulate(&model.Payment{}, &RuleSet{Default: "adyen"}); err == nil {
+		t.Error("Simulate() error = nil, want unknown processor error")
+	}
+}
+
+func TestCanCascade(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"soft decline", &model.DeclineError{Code: "try_again_later", Soft: true}, true},
+		{"hard decline", &model.DeclineError{Code: "insufficient_funds"}, false},
+		{"rate limited", fmt.Errorf("charge: %w", &retry.StatusError{StatusCode: http.StatusTooManyRequests}), true},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"server error", fmt.Errorf("charge: %w", &retry.StatusError{StatusCode: http.StatusInternalServerError}), false},
+		{"bad gateway", &retry.StatusError{StatusCode: http.StatusBadGateway}, false},
+		{"timeout", context.DeadlineExceeded, false},
+		{"bad request", &retry.StatusError{StatusCode: http.StatusBadRequest}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canCascade(tt.err); got != tt.want {
+				t.Errorf("canCascade(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// chargingProcessor counts authorizations and fails them with err
+type chargingProcessor struct {
+	refundCounter
+	err   error
+	calls int
+}
+
+func (p *chargingProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
+	p.calls++
+	if p.err != nil {
+		return p.err
+	}
+	return payment.Complete()
+}
+
+func TestServerErrorDoesNotCascade(t *testing.T) {
+	first := &chargingProcessor{err: &retry.StatusError{StatusCode: http.StatusServiceUnavailable}}
+	second := &chargingProcessor{}
+
+	router := NewProcessorRouter()
+	router.RegisterProcessor("first", first)
+	router.RegisterProcessor("second", second)
+	if err := router.ReplaceRules([]RoutingRule{{
+		Name:         "cascade",
+		Condition:    func(*model.Payment) bool { return true },
+		ProcessorIDs: []string{"first", "second"},
+	}}, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := router.Authorize(context.Background(), &model.Payment{ID: "pay_1", Amount: 1000, Currency: "USD", Status: model.StatusPending})
+	if err == nil {
+		t.Fatal("Authorize() succeeded, want the 503")
+	}
+	if second.calls != 0 {
+		t.Errorf("cascaded to the second processor after a 503 on the charge")
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// Split sends a share of a rule's payments to one processor
+type Split struct {
+	ProcessorID string
+	Weight      int
+}
+
+// What a weighted rule hashes to assign payments. Every payment for the
+// same order, or of the same customer, lands on the same processor; see
+// splitKey.
+const (
+	SplitByPayment  = "payment"
+	SplitByCustomer = "customer"
+)
+
+// validateSplits checks a weighted rule's splits
+func validateSplits(rule RoutingRule) error {
+	if len(rule.Splits) == 0 {
+		return nil
+	}
+	switch rule.SplitBy {
+	case "", SplitByPayment, SplitByCustomer:
+	default:
+		return fmt.Errorf("rule %q: unknown split key %q", rule.Name, rule.SplitBy)
+	}
+
+	seen := make(map[string]bool)
+	total := 0
+	for _, split := range rule.Splits {
+		if split.Weight < 0 {
+			return fmt.Errorf("rule %q: negative weight for %q", rule.Name, split.ProcessorID)
+		}
+		if seen[split.ProcessorID] {
+			return fmt.Errorf("rule %q: processor %q split twice", rule.Name, split.ProcessorID)
+		}
+		seen[split.ProcessorID] = true
+		total += split.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("rule %q: split weights add up to zero", rule.Name)
+	}
+	return nil
+}
+
+// splitOrder assigns the payment to one of the rule's splits and returns
+// it first, followed by the other splits by weight as failover
+func (rule RoutingRule) splitOrder(payment *model.Payment) []string {
+	total := 0
+	for _, split := range rule.Splits {
+		total += split.Weight
+	}
+
+	// Rules hash independently so that one rule's split does not decide
+	// another's
+	h := fnv.New32a()
+	h.Write([]byte(rule.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(splitKey(rule.SplitBy, payment)))
+	point := int(h.Sum32() % uint32(total))
+
+	assigned := 0
+	for i, split := range rule.Splits {
+		if point < split.Weight {
+			assigned = i
+			break
+		}
+		point -= split.Weight
+	}
+
+	rest := make([]Split, 0, len(rule.Splits)-1)
+	rest = append(rest, rule.Splits[:assigned]...)
+	rest = append(rest, rule.Splits[assigned+1:]...)
+	sort.SliceStable(rest, func(i, j int) bool { return rest[i].Weight > rest[j].Weight })
+
+	ids := []string{rule.Splits[assigned].ProcessorID}
+	for _, split := range rest {
+		ids = append(ids, split.ProcessorID)
+	}
+	return ids
+}
+
+// splitKey is what a payment is hashed on. By payment, it is the merchant's
+// metadata order_id, so a client retrying an order as a new payment stays
+// on the same processor. Without one the payment ID is used, which a retry
+// does not share. A customer is identified by metadata customer_id, then
+// email, falling back the same way.
+func splitKey(splitBy string, payment *model.Payment) string {
+	if splitBy == SplitByCustomer {
+		if id := payment.Metadata["customer_id"]; id != "" {
+			return "customer:" + id
+		}
+		if email := payment.Metadata["email"]; email != "" {
+			return "email:" + email
+		}
+	}
+	if order := payment.Metadata["order_id"]; order != "" {
+		return "order:" + payment.MerchantID + ":" + order
+	}
+	return "payment:" + payment.ID
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+func splitRule(splitBy string, weights ...int) RoutingRule {
+	rule := RoutingRule{Name: "trial", SplitBy: splitBy}
+	for i, weight := range weights {
+		rule.Splits = append(rule.Splits, Split{ProcessorID: fmt.Sprintf("p%d", i), Weight: weight})
+	}
+	return rule
+}
+
+func TestSplitDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"90/10", []int{90, 10}},
+		{"even", []int{1, 1, 1}},
+		{"uneven", []int{50, 30, 20}},
+	}
+	const payments = 20000
+	for _, tt := range tests {
+		rule := splitRule(SplitByPayment, tt.weights...)
+		counts := make(map[string]int)
+		for i := 0; i < payments; i++ {
+			counts[rule.splitOrder(&model.Payment{ID: fmt.Sprintf("pay_%d", i)})[0]]++
+		}
+
+		total := 0
+		for _, weight := range tt.weights {
+			total += weight
+		}
+		for i, weight := range tt.weights {
+			want := float64(weight) / float64(total)
+			got := float64(counts[fmt.Sprintf("p%d", i)]) / payments
+			if math.Abs(got-want) > 0.02 {
+				t.Errorf("%s: p%d got %.3f of payments, want %.3f", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestSplitZeroWeightOnlyFailover(t *testing.T) {
+	rule := splitRule(SplitByPayment, 0, 1)
+	for i := 0; i < 1000; i++ {
+		order := rule.splitOrder(&model.Payment{ID: fmt.Sprintf("pay_%d", i)})
+		if !reflect.DeepEqual(order, []string{"p1", "p0"}) {
+			t.Fatalf("order = %v, want [p1 p0]", order)
+		}
+	}
+}
+
+func TestSplitFailoverByWeight(t *testing.T) {
+	rule := splitRule(SplitByPayment, 10, 50, 10, 30)
+	for i := 0; i < 200; i++ {
+		order := rule.splitOrder(&model.Payment{ID: fmt.Sprintf("pay_%d", i)})
+		if len(order) != 4 {
+			t.Fatalf("order = %v, want every split", order)
+		}
+		// After the assigned split: heavier first, ties in rule order
+		var rest []string
+		for _, id := range []string{"p1", "p3", "p0", "p2"} {
+			if id != order[0] {
+				rest = append(rest, id)
+			}
+		}
+		if !reflect.DeepEqual(order[1:], rest) {
+			t.Fatalf("failover = %v, want %v", order[1:], rest)
+		}
+	}
+}
+
+// assignments counts which split each payment was assigned to
+func assignments(rule RoutingRule, payments []*model.Payment) map[string]int {
+	seen := make(map[string]int)
+	for _, payment := range payments {
+		seen[rule.splitOrder(payment)[0]]++
+	}
+	return seen
+}
+
+func TestSplitSticksToKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		splitBy  string
+		metadata func(i int) model.Metadata
+		sticky   bool
+	}{
+		{"order retried as new payments", SplitByPayment, func(i int) model.Metadata {
+			return model.Metadata{"order_id": "ord_1"}
+		}, true},
+		{"customer id", SplitByCustomer, func(i int) model.Metadata {
+			return model.Metadata{"customer_id": "cus_1", "email": fmt.Sprintf("%d@example.com", i)}
+		}, true},
+		{"customer email", SplitByCustomer, func(i int) model.Metadata {
+			return model.Metadata{"email": "a@example.com", "order_id": fmt.Sprintf("ord_%d", i)}
+		}, true},
+		{"customer without id or email falls back to order", SplitByCustomer, func(i int) model.Metadata {
+			return model.Metadata{"order_id": "ord_1"}
+		}, true},
+		{"no stable key", SplitByPayment, func(i int) model.Metadata {
+			return nil
+		}, false},
+	}
+	for _, tt := range tests {
+		rule := splitRule(tt.splitBy, 1, 1)
+		var payments []*model.Payment
+		for i := 0; i < 50; i++ {
+			payments = append(payments, &model.Payment{ID: fmt.Sprintf("pay_%d", i), MerchantID: "m1", Metadata: tt.metadata(i)})
+		}
+		if sticky := len(assignments(rule, payments)) == 1; sticky != tt.sticky {
+			t.Errorf("%s: every payment on one split = %v, want %v", tt.name, sticky, tt.sticky)
+		}
+	}
+}
+
+func TestSplitOrderIDIsScopedToMerchant(t *testing.T) {
+	rule := splitRule(SplitByPayment, 1, 1)
+	var payments []*model.Payment
+	for i := 0; i < 50; i++ {
+		payments = append(payments, &model.Payment{
+			ID:         fmt.Sprintf("pay_%d", i),
+			MerchantID: fmt.Sprintf("m%d", i),
+			Metadata:   model.Metadata{"order_id": "1001"},
+		})
+	}
+	if len(assignments(rule, payments)) == 1 {
+		t.Error("the same order_id at different merchants all landed on one split")
+	}
+}
+
+func TestValidateSplits(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    RoutingRule
+		wantErr string
+	}{
+		{"valid", splitRule(SplitByCustomer, 90, 10), ""},
+		{"default key", splitRule("", 1), ""},
+		{"no splits", RoutingRule{Name: "plain", SplitBy: "anything"}, ""},
+		{"unknown key", splitRule("merchant", 1), `unknown split key "merchant"`},
+		{"negative weight", splitRule("", 10, -1), `negative weight for "p1"`},
+		{"zero total", splitRule("", 0, 0), "split weights add up to zero"},
+		{
+			"duplicate processor",
+			RoutingRule{Name: "trial", Splits: []Split{{"p0", 1}, {"p0", 2}}},
+			`processor "p0" split twice`,
+		},
+	}
+	for _, tt := range tests {
+		err := validateSplits(tt.rule)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: validateSplits() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: validateSplits() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
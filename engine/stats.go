@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// Routing strategies decide the order a rule's processors are tried in
+const (
+	// StrategyOrdered tries processors in the order the rule lists them
+	StrategyOrdered = "ordered"
+	// StrategyScored ranks processors by their recent performance
+	StrategyScored = "scored"
+	// StrategyCheapest ranks processors by their estimated fee
+	StrategyCheapest = "cheapest"
+)
+
+// ScoringConfig tunes the statistics and the scored strategy. A
+// processor's score is
+//
+//	ApprovalWeight*approval_rate - ErrorWeight*error_rate - LatencyWeight*latency_seconds
+type ScoringConfig struct {
+	// Alpha is the EWMA smoothing factor; higher reacts faster
+	Alpha float64
+	// MinSamples a segment needs before its statistics are used. Below
+	// that the next broader segment is used, and a processor with too
+	// few samples overall is scored optimistically so it gets traffic.
+	MinSamples int
+
+	ApprovalWeight float64
+	ErrorWeight    float64
+	LatencyWeight  float64
+}
+
+var DefaultScoringConfig = ScoringConfig{
+	Alpha:          0.1,
+	MinSamples:     20,
+	ApprovalWeight: 1,
+	ErrorWeight:    1,
+	LatencyWeight:  0.1,
+}
+
+// ProcessorStats are rolling statistics for one processor and segment
+type ProcessorStats struct {
+	ProcessorID string `json:"processor_id"`
+	// Segment is "CURRENCY:BIN", "CURRENCY" or "" for all traffic
+	Segment string `json:"segment"`
+	Samples int    `json:"samples"`
+	// ApprovalRate is over calls the processor answered; errors do not
+	// count as declines
+	ApprovalRate float64   `json:"approval_rate"`
+	ErrorRate    float64   `json:"error_rate"`
+	LatencyMs    float64   `json:"latency_ms"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// statsTracker keeps exponentially weighted statistics per processor and
+// segment
+type statsTracker struct {
+	mu    sync.Mutex
+	stats map[string]map[string]*ProcessorStats
+}
+
+func newStatsTracker() *statsTracker {
+	return &statsTracker{stats: make(map[string]map[string]*ProcessorStats)}
+}
+
+// segments returns the payment's segments, most specific first
+func segments(payment *model.Payment) []string {
+	currency := strings.ToUpper(payment.Currency)
+	var bin string
+	if v, ok := payment.PaymentMethod.Details["bin"].(string); ok {
+		bin = v
+	}
+	if bin != "" {
+		return []string{currency + ":" + bin, currency, ""}
+	}
+	return []string{currency, ""}
+}
+
+// record feeds one authorization attempt into every segment of the payment
+func (t *statsTracker) record(processorID string, payment *model.Payment, elapsed time.Duration, err error, alpha float64) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := countsAsFailure(err)
+	var decline *model.DeclineError
+	answered := err == nil || errors.As(err, &decline)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bySegment := t.stats[processorID]
+	if bySegment == nil {
+		bySegment = make(map[string]*ProcessorStats)
+		t.stats[processorID] = bySegment
+	}
+	for _, segment := range segments(payment) {
+		s := bySegment[segment]
+		if s == nil {
+			s = &ProcessorStats{ProcessorID: processorID, Segment: segment, ApprovalRate: 1}
+			bySegment[segment] = s
+		}
+		s.Samples++
+		s.UpdatedAt = time.Now().UTC()
+		s.ErrorRate = ewma(s.ErrorRate, boolSample(failed), alpha, s.Samples)
+		s.LatencyMs = ewma(s.LatencyMs, float64(elapsed.Milliseconds()), alpha, s.Samples)
+		if answered {
+			s.ApprovalRate = ewma(s.ApprovalRate, boolSample(err == nil), alpha, s.Samples)
+		}
+	}
+}
+
+// ewma seeds the average with the first sample
+func ewma(current, sample, alpha float64, samples int) float64 {
+	if samples == 1 {
+		return sample
+	}
+	return alpha*sample + (1-alpha)*current
+}
+
+func boolSample(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// lookup returns the most specific segment with at least minSamples, or
+// false when the processor has too little history
+func (t *statsTracker) lookup(processorID string, payment *model.Payment, minSamples int) (ProcessorStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, segment := range segments(payment) {
+		if s := t.stats[processorID][segment]; s != nil && s.Samples >= minSamples {
+			return *s, true
+		}
+	}
+	return ProcessorStats{}, false
+}
+
+func (t *statsTracker) snapshot() []ProcessorStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var all []ProcessorStats
+	for _, bySegment := range t.stats {
+		for _, s := range bySegment {
+			all = append(all, *s)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].ProcessorID != all[j].ProcessorID {
+			return all[i].ProcessorID < all[j].ProcessorID
+		}
+		return all[i].Segment < all[j].Segment
+	})
+	return all
+}
+
+// score ranks ids for the payment, best first. Ties keep the rule's order.
+func (t *statsTracker) score(ids []string, payment *model.Payment, cfg ScoringConfig) []model.ProcessorScore {
+	scores := make([]model.ProcessorScore, len(ids))
+	for i, id := range ids {
+		s, ok := t.lookup(id, payment, cfg.MinSamples)
+		if !ok {
+			// Optimistic until there is enough history to judge
+			s = ProcessorStats{ApprovalRate: 1}
+		}
+		scores[i] = model.ProcessorScore{
+			ProcessorID:  id,
+			Segment:      s.Segment,
+			Samples:      s.Samples,
+			ApprovalRate: s.ApprovalRate,
+			ErrorRate:    s.ErrorRate,
+			LatencyMs:    s.LatencyMs,
+			Score: cfg.ApprovalWeight*s.ApprovalRate -
+				cfg.ErrorWeight*s.ErrorRate -
+				cfg.LatencyWeight*s.LatencyMs/1000,
+		}
+	}
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].Score > scores[j].Score })
+	return scores
+}
+
+func validateStrategy(rule RoutingRule) error {
+	switch rule.Strategy {
+	case "", StrategyOrdered:
+		return nil
+	case StrategyScored, StrategyCheapest:
+		if len(rule.Splits) > 0 {
+			return fmt.Errorf("rule %q: a %s rule cannot also split by weight", rule.Name, rule.Strategy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("rule %q: unknown strategy %q", rule.Name, rule.Strategy)
+	}
+}
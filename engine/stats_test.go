@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/retry"
+)
+
+func statsPayment(currency, bin string) *model.Payment {
+	payment := &model.Payment{Currency: currency, PaymentMethod: model.PaymentMethod{Type: "card"}}
+	if bin != "" {
+		payment.PaymentMethod.Details = model.PaymentMethodDetails{"bin": bin}
+	}
+	return payment
+}
+
+var (
+	errUnavailable = &retry.StatusError{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"}
+	errDeclined    = &model.DeclineError{Processor: "p", Code: "do_not_honor"}
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestStatsSeedWithFirstSample(t *testing.T) {
+	tracker := newStatsTracker()
+	payment := statsPayment("NGN", "")
+
+	tracker.record("p", payment, 200*time.Millisecond, errDeclined, 0.5)
+	s, _ := tracker.lookup("p", payment, 1)
+	if s.ApprovalRate != 0 || s.ErrorRate != 0 || s.LatencyMs != 200 || s.Samples != 1 {
+		t.Fatalf("after first sample: %+v, want the sample itself", s)
+	}
+
+	tracker.record("p", payment, 100*time.Millisecond, nil, 0.5)
+	s, _ = tracker.lookup("p", payment, 1)
+	if !approxEqual(s.ApprovalRate, 0.5) || !approxEqual(s.LatencyMs, 150) || s.Samples != 2 {
+		t.Errorf("after second sample: %+v, want approval 0.5 and latency 150", s)
+	}
+}
+
+func TestStatsErrorsAreNotDeclines(t *testing.T) {
+	tracker := newStatsTracker()
+	payment := statsPayment("NGN", "")
+
+	for _, err := range []error{nil, errUnavailable, errUnavailable} {
+		tracker.record("p", payment, time.Millisecond, err, 0.5)
+	}
+	s, _ := tracker.lookup("p", payment, 1)
+	if s.ApprovalRate != 1 {
+		t.Errorf("approval rate = %v after errors, want 1", s.ApprovalRate)
+	}
+	if !approxEqual(s.ErrorRate, 0.75) {
+		t.Errorf("error rate = %v, want 0.75", s.ErrorRate)
+	}
+
+	// A decline lowers the approval rate but is not an error
+	tracker.record("p", payment, time.Millisecond, errDeclined, 0.5)
+	s, _ = tracker.lookup("p", payment, 1)
+	if !approxEqual(s.ApprovalRate, 0.5) || !approxEqual(s.ErrorRate, 0.375) {
+		t.Errorf("after a decline: %+v, want approval 0.5 and error rate 0.375", s)
+	}
+}
+
+func TestStatsIgnoreCanceledAttempts(t *testing.T) {
+	tracker := newStatsTracker()
+	tracker.record("p", statsPayment("NGN", ""), time.Millisecond, context.Canceled, 0.5)
+	if stats := tracker.snapshot(); len(stats) != 0 {
+		t.Errorf("canceled attempt recorded: %+v", stats)
+	}
+}
+
+func TestStatsSegmentFallback(t *testing.T) {
+	tracker := newStatsTracker()
+	for i := 0; i < 3; i++ {
+		tracker.record("p", statsPayment("NGN", "539983"), time.Millisecond, nil, 0.1)
+	}
+	for i := 0; i < 2; i++ {
+		tracker.record("p", statsPayment("NGN", "424242"), time.Millisecond, nil, 0.1)
+	}
+
+	tests := []struct {
+		name        string
+		payment     *model.Payment
+		minSamples  int
+		wantSegment string
+		wantFound   bool
+	}{
+		{"bin with enough samples", statsPayment("ngn", "539983"), 3, "NGN:539983", true},
+		{"bin below MinSamples", statsPayment("NGN", "424242"), 3, "NGN", true},
+		{"unseen bin", statsPayment("NGN", "506099"), 3, "NGN", true},
+		{"no bin", statsPayment("NGN", ""), 3, "NGN", true},
+		{"unseen currency", statsPayment("USD", "424242"), 3, "", true},
+		{"too little history", statsPayment("NGN", "539983"), 6, "", false},
+	}
+	for _, tt := range tests {
+		s, ok := tracker.lookup("p", tt.payment, tt.minSamples)
+		if ok != tt.wantFound || s.Segment != tt.wantSegment {
+			t.Errorf("%s: lookup() = %q, %v, want %q, %v", tt.name, s.Segment, ok, tt.wantSegment, tt.wantFound)
+		}
+	}
+}
+
+func TestScoreNewProcessorsOptimistically(t *testing.T) {
+	tracker := newStatsTracker()
+	cfg := DefaultScoringConfig
+	cfg.MinSamples = 2
+	payment := statsPayment("NGN", "")
+
+	for i := 0; i < 5; i++ {
+		tracker.record("seasoned", payment, 100*time.Millisecond, nil, cfg.Alpha)
+	}
+	// One sample is below MinSamples, so a single decline does not count
+	tracker.record("fresh", payment, time.Millisecond, errDeclined, cfg.Alpha)
+
+	scores := tracker.score([]string{"seasoned", "fresh", "unknown"}, payment, cfg)
+	if scores[0].ProcessorID != "fresh" || scores[1].ProcessorID != "unknown" || scores[2].ProcessorID != "seasoned" {
+		t.Fatalf("order = %v, want processors without history first", scores)
+	}
+	for _, s := range scores[:2] {
+		if s.Score != cfg.ApprovalWeight || s.Samples != 0 {
+			t.Errorf("%s scored %v from %d samples, want %v from none", s.ProcessorID, s.Score, s.Samples, cfg.ApprovalWeight)
+		}
+	}
+	if want := cfg.ApprovalWeight - cfg.LatencyWeight*0.1; !approxEqual(scores[2].Score, want) {
+		t.Errorf("seasoned scored %v, want %v", scores[2].Score, want)
+	}
+}
+
+func TestScoreTiesKeepRuleOrder(t *testing.T) {
+	tracker := newStatsTracker()
+	payment := statsPayment("NGN", "")
+
+	for _, ids := range [][]string{{"a", "b", "c"}, {"c", "a", "b"}} {
+		scores := tracker.score(ids, payment, DefaultScoringConfig)
+		for i, s := range scores {
+			if s.ProcessorID != ids[i] {
+				t.Errorf("score(%v) order = %v, want the rule's order", ids, scores)
+				break
+			}
+		}
+	}
+}
+
+func TestScoreRanksByConfig(t *testing.T) {
+	tracker := newStatsTracker()
+	cfg := ScoringConfig{Alpha: 1, MinSamples: 1, ApprovalWeight: 1, ErrorWeight: 1, LatencyWeight: 1}
+	payment := statsPayment("NGN", "")
+
+	tracker.record("declining", payment, 500*time.Millisecond, errDeclined, cfg.Alpha)
+	tracker.record("failing", payment, time.Millisecond, errUnavailable, cfg.Alpha)
+	tracker.record("slow", payment, 2*time.Second, nil, cfg.Alpha)
+	tracker.record("fast", payment, time.Millisecond, nil, cfg.Alpha)
+
+	// fast ≈ 1, failing ≈ 1 - 1 as its approval rate stays optimistic (an
+	// error is not an answer), declining = -0.5, slow = 1 - 2
+	scores := tracker.score([]string{"slow", "failing", "declining", "fast"}, payment, cfg)
+	want := []string{"fast", "failing", "declining", "slow"}
+	for i, s := range scores {
+		if s.ProcessorID != want[i] {
+			t.Fatalf("order = %v, want %v", scores, want)
+		}
+	}
+}
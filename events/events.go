@@ -0,0 +1,112 @@
+// Package events carries payment domain events from the transactional
+// outbox to their consumers. Repositories write events in the same
+// transaction as the state change; the Relay publishes them afterwards, so
+// consumers see at-least-once delivery and should dedupe on Event.ID.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types. Besides creation, payments and refunds emit
+// payment.<status> and refund.<status> on every status change. A fee
+// reported after the charge, from a settlement report, emits
+// payment.fee_recorded.
+const (
+	PaymentCreated           = "payment.created"
+	PaymentAuthorized        = "payment.authorized"
+	PaymentCaptured          = "payment.captured"
+	PaymentCompleted         = "payment.completed"
+	PaymentVoided            = "payment.voided"
+	PaymentFailed            = "payment.failed"
+	PaymentPartiallyRefunded = "payment.partially_refunded"
+	PaymentRefunded          = "payment.refunded"
+	PaymentDisputed          = "payment.disputed"
+	PaymentFeeRecorded       = "payment.fee_recorded"
+	RefundCreated            = "refund.created"
+	RefundSucceeded          = "refund.succeeded"
+	RefundFailed             = "refund.failed"
+)
+
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// EventPublisher hands an event to its consumers
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Handler consumes events from the Bus
+type Handler func(ctx context.Context, event Event) error
+
+// Bus is the default in-process publisher. Every subscriber sees every
+// event; the publish fails if any subscriber fails so the event is retried.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileSink appends each event as a JSON line, for tests and local debugging
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Publish(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
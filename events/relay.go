@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const relayBatchSize = 100
+
+// OutboxStore hands out unpublished outbox events in commit order
+type OutboxStore interface {
+	// PublishBatch passes up to limit unpublished events to publish, oldest
+	// first, marking each as published once publish returns nil. Only one
+	// caller publishes at a time, and a failure stops the batch so later
+	// events wait for the failed one to be retried. After repeated failures
+	// an event is dead-lettered and skipped instead.
+	PublishBatch(ctx context.Context, limit int, publish func(Event) error) (int, error)
+}
+
+// Relay moves events from the outbox to a publisher
+type Relay struct {
+	store        OutboxStore
+	publisher    EventPublisher
+	pollInterval time.Duration
+	logger       *zap.Logger
+}
+
+func NewRelay(store OutboxStore, publisher EventPublisher, pollInterval time.Duration, logger *zap.Logger) *Relay {
+	return &Relay{
+		store:        store,
+		publisher:    publisher,
+		pollInterval: pollInterval,
+		logger:       logger.With(zap.String("component", "outbox_relay")),
+	}
+}
+
+// Run publishes outbox events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain full batches straight away, then wait for new events
+		for {
+			n, err := r.store.PublishBatch(ctx, relayBatchSize, func(event Event) error {
+				return r.publisher.Publish(ctx, event)
+			})
+			if err != nil {
+				r.logger.Error("Failed to publish outbox events", zap.Error(err))
+				break
+			}
+			if n < relayBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
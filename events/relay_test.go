@@ -0,0 +1,170 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryOutbox follows the DbOutboxRepository contract: a failure stops the
+// batch, and an event that fails maxAttempts times is dead-lettered
+type memoryOutbox struct {
+	mu          sync.Mutex
+	events      []Event
+	published   map[string]bool
+	attempts    map[string]int
+	dead        map[string]bool
+	maxAttempts int
+}
+
+func newMemoryOutbox(maxAttempts int, ids ...string) *memoryOutbox {
+	o := &memoryOutbox{
+		published:   make(map[string]bool),
+		attempts:    make(map[string]int),
+		dead:        make(map[string]bool),
+		maxAttempts: maxAttempts,
+	}
+	for _, id := range ids {
+		o.events = append(o.events, Event{ID: id, Type: PaymentCreated, AggregateType: "payment", AggregateID: id})
+	}
+	return o
+}
+
+func (o *memoryOutbox) PublishBatch(ctx context.Context, limit int, publish func(Event) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	published := 0
+	for _, event := range o.events {
+		if published == limit {
+			break
+		}
+		if o.published[event.ID] || o.dead[event.ID] {
+			continue
+		}
+
+		o.attempts[event.ID]++
+		if err := publish(event); err != nil {
+			if o.attempts[event.ID] < o.maxAttempts {
+				return published, fmt.Errorf("failed to publish event: %w", err)
+			}
+			o.dead[event.ID] = true
+			continue
+		}
+		o.published[event.ID] = true
+		published++
+	}
+	return published, nil
+}
+
+func (o *memoryOutbox) settled() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.published)+len(o.dead) == len(o.events)
+}
+
+// runRelay publishes the outbox through a Bus into a JSONL file until every
+// event is published or dead-lettered, and returns the event IDs written
+func runRelay(t *testing.T, outbox *memoryOutbox, fail func(Event) bool) []string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	bus := NewBus()
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		if fail(event) {
+			return errors.New("subscriber unavailable")
+		}
+		return sink.Publish(ctx, event)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewRelay(outbox, bus, time.Millisecond, zap.NewNop()).Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !outbox.settled() {
+		if time.Now().After(deadline) {
+			cancel()
+			<-done
+			t.Fatal("relay did not settle every event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, event.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestRelayPublishesInOrderAcrossRetries(t *testing.T) {
+	outbox := newMemoryOutbox(10, "1", "2", "3", "4")
+	failures := 0
+
+	ids := runRelay(t, outbox, func(event Event) bool {
+		if event.ID == "2" && failures < 3 {
+			failures++
+			return true
+		}
+		return false
+	})
+
+	want := []string{"1", "2", "3", "4"}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("published %v, want %v", ids, want)
+	}
+	if outbox.attempts["2"] != 4 {
+		t.Fatalf("event 2 attempted %d times, want 4", outbox.attempts["2"])
+	}
+}
+
+func TestRelayDeadLettersPoisonEvent(t *testing.T) {
+	outbox := newMemoryOutbox(3, "1", "2", "3")
+
+	ids := runRelay(t, outbox, func(event Event) bool {
+		return event.ID == "2"
+	})
+
+	want := []string{"1", "3"}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("published %v, want %v", ids, want)
+	}
+	if !outbox.dead["2"] || outbox.attempts["2"] != 3 {
+		t.Fatalf("event 2 dead=%v after %d attempts, want dead after 3", outbox.dead["2"], outbox.attempts["2"])
+	}
+}
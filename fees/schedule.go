@@ -0,0 +1,177 @@
+// Package fees models what each processor charges and estimates the fee
+// for a payment before it is routed.
+package fees
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thoraf20/payment-processor/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Card regions a fee may be limited to
+const (
+	RegionDomestic      = "domestic"
+	RegionInternational = "international"
+)
+
+// Schedule is the fee schedule file:
+//
+//	bin_countries:
+//	  "506099": NG
+//	processors:
+//	  stripe:
+//	    country: US
+//	    fees:
+//	      - {currency: USD, region: domestic, percent: 2.9, fixed: 30}
+//	      - {currency: USD, percent: 4.4, fixed: 30}
+//	  flutterwave:
+//	    country: NG
+//	    fees:
+//	      - {currency: NGN, region: domestic, percent: 1.4, cap: 200000}
+//	      - {percent: 3.8}
+//
+// The most specific fee matching a payment applies; among equally
+// specific fees the first listed wins.
+type Schedule struct {
+	BINCountries model.BINCountries           `json:"bin_countries" yaml:"bin_countries"`
+	Processors   map[string]ProcessorSchedule `json:"processors" yaml:"processors"`
+}
+
+type ProcessorSchedule struct {
+	// Country the processor acquires in; cards issued there are domestic
+	Country string `json:"country" yaml:"country"`
+	Fees    []Fee  `json:"fees" yaml:"fees"`
+}
+
+// Fee is percent of the amount plus a fixed part, in the payment's minor
+// unit. Empty criteria match any payment.
+type Fee struct {
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+	Brand    string `json:"brand,omitempty" yaml:"brand,omitempty"`
+	Method   string `json:"method,omitempty" yaml:"method,omitempty"`
+	// Region is RegionDomestic or RegionInternational
+	Region  string  `json:"region,omitempty" yaml:"region,omitempty"`
+	Percent float64 `json:"percent" yaml:"percent"`
+	Fixed   int64   `json:"fixed" yaml:"fixed"`
+	// Cap limits the fee, when set
+	Cap int64 `json:"cap,omitempty" yaml:"cap,omitempty"`
+}
+
+// LoadFile reads a schedule. Files ending in .json are parsed as JSON,
+// anything else as YAML.
+func LoadFile(path string) (*Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fee schedule: %w", err)
+	}
+
+	var schedule Schedule
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&schedule)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&schedule)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fee schedule %s: %w", path, err)
+	}
+	if err := schedule.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid fee schedule %s: %w", path, err)
+	}
+	return &schedule, nil
+}
+
+func (s *Schedule) Validate() error {
+	var errs []error
+	for id, processor := range s.Processors {
+		for i, fee := range processor.Fees {
+			where := fmt.Sprintf("%s fee %d", id, i+1)
+			switch fee.Region {
+			case "":
+			case RegionDomestic, RegionInternational:
+				if processor.Country == "" {
+					errs = append(errs, fmt.Errorf("%s: region needs the processor's country", where))
+				}
+			default:
+				errs = append(errs, fmt.Errorf("%s: unknown region %q", where, fee.Region))
+			}
+			if fee.Percent < 0 || fee.Percent >= 100 || fee.Fixed < 0 || fee.Cap < 0 {
+				errs = append(errs, fmt.Errorf("%s: percent must be in [0, 100) and amounts not negative", where))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EstimateFee returns what processorID would charge for the payment, and
+// false when the schedule has no fee for it
+func (s *Schedule) EstimateFee(processorID string, payment *model.Payment) (int64, bool) {
+	processor, ok := s.Processors[processorID]
+	if !ok {
+		return 0, false
+	}
+
+	region := ""
+	if country := payment.CardCountry(s.BINCountries); country != "" && processor.Country != "" {
+		region = RegionInternational
+		if strings.EqualFold(country, processor.Country) {
+			region = RegionDomestic
+		}
+	}
+	brand, _ := payment.PaymentMethod.Details["brand"].(string)
+
+	best, bestScore := -1, -1
+	for i, fee := range processor.Fees {
+		score, matches := fee.match(payment, brand, region)
+		if matches && score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return processor.Fees[best].Amount(payment.Amount), true
+}
+
+// match reports whether the fee applies and how many criteria it matched
+func (f Fee) match(payment *model.Payment, brand, region string) (int, bool) {
+	score := 0
+	for _, c := range []struct{ want, got string }{
+		{f.Currency, payment.Currency},
+		{f.Brand, brand},
+		{f.Method, payment.PaymentMethod.Type},
+		{f.Region, region},
+	} {
+		if c.want == "" {
+			continue
+		}
+		if !strings.EqualFold(c.want, c.got) {
+			return 0, false
+		}
+		score++
+	}
+	return score, true
+}
+
+// Amount is the fee on amount, rounded half up to the minor unit
+func (f Fee) Amount(amount int64) int64 {
+	// Percent is taken to hundredths of a basis point to keep the
+	// arithmetic in integers
+	rate := int64(math.Round(f.Percent * 10000))
+	fee := (amount*rate+500000)/1000000 + f.Fixed
+	if f.Cap > 0 && fee > f.Cap {
+		fee = f.Cap
+	}
+	return fee
+}
@@ -0,0 +1,163 @@
+package fees
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+func TestFeeAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		fee    Fee
+		amount int64
+		want   int64
+	}{
+		{"percent", Fee{Percent: 2.9}, 10000, 290},
+		{"percent and fixed", Fee{Percent: 2.9, Fixed: 30}, 10000, 320},
+		{"fixed only", Fee{Fixed: 100}, 10000, 100},
+		{"rounds half up", Fee{Percent: 1}, 50, 1},
+		{"rounds down below half", Fee{Percent: 1}, 49, 0},
+		{"fractional percent", Fee{Percent: 1.45}, 100000, 1450},
+		{"capped", Fee{Percent: 1.4, Cap: 200000}, 50_000_000, 200000},
+		{"below cap", Fee{Percent: 1.4, Cap: 200000}, 1_000_000, 14000},
+		{"cap includes fixed", Fee{Percent: 1, Fixed: 100, Cap: 150}, 10000, 150},
+		{"zero amount", Fee{Percent: 2.9, Fixed: 30}, 0, 30},
+	}
+	for _, tt := range tests {
+		if got := tt.fee.Amount(tt.amount); got != tt.want {
+			t.Errorf("%s: Amount(%d) = %d, want %d", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func testSchedule() *Schedule {
+	return &Schedule{
+		BINCountries: model.BINCountries{"506099": "NG", "4242": "US"},
+		Processors: map[string]ProcessorSchedule{
+			"stripe": {
+				Country: "US",
+				Fees: []Fee{
+					{Currency: "USD", Region: RegionDomestic, Percent: 2.9, Fixed: 30},
+					{Currency: "USD", Percent: 4.4, Fixed: 30},
+				},
+			},
+			"flutterwave": {
+				Country: "NG",
+				Fees: []Fee{
+					{Currency: "NGN", Region: RegionDomestic, Percent: 1.4, Cap: 200000},
+					{Currency: "NGN", Brand: "verve", Percent: 1},
+					{Currency: "NGN", Method: "card", Percent: 2},
+					{Currency: "NGN", Brand: "visa", Percent: 3},
+					{Percent: 3.8},
+				},
+			},
+		},
+	}
+}
+
+func feePayment(currency string, amount int64, method string, details model.PaymentMethodDetails) *model.Payment {
+	return &model.Payment{
+		Amount:        amount,
+		Currency:      currency,
+		PaymentMethod: model.PaymentMethod{Type: method, Details: details},
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor string
+		payment   *model.Payment
+		want      int64
+		wantKnown bool
+	}{
+		{"domestic card", "stripe", feePayment("USD", 10000, "card", model.PaymentMethodDetails{"bin": "424242"}), 320, true},
+		{"international card", "stripe", feePayment("USD", 10000, "card", model.PaymentMethodDetails{"bin": "506099"}), 470, true},
+		{"unknown country", "stripe", feePayment("USD", 10000, "card", nil), 470, true},
+		{"currency is case insensitive", "stripe", feePayment("usd", 10000, "card", nil), 470, true},
+		{"no fee for currency", "stripe", feePayment("NGN", 10000, "card", nil), 0, false},
+		{"unknown processor", "paystack", feePayment("USD", 10000, "card", nil), 0, false},
+		{"other currency falls back", "flutterwave", feePayment("GHS", 10000, "card", nil), 380, true},
+		{"domestic capped", "flutterwave", feePayment("NGN", 50_000_000, "card", model.PaymentMethodDetails{"bin": "506099", "brand": "verve"}), 200000, true},
+		{"brand", "flutterwave", feePayment("NGN", 10000, "bank_transfer", model.PaymentMethodDetails{"brand": "verve"}), 100, true},
+		{"first of equally specific fees", "flutterwave", feePayment("NGN", 10000, "card", model.PaymentMethodDetails{"brand": "verve"}), 100, true},
+		{"method", "flutterwave", feePayment("NGN", 10000, "card", model.PaymentMethodDetails{"brand": "amex"}), 200, true},
+	}
+	schedule := testSchedule()
+	for _, tt := range tests {
+		got, known := schedule.EstimateFee(tt.processor, tt.payment)
+		if got != tt.want || known != tt.wantKnown {
+			t.Errorf("%s: EstimateFee() = %d, %v, want %d, %v", tt.name, got, known, tt.want, tt.wantKnown)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		fee     Fee
+		country string
+		wantErr string
+	}{
+		{"valid", Fee{Currency: "USD", Region: RegionDomestic, Percent: 2.9, Fixed: 30}, "US", ""},
+		{"region without country", Fee{Region: RegionInternational, Percent: 1}, "", "region needs the processor's country"},
+		{"unknown region", Fee{Region: "local", Percent: 1}, "US", `unknown region "local"`},
+		{"negative percent", Fee{Percent: -1}, "", "percent must be in [0, 100) and amounts not negative"},
+		{"percent of 100", Fee{Percent: 100}, "", "percent must be in [0, 100) and amounts not negative"},
+		{"negative fixed", Fee{Fixed: -1}, "", "percent must be in [0, 100) and amounts not negative"},
+		{"negative cap", Fee{Cap: -1}, "", "percent must be in [0, 100) and amounts not negative"},
+	}
+	for _, tt := range tests {
+		schedule := &Schedule{Processors: map[string]ProcessorSchedule{
+			"stripe": {Country: tt.country, Fees: []Fee{tt.fee}},
+		}}
+		err := schedule.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Validate() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "stripe fee 1: "+tt.wantErr) {
+			t.Errorf("%s: Validate() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+		wantErr string
+	}{
+		{"fees.yaml", "processors:\n  stripe:\n    country: US\n    fees:\n      - {currency: USD, percent: 2.9, fixed: 30}\n", ""},
+		{"fees.json", `{"processors": {"stripe": {"country": "US", "fees": [{"currency": "USD", "percent": 2.9, "fixed": 30}]}}}`, ""},
+		{"unknown.yaml", "processors:\n  stripe:\n    fees:\n      - {currency: USD, percentage: 2.9}\n", "failed to parse"},
+		{"unknown.json", `{"processors": {"stripe": {"fees": [{"currency": "USD", "percentage": 2.9}]}}}`, "failed to parse"},
+		{"invalid.yaml", "processors:\n  stripe:\n    fees:\n      - {region: domestic, percent: 2.9}\n", "invalid fee schedule"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		schedule, err := LoadFile(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: LoadFile() error = %v, want %q", tt.file, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: LoadFile() error = %v", tt.file, err)
+		}
+		if fee, _ := schedule.EstimateFee("stripe", feePayment("USD", 10000, "card", nil)); fee != 320 {
+			t.Errorf("%s: fee = %d, want 320", tt.file, fee)
+		}
+	}
+}
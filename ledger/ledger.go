@@ -0,0 +1,254 @@
+// Package ledger keeps a double-entry record of every money movement.
+// Journal entries are immutable and must balance per currency; corrections
+// are posted as new, offsetting entries. Postings are signed: debits are
+// positive and credits negative.
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnbalanced      = errors.New("journal entry does not balance")
+	ErrEmptyEntry      = errors.New("journal entry has no postings")
+	ErrAccountNotFound = errors.New("ledger account not found")
+)
+
+type AccountType string
+
+const (
+	Asset     AccountType = "asset"
+	Liability AccountType = "liability"
+	Expense   AccountType = "expense"
+)
+
+// Account is a ledger account, identified by a stable code
+type Account struct {
+	Code string      `json:"code"`
+	Type AccountType `json:"type"`
+}
+
+// MerchantBalance is what the platform owes a merchant
+func MerchantBalance(merchantID string) Account {
+	if merchantID == "" {
+		merchantID = "default"
+	}
+	return Account{Code: "merchant:" + merchantID + ":balance", Type: Liability}
+}
+
+// ProcessorReceivable is what a processor owes the platform in settlements
+func ProcessorReceivable(processorID string) Account {
+	return Account{Code: "processor:" + processorID + ":receivable", Type: Asset}
+}
+
+// Fees are the processing fees a processor withholds from settlement
+func Fees(processorID string) Account {
+	return Account{Code: "processor:" + processorID + ":fees", Type: Expense}
+}
+
+// RefundsPayable holds refunds accepted but not yet settled by a processor
+func RefundsPayable(processorID string) Account {
+	return Account{Code: "processor:" + processorID + ":refunds_payable", Type: Liability}
+}
+
+type Posting struct {
+	Account  Account `json:"account"`
+	Currency string  `json:"currency"`
+	Amount   int64   `json:"amount"`
+}
+
+// Entry is a journal entry. IdempotencyKey is derived from the source of
+// the movement so the same movement is never posted twice.
+type Entry struct {
+	ID             string    `json:"id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Description    string    `json:"description"`
+	PaymentID      string    `json:"payment_id,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	Postings       []Posting `json:"postings"`
+}
+
+// Transfer builds the pair of postings that moves amount from the credited
+// account to the debited one
+func Transfer(debit, credit Account, currency string, amount int64) []Posting {
+	return []Posting{
+		{Account: debit, Currency: currency, Amount: amount},
+		{Account: credit, Currency: currency, Amount: -amount},
+	}
+}
+
+// Validate checks that the entry has postings and sums to zero per currency
+func (e *Entry) Validate() error {
+	if len(e.Postings) == 0 {
+		return ErrEmptyEntry
+	}
+	sums := make(map[string]int64)
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return fmt.Errorf("%w: zero posting to %s", ErrUnbalanced, p.Account.Code)
+		}
+		sums[p.Currency] += p.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("%w: %s postings sum to %d", ErrUnbalanced, currency, sum)
+		}
+	}
+	return nil
+}
+
+type Ledger struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func New(db *sql.DB, logger *zap.Logger) *Ledger {
+	return &Ledger{db: db, logger: logger}
+}
+
+// Post records a balanced entry. It reports false without error when an
+// entry with the same idempotency key already exists.
+func (l *Ledger) Post(ctx context.Context, entry *Entry) (bool, error) {
+	if err := entry.Validate(); err != nil {
+		return false, err
+	}
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now().UTC()
+	}
+	entry.CreatedAt = time.Now().UTC()
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO journal_entries (id, idempotency_key, description, payment_id, occurred_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (idempotency_key) DO NOTHING`,
+		entry.ID, entry.IdempotencyKey, entry.Description, entry.PaymentID, entry.OccurredAt, entry.CreatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return false, nil
+	}
+
+	for _, p := range entry.Postings {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO ledger_accounts (code, type) VALUES ($1, $2)
+			ON CONFLICT (code) DO NOTHING`,
+			p.Account.Code, p.Account.Type,
+		); err != nil {
+			return false, fmt.Errorf("failed to open account %s: %w", p.Account.Code, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO ledger_postings (entry_id, account_code, currency, amount)
+			VALUES ($1, $2, $3, $4)`,
+			entry.ID, p.Account.Code, p.Currency, p.Amount,
+		); err != nil {
+			return false, fmt.Errorf("failed to insert posting: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit journal entry: %w", err)
+	}
+	return true, nil
+}
+
+// Balance returns the account's balance in currency as of the given time,
+// on the account's normal side: assets and expenses are debit-positive,
+// liabilities credit-positive. A zero asOf means now.
+func (l *Ledger) Balance(ctx context.Context, code, currency string, asOf time.Time) (int64, error) {
+	if asOf.IsZero() {
+		asOf = time.Now().UTC()
+	}
+
+	var accountType AccountType
+	err := l.db.QueryRowContext(ctx, `SELECT type FROM ledger_accounts WHERE code = $1`, code).Scan(&accountType)
+	if err == sql.ErrNoRows {
+		return 0, ErrAccountNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	var balance int64
+	err = l.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM ledger_postings p
+		JOIN journal_entries e ON e.id = p.entry_id
+		WHERE p.account_code = $1 AND p.currency = $2 AND e.occurred_at <= $3`,
+		code, currency, asOf,
+	).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum postings: %w", err)
+	}
+
+	if accountType == Liability {
+		balance = -balance
+	}
+	return balance, nil
+}
+
+// Entries lists the journal entries posted for a payment, oldest first
+func (l *Ledger) Entries(ctx context.Context, paymentID string) ([]*Entry, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT e.id, e.idempotency_key, e.description, e.payment_id, e.occurred_at, e.created_at,
+		       p.account_code, a.type, p.currency, p.amount
+		FROM journal_entries e
+		JOIN ledger_postings p ON p.entry_id = e.id
+		JOIN ledger_accounts a ON a.code = p.account_code
+		WHERE e.payment_id = $1
+		ORDER BY e.occurred_at, e.id, p.id`,
+		paymentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		var e Entry
+		var p Posting
+		if err := rows.Scan(&e.ID, &e.IdempotencyKey, &e.Description, &e.PaymentID, &e.OccurredAt, &e.CreatedAt,
+			&p.Account.Code, &p.Account.Type, &p.Currency, &p.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan journal entry: %w", err)
+		}
+		if n := len(entries); n == 0 || entries[n-1].ID != e.ID {
+			entries = append(entries, &e)
+		}
+		last := entries[len(entries)-1]
+		last.Postings = append(last.Postings, p)
+	}
+	return entries, rows.Err()
+}
+
+// countEntries counts entries whose idempotency key starts with prefix
+func (l *Ledger) countEntries(ctx context.Context, prefix string) (int, error) {
+	var n int
+	err := l.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM journal_entries WHERE idempotency_key LIKE $1 || '%'`, prefix,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count journal entries: %w", err)
+	}
+	return n, nil
+}
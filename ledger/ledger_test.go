@@ -0,0 +1,291 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryDB answers the statements Ledger issues from memory. Transactions
+// apply as they go; the tests never roll back.
+type memoryDB struct {
+	mu       sync.Mutex
+	entries  map[string]memoryEntry // by idempotency key
+	accounts map[string]string
+	postings []memoryPosting
+}
+
+type memoryEntry struct {
+	id          string
+	description string
+	occurredAt  time.Time
+}
+
+type memoryPosting struct {
+	entryID  string
+	account  string
+	currency string
+	amount   int64
+}
+
+func newTestLedger() (*Ledger, *memoryDB) {
+	db := &memoryDB{entries: make(map[string]memoryEntry), accounts: make(map[string]string)}
+	return New(sql.OpenDB(db), zap.NewNop()), db
+}
+
+// entry returns the postings of the entry with the given idempotency key
+func (db *memoryDB) entry(key string) ([]memoryPosting, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, ok := db.entries[key]
+	if !ok {
+		return nil, false
+	}
+	var postings []memoryPosting
+	for _, p := range db.postings {
+		if p.entryID == e.id {
+			postings = append(postings, p)
+		}
+	}
+	return postings, true
+}
+
+func (db *memoryDB) Connect(ctx context.Context) (driver.Conn, error) { return memoryConn{db}, nil }
+func (db *memoryDB) Driver() driver.Driver                            { return nil }
+
+type memoryConn struct{ db *memoryDB }
+
+func (c memoryConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("unexpected prepare: %s", query)
+}
+func (c memoryConn) Close() error              { return nil }
+func (c memoryConn) Begin() (driver.Tx, error) { return memoryTx{}, nil }
+
+type memoryTx struct{}
+
+func (memoryTx) Commit() error   { return nil }
+func (memoryTx) Rollback() error { return nil }
+
+func (c memoryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "INSERT INTO journal_entries"):
+		key := args[1].Value.(string)
+		if _, ok := db.entries[key]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		db.entries[key] = memoryEntry{
+			id:          args[0].Value.(string),
+			description: args[2].Value.(string),
+			occurredAt:  args[4].Value.(time.Time),
+		}
+		return driver.RowsAffected(1), nil
+	case strings.Contains(query, "INSERT INTO ledger_accounts"):
+		code := args[0].Value.(string)
+		if _, ok := db.accounts[code]; !ok {
+			db.accounts[code] = args[1].Value.(string)
+		}
+		return driver.RowsAffected(1), nil
+	case strings.Contains(query, "INSERT INTO ledger_postings"):
+		db.postings = append(db.postings, memoryPosting{
+			entryID:  args[0].Value.(string),
+			account:  args[1].Value.(string),
+			currency: args[2].Value.(string),
+			amount:   args[3].Value.(int64),
+		})
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected exec: %s", query)
+}
+
+func (c memoryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "SELECT COUNT(*) FROM journal_entries"):
+		prefix := args[0].Value.(string)
+		n := 0
+		for key := range db.entries {
+			if strings.HasPrefix(key, prefix) {
+				n++
+			}
+		}
+		return &memoryRows{columns: []string{"count"}, values: [][]driver.Value{{int64(n)}}}, nil
+	case strings.Contains(query, "SELECT type FROM ledger_accounts"):
+		rows := &memoryRows{columns: []string{"type"}}
+		if accountType, ok := db.accounts[args[0].Value.(string)]; ok {
+			rows.values = [][]driver.Value{{accountType}}
+		}
+		return rows, nil
+	case strings.Contains(query, "SUM(p.amount)"):
+		code, currency, asOf := args[0].Value.(string), args[1].Value.(string), args[2].Value.(time.Time)
+		occurred := make(map[string]time.Time, len(db.entries))
+		for _, e := range db.entries {
+			occurred[e.id] = e.occurredAt
+		}
+		var sum int64
+		for _, p := range db.postings {
+			if p.account == code && p.currency == currency && !occurred[p.entryID].After(asOf) {
+				sum += p.amount
+			}
+		}
+		return &memoryRows{columns: []string{"sum"}, values: [][]driver.Value{{sum}}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+type memoryRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *memoryRows) Columns() []string { return r.columns }
+func (r *memoryRows) Close() error      { return nil }
+
+func (r *memoryRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestPostIgnoresRepeatedIdempotencyKey(t *testing.T) {
+	ledger, db := newTestLedger()
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		posted, err := ledger.Post(ctx, &Entry{
+			IdempotencyKey: "capture:pay_1",
+			Postings:       Transfer(ProcessorReceivable("stripe"), MerchantBalance("m1"), "USD", 1000),
+		})
+		if err != nil {
+			t.Fatalf("Post() #%d error = %v", i+1, err)
+		}
+		if posted != want {
+			t.Errorf("Post() #%d posted = %v, want %v", i+1, posted, want)
+		}
+	}
+	if len(db.postings) != 2 {
+		t.Errorf("%d postings recorded, want 2", len(db.postings))
+	}
+}
+
+func TestEntryValidate(t *testing.T) {
+	stripe, merchant, fees := ProcessorReceivable("stripe"), MerchantBalance("m1"), Fees("stripe")
+	tests := []struct {
+		name     string
+		postings []Posting
+		wantErr  error
+	}{
+		{"transfer", Transfer(stripe, merchant, "USD", 1000), nil},
+		{"split", []Posting{
+			{Account: stripe, Currency: "USD", Amount: 970},
+			{Account: fees, Currency: "USD", Amount: 30},
+			{Account: merchant, Currency: "USD", Amount: -1000},
+		}, nil},
+		{"balanced per currency", append(Transfer(stripe, merchant, "USD", 1000), Transfer(stripe, merchant, "NGN", 5000)...), nil},
+		{"empty", nil, ErrEmptyEntry},
+		{"one sided", []Posting{{Account: stripe, Currency: "USD", Amount: 1000}}, ErrUnbalanced},
+		{"short credit", []Posting{
+			{Account: stripe, Currency: "USD", Amount: 1000},
+			{Account: merchant, Currency: "USD", Amount: -999},
+		}, ErrUnbalanced},
+		{"currencies do not offset", []Posting{
+			{Account: stripe, Currency: "USD", Amount: 1000},
+			{Account: merchant, Currency: "NGN", Amount: -1000},
+		}, ErrUnbalanced},
+		{"zero posting", append(Transfer(stripe, merchant, "USD", 1000), Posting{Account: fees, Currency: "USD"}), ErrUnbalanced},
+	}
+	for _, tt := range tests {
+		entry := &Entry{IdempotencyKey: "test:" + tt.name, Postings: tt.postings}
+		if err := entry.Validate(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Validate() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPostRejectsUnbalancedEntry(t *testing.T) {
+	ledger, db := newTestLedger()
+
+	posted, err := ledger.Post(context.Background(), &Entry{
+		IdempotencyKey: "capture:pay_1",
+		Postings: []Posting{
+			{Account: ProcessorReceivable("stripe"), Currency: "USD", Amount: 1000},
+			{Account: MerchantBalance("m1"), Currency: "USD", Amount: -900},
+		},
+	})
+	if !errors.Is(err, ErrUnbalanced) || posted {
+		t.Fatalf("Post() = %v, %v, want %v", posted, err, ErrUnbalanced)
+	}
+	if len(db.entries) != 0 || len(db.postings) != 0 {
+		t.Error("unbalanced entry was recorded")
+	}
+}
+
+func TestBalance(t *testing.T) {
+	ledger, _ := newTestLedger()
+	ctx := context.Background()
+	stripe, merchant, fees := ProcessorReceivable("stripe"), MerchantBalance("m1"), Fees("stripe")
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []*Entry{
+		{IdempotencyKey: "capture:pay_1", OccurredAt: day, Postings: Transfer(stripe, merchant, "USD", 1000)},
+		{IdempotencyKey: "fee:pay_1", OccurredAt: day.Add(time.Hour), Postings: Transfer(fees, stripe, "USD", 30)},
+		{IdempotencyKey: "capture:pay_2", OccurredAt: day.AddDate(0, 0, 1), Postings: Transfer(stripe, merchant, "USD", 500)},
+		{IdempotencyKey: "refund:ref_1", OccurredAt: day.AddDate(0, 0, 2), Postings: Transfer(merchant, stripe, "USD", 200)},
+		{IdempotencyKey: "capture:pay_3", OccurredAt: day, Postings: Transfer(stripe, merchant, "NGN", 90000)},
+	}
+	for _, entry := range entries {
+		if _, err := ledger.Post(ctx, entry); err != nil {
+			t.Fatalf("Post(%s) error = %v", entry.IdempotencyKey, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		account  Account
+		currency string
+		asOf     time.Time
+		want     int64
+	}{
+		// Liabilities are credit-positive, assets and expenses debit-positive
+		{"merchant", merchant, "USD", time.Time{}, 1300},
+		{"receivable", stripe, "USD", time.Time{}, 1270},
+		{"fees", fees, "USD", time.Time{}, 30},
+		{"other currency", merchant, "NGN", time.Time{}, 90000},
+		{"no postings in currency", fees, "NGN", time.Time{}, 0},
+		{"as of the first day", merchant, "USD", day.Add(time.Hour), 1000},
+		{"as of the second day", stripe, "USD", day.AddDate(0, 0, 1), 1470},
+		{"before any entry", merchant, "USD", day.Add(-time.Second), 0},
+	}
+	for _, tt := range tests {
+		got, err := ledger.Balance(ctx, tt.account.Code, tt.currency, tt.asOf)
+		if err != nil {
+			t.Errorf("%s: Balance() error = %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Balance() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ledger.Balance(ctx, MerchantBalance("m2").Code, "USD", time.Time{}); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Balance(unknown account) error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
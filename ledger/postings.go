@@ -0,0 +1,173 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/thoraf20/payment-processor/events"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+// Poster turns payment and refund events into journal entries. Event
+// delivery is at-least-once, so every entry's idempotency key is derived
+// from what it records rather than from the event.
+type Poster struct {
+	ledger   *Ledger
+	payments repository.PaymentRepository
+	logger   *zap.Logger
+}
+
+func NewPoster(ledger *Ledger, payments repository.PaymentRepository, logger *zap.Logger) *Poster {
+	return &Poster{ledger: ledger, payments: payments, logger: logger}
+}
+
+// HandleEvent is an events.Handler
+func (p *Poster) HandleEvent(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case events.PaymentCaptured, events.PaymentCompleted:
+		var payment model.Payment
+		if err := json.Unmarshal(event.Payload, &payment); err != nil {
+			return fmt.Errorf("failed to decode payment event: %w", err)
+		}
+		return p.postCapture(ctx, &payment, event)
+	case events.PaymentFeeRecorded:
+		var payment model.Payment
+		if err := json.Unmarshal(event.Payload, &payment); err != nil {
+			return fmt.Errorf("failed to decode payment event: %w", err)
+		}
+		return p.postFee(ctx, &payment, event)
+	case events.PaymentDisputed:
+		var payment model.Payment
+		if err := json.Unmarshal(event.Payload, &payment); err != nil {
+			return fmt.Errorf("failed to decode payment event: %w", err)
+		}
+		return p.postChargeback(ctx, &payment, event)
+	case events.RefundCreated, events.RefundSucceeded, events.RefundFailed:
+		var refund model.Refund
+		if err := json.Unmarshal(event.Payload, &refund); err != nil {
+			return fmt.Errorf("failed to decode refund event: %w", err)
+		}
+		return p.postRefund(ctx, &refund, event)
+	}
+	return nil
+}
+
+// postCapture credits the merchant with the captured amount, owed to us by
+// the processor, less any fee the processor withheld. A capture after a
+// chargeback is a won dispute and reverses the chargeback.
+func (p *Poster) postCapture(ctx context.Context, payment *model.Payment, event events.Event) error {
+	if payment.CapturedAmount <= 0 {
+		return nil
+	}
+	if err := p.post(ctx, &Entry{
+		IdempotencyKey: "capture:" + payment.ID,
+		Description:    "capture",
+		PaymentID:      payment.ID,
+		OccurredAt:     event.OccurredAt,
+		Postings:       Transfer(ProcessorReceivable(payment.ProcessorID), MerchantBalance(payment.MerchantID), payment.Currency, payment.CapturedAmount),
+	}); err != nil {
+		return err
+	}
+
+	if err := p.postFee(ctx, payment, event); err != nil {
+		return err
+	}
+
+	chargebacks, err := p.ledger.countEntries(ctx, "chargeback:"+payment.ID+":")
+	if err != nil {
+		return err
+	}
+	reversals, err := p.ledger.countEntries(ctx, "chargeback_reversal:"+payment.ID+":")
+	if err != nil {
+		return err
+	}
+	if chargebacks > reversals {
+		return p.post(ctx, &Entry{
+			IdempotencyKey: "chargeback_reversal:" + payment.ID + ":" + event.ID,
+			Description:    "chargeback reversal",
+			PaymentID:      payment.ID,
+			OccurredAt:     event.OccurredAt,
+			Postings:       Transfer(ProcessorReceivable(payment.ProcessorID), MerchantBalance(payment.MerchantID), payment.Currency, payment.RefundableAmount()),
+		})
+	}
+	return nil
+}
+
+// postFee books the fee the processor withheld, whether reported with the
+// charge or later from a settlement report
+func (p *Poster) postFee(ctx context.Context, payment *model.Payment, event events.Event) error {
+	if payment.ProcessorFee <= 0 {
+		return nil
+	}
+	return p.post(ctx, &Entry{
+		IdempotencyKey: "fee:" + payment.ID,
+		Description:    "processor fee",
+		PaymentID:      payment.ID,
+		OccurredAt:     event.OccurredAt,
+		Postings:       Transfer(Fees(payment.ProcessorID), ProcessorReceivable(payment.ProcessorID), payment.Currency, payment.ProcessorFee),
+	})
+}
+
+// postChargeback takes the disputed amount back from the merchant; the
+// processor has already pulled it from settlement
+func (p *Poster) postChargeback(ctx context.Context, payment *model.Payment, event events.Event) error {
+	amount := payment.RefundableAmount()
+	if amount <= 0 {
+		return nil
+	}
+	return p.post(ctx, &Entry{
+		IdempotencyKey: "chargeback:" + payment.ID + ":" + event.ID,
+		Description:    "chargeback",
+		PaymentID:      payment.ID,
+		OccurredAt:     event.OccurredAt,
+		Postings:       Transfer(MerchantBalance(payment.MerchantID), ProcessorReceivable(payment.ProcessorID), payment.Currency, amount),
+	})
+}
+
+// postRefund moves a refund from the merchant's balance into refunds
+// payable when it is accepted, then settles or reverses it once the
+// processor reports the outcome
+func (p *Poster) postRefund(ctx context.Context, refund *model.Refund, event events.Event) error {
+	payment, err := p.payments.Get(ctx, refund.PaymentID)
+	if err != nil {
+		return fmt.Errorf("failed to get refunded payment: %w", err)
+	}
+
+	merchant := MerchantBalance(payment.MerchantID)
+	payable := RefundsPayable(payment.ProcessorID)
+	entry := &Entry{
+		IdempotencyKey: "refund:" + refund.ID + ":" + string(refund.Status),
+		PaymentID:      payment.ID,
+		OccurredAt:     event.OccurredAt,
+	}
+	switch event.Type {
+	case events.RefundCreated:
+		entry.IdempotencyKey = "refund:" + refund.ID + ":created"
+		entry.Description = "refund"
+		entry.Postings = Transfer(merchant, payable, refund.Currency, refund.Amount)
+	case events.RefundSucceeded:
+		entry.Description = "refund settled"
+		entry.Postings = Transfer(payable, ProcessorReceivable(payment.ProcessorID), refund.Currency, refund.Amount)
+	case events.RefundFailed:
+		entry.Description = "refund reversal"
+		entry.Postings = Transfer(payable, merchant, refund.Currency, refund.Amount)
+	}
+	return p.post(ctx, entry)
+}
+
+func (p *Poster) post(ctx context.Context, entry *Entry) error {
+	posted, err := p.ledger.Post(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("failed to post %s: %w", entry.IdempotencyKey, err)
+	}
+	if posted {
+		p.logger.Debug("Posted journal entry",
+			zap.String("key", entry.IdempotencyKey),
+			zap.String("payment_id", entry.PaymentID),
+		)
+	}
+	return nil
+}
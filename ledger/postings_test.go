@@ -0,0 +1,121 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/events"
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+// paymentEvent is a payment event as the outbox publishes it
+func paymentEvent(t *testing.T, eventType string, payment *model.Payment) events.Event {
+	t.Helper()
+	payload, err := json.Marshal(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.Event{
+		ID:            "evt_" + eventType,
+		Type:          eventType,
+		AggregateType: "payment",
+		AggregateID:   payment.ID,
+		Payload:       payload,
+		OccurredAt:    time.Now().UTC(),
+	}
+}
+
+func TestPosterPostsCompletedSale(t *testing.T) {
+	ledger, db := newTestLedger()
+	poster := NewPoster(ledger, nil, zap.NewNop())
+
+	payment := &model.Payment{
+		ID:           "pay_1",
+		Amount:       1000,
+		Currency:     "NGN",
+		Status:       model.StatusPending,
+		ProcessorID:  "flutterwave",
+		MerchantID:   "m1",
+		ProcessorFee: 14,
+	}
+	if err := payment.Complete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := poster.HandleEvent(context.Background(), paymentEvent(t, events.PaymentCompleted, payment)); err != nil {
+		t.Fatalf("HandleEvent() error = %v", err)
+	}
+
+	capture, ok := db.entry("capture:pay_1")
+	if !ok {
+		t.Fatal("completed sale was not posted")
+	}
+	want := map[string]int64{
+		ProcessorReceivable("flutterwave").Code: 1000,
+		MerchantBalance("m1").Code:              -1000,
+	}
+	for _, p := range capture {
+		if p.currency != "NGN" || want[p.account] != p.amount {
+			t.Errorf("capture posting %+v, want %v in NGN", p, want)
+		}
+	}
+	if _, ok := db.entry("fee:pay_1"); !ok {
+		t.Error("processor fee was not posted")
+	}
+}
+
+func TestPosterPostsFeeRecordedFromSettlement(t *testing.T) {
+	ledger, db := newTestLedger()
+	poster := NewPoster(ledger, nil, zap.NewNop())
+	ctx := context.Background()
+
+	// Stripe reports no fee when charging
+	payment := &model.Payment{
+		ID:          "pay_1",
+		Amount:      1000,
+		Currency:    "USD",
+		Status:      model.StatusPending,
+		ProcessorID: "stripe",
+	}
+	if err := payment.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := poster.HandleEvent(ctx, paymentEvent(t, events.PaymentCompleted, payment)); err != nil {
+		t.Fatalf("HandleEvent(completed) error = %v", err)
+	}
+	if _, ok := db.entry("fee:pay_1"); ok {
+		t.Fatal("fee posted before it was known")
+	}
+
+	payment.ProcessorFee = 59
+	for i := 0; i < 2; i++ {
+		if err := poster.HandleEvent(ctx, paymentEvent(t, events.PaymentFeeRecorded, payment)); err != nil {
+			t.Fatalf("HandleEvent(fee_recorded) error = %v", err)
+		}
+	}
+
+	fee, ok := db.entry("fee:pay_1")
+	if !ok {
+		t.Fatal("recorded fee was not posted")
+	}
+	if len(fee) != 2 {
+		t.Fatalf("fee entry has %d postings, want 2", len(fee))
+	}
+	for _, p := range fee {
+		switch p.account {
+		case Fees("stripe").Code:
+			if p.amount != 59 {
+				t.Errorf("fees debited %d, want 59", p.amount)
+			}
+		case ProcessorReceivable("stripe").Code:
+			if p.amount != -59 {
+				t.Errorf("receivable credited %d, want -59", p.amount)
+			}
+		default:
+			t.Errorf("unexpected fee posting to %s", p.account)
+		}
+	}
+}
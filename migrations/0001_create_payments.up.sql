@@ -0,0 +1,27 @@
+CREATE TABLE payments (
+    id                     TEXT PRIMARY KEY,
+    external_id            TEXT NOT NULL DEFAULT '',
+    amount                 BIGINT NOT NULL CHECK (amount >= 0),
+    currency               TEXT NOT NULL,
+    status                 TEXT NOT NULL,
+    payment_method_type    TEXT NOT NULL DEFAULT '',
+    payment_method_details JSONB,
+    metadata               JSONB,
+    processor_id           TEXT NOT NULL DEFAULT '',
+    processor_payment_id   TEXT NOT NULL DEFAULT '',
+    captured_amount        BIGINT NOT NULL DEFAULT 0,
+    refunded_amount        BIGINT NOT NULL DEFAULT 0,
+    created_at             TIMESTAMPTZ NOT NULL,
+    updated_at             TIMESTAMPTZ NOT NULL,
+    CHECK (captured_amount <= amount),
+    CHECK (refunded_amount <= captured_amount)
+);
+
+-- Keyset pagination orders by (created_at, id) or (amount, id)
+CREATE INDEX payments_created_at_id_idx ON payments (created_at, id);
+CREATE INDEX payments_amount_id_idx ON payments (amount, id);
+CREATE INDEX payments_status_idx ON payments (status);
+CREATE INDEX payments_currency_idx ON payments (currency);
+CREATE INDEX payments_processor_idx ON payments (processor_id, processor_payment_id);
+CREATE INDEX payments_external_id_idx ON payments (external_id);
+CREATE INDEX payments_metadata_idx ON payments USING GIN (metadata);
@@ -0,0 +1,13 @@
+CREATE TABLE refunds (
+    id                  TEXT PRIMARY KEY,
+    payment_id          TEXT NOT NULL REFERENCES payments (id),
+    amount              BIGINT NOT NULL CHECK (amount > 0),
+    currency            TEXT NOT NULL,
+    reason              TEXT NOT NULL DEFAULT '',
+    status              TEXT NOT NULL,
+    processor_refund_id TEXT NOT NULL DEFAULT '',
+    created_at          TIMESTAMPTZ NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX refunds_payment_id_idx ON refunds (payment_id, created_at);
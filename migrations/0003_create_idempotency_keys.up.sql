@@ -0,0 +1,16 @@
+CREATE TABLE idempotency_keys (
+    key                   TEXT PRIMARY KEY,
+    fingerprint           TEXT NOT NULL,
+    status                TEXT NOT NULL,
+    response_code         INTEGER,
+    response_content_type TEXT,
+    response_body         BYTEA,
+    -- lock_token identifies the request holding the key; only it may
+    -- store the response
+    lock_token            TEXT NOT NULL,
+    locked_at             TIMESTAMPTZ NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL,
+    expires_at            TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
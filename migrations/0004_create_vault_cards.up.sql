@@ -0,0 +1,18 @@
+-- encrypted_pan is sealed with a per-card data key; wrapped_key is that data
+-- key sealed with the vault KEK. CVVs are never stored. A token may only be
+-- charged by the merchant that created it.
+CREATE TABLE vault_cards (
+    token         TEXT PRIMARY KEY,
+    merchant_id   TEXT NOT NULL,
+    encrypted_pan BYTEA NOT NULL,
+    wrapped_key   BYTEA NOT NULL,
+    bin           TEXT NOT NULL,
+    last4         TEXT NOT NULL,
+    brand         TEXT NOT NULL,
+    exp_month     TEXT NOT NULL,
+    exp_year      TEXT NOT NULL,
+    fingerprint   TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX vault_cards_fingerprint_idx ON vault_cards (fingerprint);
@@ -0,0 +1,17 @@
+-- Raw inbound provider webhooks, kept for audit and to drop redeliveries
+CREATE TABLE provider_events (
+    provider     TEXT NOT NULL,
+    event_id     TEXT NOT NULL,
+    event_type   TEXT NOT NULL,
+    reference    TEXT NOT NULL DEFAULT '',
+    payload      JSONB NOT NULL,
+    received_at  TIMESTAMPTZ NOT NULL,
+    -- claimed_at is when a delivery started processing the event; other
+    -- deliveries leave it alone until it is processed or the claim lapses
+    claimed_at   TIMESTAMPTZ NOT NULL,
+    processed_at TIMESTAMPTZ,
+    error        TEXT,
+    PRIMARY KEY (provider, event_id)
+);
+
+CREATE INDEX provider_events_reference_idx ON provider_events (provider, reference);
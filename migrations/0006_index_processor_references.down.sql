@@ -0,0 +1 @@
+DROP INDEX IF EXISTS payments_processor_external_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX payments_processor_external_id_idx ON payments (processor_id, external_id);
@@ -0,0 +1,16 @@
+-- Domain events written in the same transaction as the change they describe
+CREATE TABLE outbox_events (
+    id             BIGSERIAL PRIMARY KEY,
+    event_id       TEXT NOT NULL UNIQUE,
+    event_type     TEXT NOT NULL,
+    aggregate_type TEXT NOT NULL,
+    aggregate_id   TEXT NOT NULL,
+    payload        JSONB NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL,
+    published_at   TIMESTAMPTZ,
+    attempts       INTEGER NOT NULL DEFAULT 0,
+    last_error     TEXT
+);
+
+CREATE INDEX outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
+CREATE INDEX outbox_events_aggregate_idx ON outbox_events (aggregate_type, aggregate_id);
@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS merchant_id,
+    DROP COLUMN IF EXISTS processor_fee;
@@ -0,0 +1,5 @@
+ALTER TABLE payments
+    ADD COLUMN merchant_id   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN processor_fee BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX payments_merchant_id_idx ON payments (merchant_id);
@@ -0,0 +1,39 @@
+CREATE TABLE ledger_accounts (
+    code       TEXT PRIMARY KEY,
+    type       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE journal_entries (
+    id              TEXT PRIMARY KEY,
+    -- Derived from the source event so redelivered events post once
+    idempotency_key TEXT NOT NULL UNIQUE,
+    description     TEXT NOT NULL,
+    payment_id      TEXT NOT NULL DEFAULT '',
+    occurred_at     TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL
+);
+
+-- Debits are positive, credits negative; each entry sums to zero per currency
+CREATE TABLE ledger_postings (
+    id           BIGSERIAL PRIMARY KEY,
+    entry_id     TEXT NOT NULL REFERENCES journal_entries (id),
+    account_code TEXT NOT NULL REFERENCES ledger_accounts (code),
+    currency     TEXT NOT NULL,
+    amount       BIGINT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX ledger_postings_account_idx ON ledger_postings (account_code, currency);
+CREATE INDEX journal_entries_occurred_at_idx ON journal_entries (occurred_at);
+
+-- Journal entries are immutable; corrections are posted as new entries
+CREATE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger rows are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_immutable BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+CREATE TRIGGER ledger_postings_immutable BEFORE UPDATE OR DELETE ON ledger_postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
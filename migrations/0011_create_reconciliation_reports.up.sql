@@ -0,0 +1,32 @@
+CREATE TABLE reconciliation_reports (
+    id                TEXT PRIMARY KEY,
+    processor_id      TEXT NOT NULL,
+    source            TEXT NOT NULL,
+    period_start      TIMESTAMPTZ NOT NULL,
+    period_end        TIMESTAMPTZ NOT NULL,
+    row_count         INT NOT NULL,
+    matched           INT NOT NULL,
+    missing           INT NOT NULL,
+    extra             INT NOT NULL,
+    amount_mismatched INT NOT NULL,
+    status_mismatched INT NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX reconciliation_reports_created_at_idx ON reconciliation_reports (created_at DESC);
+
+CREATE TABLE reconciliation_discrepancies (
+    id              BIGSERIAL PRIMARY KEY,
+    report_id       TEXT NOT NULL REFERENCES reconciliation_reports (id) ON DELETE CASCADE,
+    kind            TEXT NOT NULL,
+    payment_id      TEXT NOT NULL DEFAULT '',
+    reference       TEXT NOT NULL,
+    currency        TEXT NOT NULL,
+    expected_amount BIGINT NOT NULL,
+    actual_amount   BIGINT NOT NULL,
+    expected_status TEXT NOT NULL DEFAULT '',
+    actual_status   TEXT NOT NULL DEFAULT '',
+    detail          TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX reconciliation_discrepancies_report_idx ON reconciliation_discrepancies (report_id);
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN attempts JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN estimated_fee BIGINT NOT NULL DEFAULT 0;
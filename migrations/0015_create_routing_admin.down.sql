@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admin_audit_log;
+DROP TABLE IF EXISTS processor_settings;
+DROP TABLE IF EXISTS routing_rule_sets;
@@ -0,0 +1,27 @@
+-- Every change to the routing rules writes a new version; instances apply
+-- the latest
+CREATE TABLE routing_rule_sets (
+    version    BIGINT PRIMARY KEY,
+    config     JSONB NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE processor_settings (
+    processor_id TEXT PRIMARY KEY,
+    enabled      BOOLEAN NOT NULL,
+    updated_by   TEXT NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE admin_audit_log (
+    id         BIGSERIAL PRIMARY KEY,
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    target     TEXT NOT NULL,
+    before     JSONB,
+    after      JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX admin_audit_log_created_at_idx ON admin_audit_log (created_at DESC);
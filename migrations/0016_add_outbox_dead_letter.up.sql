@@ -0,0 +1,5 @@
+-- Events that keep failing are set aside so later events can still go out
+ALTER TABLE outbox_events ADD COLUMN dead_lettered_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS outbox_events_unpublished_idx;
+CREATE INDEX outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL AND dead_lettered_at IS NULL;
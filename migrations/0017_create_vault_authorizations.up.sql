@@ -0,0 +1,12 @@
+-- Reusable authorizations a provider issued for a vaulted card. Each is
+-- bound to the card token and the customer it was issued to, and sealed
+-- like the card number.
+CREATE TABLE vault_authorizations (
+    token          TEXT NOT NULL REFERENCES vault_cards (token) ON DELETE CASCADE,
+    processor      TEXT NOT NULL,
+    customer       TEXT NOT NULL,
+    encrypted_code BYTEA NOT NULL,
+    wrapped_key    BYTEA NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (token, processor, customer)
+);
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RoutingRuleSet is one stored version of the runtime routing rules.
+// Config is the rule set in the routing rules file format.
+type RoutingRuleSet struct {
+	Version   int64           `json:"version"`
+	Config    json.RawMessage `json:"config"`
+	CreatedBy string          `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ProcessorSetting is an operator's override for a registered processor
+type ProcessorSetting struct {
+	ProcessorID string    `json:"processor_id"`
+	Enabled     bool      `json:"enabled"`
+	UpdatedBy   string    `json:"updated_by"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AuditEntry records an admin change with the state before and after it
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
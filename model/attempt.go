@@ -0,0 +1,30 @@
+package model
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// ProcessorAttempt is one processor the router tried for a payment
+type ProcessorAttempt struct {
+	ProcessorID string    `json:"processor_id"`
+	Succeeded   bool      `json:"succeeded"`
+	Error       string    `json:"error,omitempty"`
+	DeclineCode string    `json:"decline_code,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// ProcessorAttempts is stored as a JSONB array
+type ProcessorAttempts []ProcessorAttempt
+
+func (a ProcessorAttempts) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("[]"), nil
+	}
+	return jsonValue([]ProcessorAttempt(a))
+}
+
+func (a *ProcessorAttempts) Scan(src interface{}) error {
+	return scanJSON(src, (*[]ProcessorAttempt)(a))
+}
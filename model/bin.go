@@ -0,0 +1,33 @@
+package model
+
+import "strings"
+
+// BINCountries maps card BIN prefixes to the issuing bank's country
+type BINCountries map[string]string
+
+// Country returns the country of the longest prefix matching bin, or ""
+func (b BINCountries) Country(bin string) string {
+	best := ""
+	for prefix := range b {
+		if strings.HasPrefix(bin, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return strings.ToUpper(b[best])
+}
+
+// CardCountry is the issuing country of the payment's card: the
+// bin_country detail when the client sent one, otherwise looked up by BIN
+func (p *Payment) CardCountry(bins BINCountries) string {
+	if country, ok := p.PaymentMethod.Details["bin_country"].(string); ok && country != "" {
+		return strings.ToUpper(country)
+	}
+	bin, _ := p.PaymentMethod.Details["bin"].(string)
+	if bin == "" {
+		return ""
+	}
+	return bins.Country(bin)
+}
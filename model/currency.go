@@ -0,0 +1,32 @@
+package model
+
+import (
+	"math"
+	"strings"
+)
+
+// zeroDecimalCurrencies have no minor unit; amounts in them are whole units
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+	"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// MinorUnitDigits is the number of decimal places of the currency's minor
+// unit, the unit payment amounts are kept in
+func MinorUnitDigits(currency string) int {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// ToMajorUnits converts an amount in minor units to the decimal amount
+// providers that quote major units expect, e.g. 1050 USD cents to 10.50
+func ToMajorUnits(amount int64, currency string) float64 {
+	return float64(amount) / math.Pow10(MinorUnitDigits(currency))
+}
+
+// FromMajorUnits converts a provider's decimal amount back to minor units
+func FromMajorUnits(amount float64, currency string) int64 {
+	return int64(math.Round(amount * math.Pow10(MinorUnitDigits(currency))))
+}
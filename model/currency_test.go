@@ -0,0 +1,26 @@
+package model
+
+import "testing"
+
+func TestMajorUnits(t *testing.T) {
+	tests := []struct {
+		currency string
+		minor    int64
+		major    float64
+	}{
+		{"USD", 1050, 10.5},
+		{"NGN", 150000, 1500},
+		{"UGX", 5000, 5000},
+		{"xof", 2500, 2500},
+		{"RWF", 100, 100},
+	}
+
+	for _, tt := range tests {
+		if got := ToMajorUnits(tt.minor, tt.currency); got != tt.major {
+			t.Errorf("ToMajorUnits(%d, %s) = %v, want %v", tt.minor, tt.currency, got, tt.major)
+		}
+		if got := FromMajorUnits(tt.major, tt.currency); got != tt.minor {
+			t.Errorf("FromMajorUnits(%v, %s) = %d, want %d", tt.major, tt.currency, got, tt.minor)
+		}
+	}
+}
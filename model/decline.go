@@ -0,0 +1,68 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeclineError is a charge refused by the issuer or the processor
+type DeclineError struct {
+	Processor string
+	// Code is the provider's decline code or gateway response
+	Code    string
+	Message string
+	// Soft declines are transient on the issuer's or processor's side and
+	// may succeed elsewhere; hard declines (stolen card, insufficient
+	// funds, fraud) must not be retried anywhere
+	Soft bool
+}
+
+func (e *DeclineError) Error() string {
+	kind := "hard"
+	if e.Soft {
+		kind = "soft"
+	}
+	if e.Message != "" && e.Message != e.Code {
+		return fmt.Sprintf("%s declined the charge (%s decline %s): %s", e.Processor, kind, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s declined the charge (%s decline %s)", e.Processor, kind, e.Code)
+}
+
+// isoSoftResponses are the issuer responses (ISO 8583 texts) relayed as
+// the decline code by processors fronting local acquirers
+var isoSoftResponses = map[string]bool{
+	"issuer or switch inoperative": true,
+	"issuer unavailable":           true,
+	"system malfunction":           true,
+	"system error":                 true,
+	"re-enter transaction":         true,
+	"transaction timed out":        true,
+}
+
+// softDeclines lists, per processor, the normalized decline codes that
+// signal a temporary problem rather than a refusal of the card. Only
+// exact codes count; free-text messages are never consulted.
+var softDeclines = map[string]map[string]bool{
+	"stripe": {
+		"try_again_later":      true,
+		"issuer_not_available": true,
+		"processing_error":     true,
+		"reenter_transaction":  true,
+		"approve_with_id":      true,
+	},
+	"flutterwave": isoSoftResponses,
+	"paystack":    isoSoftResponses,
+}
+
+// NewDeclineError builds a DeclineError, classifying the processor's code
+// as soft or hard. Unknown codes are hard.
+func NewDeclineError(processor, code, message string) *DeclineError {
+	soft := softDeclines[processor][normalizeDeclineCode(code)]
+	return &DeclineError{Processor: processor, Code: code, Message: message, Soft: soft}
+}
+
+// normalizeDeclineCode lower-cases the code and collapses whitespace
+func normalizeDeclineCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), " "))
+	return strings.TrimSuffix(code, ".")
+}
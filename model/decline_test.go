@@ -0,0 +1,32 @@
+package model
+
+import "testing"
+
+func TestNewDeclineErrorSoft(t *testing.T) {
+	tests := []struct {
+		processor, code, message string
+		soft                     bool
+	}{
+		{"stripe", "try_again_later", "", true},
+		{"stripe", "issuer_not_available", "Your card was declined.", true},
+		{"stripe", "insufficient_funds", "", false},
+		{"stripe", "do_not_honor", "Do not honour - try again later is not permitted", false},
+		{"stripe", "generic_decline", "Request timeout", false},
+		{"flutterwave", "Issuer or Switch Inoperative", "", true},
+		{"flutterwave", "  issuer or switch   inoperative. ", "", true},
+		{"flutterwave", "Do not try again", "", false},
+		{"flutterwave", "Do Not Honour", "timeout", false},
+		{"paystack", "System malfunction", "charge failed", true},
+		{"paystack", "Insufficient Funds", "try again", false},
+		// Codes only count for the processor that uses them
+		{"paystack", "try_again_later", "", false},
+		{"unknown", "try_again_later", "", false},
+	}
+
+	for _, tt := range tests {
+		got := NewDeclineError(tt.processor, tt.code, tt.message).Soft
+		if got != tt.soft {
+			t.Errorf("NewDeclineError(%q, %q, %q).Soft = %v, want %v", tt.processor, tt.code, tt.message, got, tt.soft)
+		}
+	}
+}
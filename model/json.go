@@ -0,0 +1,94 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Card fields that must never be persisted or echoed back. Only the last
+// four digits of the number are kept. Vault tokens are stored but left out
+// of JSON, which reaches API responses and merchant webhooks.
+var (
+	cardNumberKeys = []string{"number", "card_number", "pan"}
+	cardCodeKeys   = []string{"cvv", "cvc"}
+	cardTokenKeys  = []string{"token"}
+)
+
+// PaymentMethodDetails holds method specific data such as card expiry. It is
+// stored as JSONB with sensitive card fields removed.
+type PaymentMethodDetails map[string]interface{}
+
+// Redacted returns a copy without the PAN and CVV, adding last4 when the
+// card number was present.
+func (d PaymentMethodDetails) Redacted() PaymentMethodDetails {
+	if d == nil {
+		return nil
+	}
+	redacted := make(PaymentMethodDetails, len(d))
+	for k, v := range d {
+		redacted[k] = v
+	}
+	for _, key := range cardNumberKeys {
+		if number, ok := redacted[key].(string); ok && len(number) >= 4 {
+			if _, has := redacted["last4"]; !has {
+				redacted["last4"] = number[len(number)-4:]
+			}
+		}
+		delete(redacted, key)
+	}
+	for _, key := range cardCodeKeys {
+		delete(redacted, key)
+	}
+	return redacted
+}
+
+func (d PaymentMethodDetails) MarshalJSON() ([]byte, error) {
+	redacted := d.Redacted()
+	for _, key := range cardTokenKeys {
+		delete(redacted, key)
+	}
+	return json.Marshal(map[string]interface{}(redacted))
+}
+
+func (d PaymentMethodDetails) Value() (driver.Value, error) {
+	return jsonValue(map[string]interface{}(d.Redacted()))
+}
+
+func (d *PaymentMethodDetails) Scan(src interface{}) error {
+	return scanJSON(src, (*map[string]interface{})(d))
+}
+
+// Metadata is free-form merchant data stored as JSONB
+type Metadata map[string]string
+
+func (m Metadata) Value() (driver.Value, error) {
+	return jsonValue(map[string]string(m))
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	return scanJSON(src, (*map[string]string)(m))
+}
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func scanJSON(src interface{}, dest interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON column", src)
+	}
+	return json.Unmarshal(data, dest)
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// roundTrip stores v with Value and reads it back with Scan, handing the
+// value over as the driver would: as []byte, or as string when asString
+func roundTrip(t *testing.T, v driver.Valuer, dest interface{ Scan(interface{}) error }, asString bool) {
+	t.Helper()
+
+	stored, err := v.Value()
+	if err != nil {
+		t.Fatalf("Value() error = %v", err)
+	}
+	var src interface{} = stored
+	if b, ok := stored.([]byte); ok && asString {
+		src = string(b)
+	}
+	if err := dest.Scan(src); err != nil {
+		t.Fatalf("Scan(%v) error = %v", src, err)
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Metadata
+		want Metadata
+	}{
+		{"values", Metadata{"order_id": "ord_1", "email": "a@example.com"}, Metadata{"order_id": "ord_1", "email": "a@example.com"}},
+		{"empty", Metadata{}, Metadata{}},
+		{"nil", nil, nil},
+	}
+
+	for _, tt := range tests {
+		for _, asString := range []bool{false, true} {
+			var got Metadata
+			roundTrip(t, tt.in, &got, asString)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s (string %v): got %#v, want %#v", tt.name, asString, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestPaymentMethodDetailsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   PaymentMethodDetails
+		want PaymentMethodDetails
+	}{
+		{
+			"card redacted",
+			PaymentMethodDetails{"number": "4242424242424242", "cvv": "123", "exp_month": "12"},
+			PaymentMethodDetails{"last4": "4242", "exp_month": "12"},
+		},
+		{"token", PaymentMethodDetails{"token": "tok_1", "bin": "424242"}, PaymentMethodDetails{"token": "tok_1", "bin": "424242"}},
+		{"empty", PaymentMethodDetails{}, PaymentMethodDetails{}},
+		{"nil", nil, nil},
+	}
+
+	for _, tt := range tests {
+		for _, asString := range []bool{false, true} {
+			var got PaymentMethodDetails
+			roundTrip(t, tt.in, &got, asString)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s (string %v): got %#v, want %#v", tt.name, asString, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestPaymentMethodDetailsJSONOmitsToken(t *testing.T) {
+	details := PaymentMethodDetails{"token": "tok_1", "number": "4242424242424242", "cvv": "123", "brand": "visa"}
+
+	b, err := json.Marshal(details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"last4": "4242", "brand": "visa"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON = %s, want %v", b, want)
+	}
+}
+
+func TestProcessorAttemptsRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		in   ProcessorAttempts
+		want ProcessorAttempts
+	}{
+		{
+			"attempts",
+			ProcessorAttempts{
+				{ProcessorID: "stripe", Error: "declined", DeclineCode: "try_again_later", DurationMs: 120, AttemptedAt: at},
+				{ProcessorID: "paystack", Succeeded: true, DurationMs: 80, AttemptedAt: at},
+			},
+			ProcessorAttempts{
+				{ProcessorID: "stripe", Error: "declined", DeclineCode: "try_again_later", DurationMs: 120, AttemptedAt: at},
+				{ProcessorID: "paystack", Succeeded: true, DurationMs: 80, AttemptedAt: at},
+			},
+		},
+		{"empty", ProcessorAttempts{}, ProcessorAttempts{}},
+		// Stored as an empty array, never as NULL
+		{"nil", nil, ProcessorAttempts{}},
+	}
+
+	for _, tt := range tests {
+		for _, asString := range []bool{false, true} {
+			var got ProcessorAttempts
+			roundTrip(t, tt.in, &got, asString)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s (string %v): got %#v, want %#v", tt.name, asString, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRoutingDecisionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   RoutingDecision
+	}{
+		{"ordered", RoutingDecision{Rule: "ngn", Strategy: "ordered", Processors: []string{"paystack", "flutterwave"}}},
+		{"split", RoutingDecision{Rule: "ab", Strategy: "ordered", Processors: []string{"stripe"}, Split: "stripe"}},
+		{
+			"scored",
+			RoutingDecision{
+				Rule:       "scored",
+				Strategy:   "scored",
+				Processors: []string{"stripe"},
+				Scores:     []ProcessorScore{{ProcessorID: "stripe", Score: 0.9, Segment: "USD", Samples: 40, ApprovalRate: 0.95}},
+				Fees:       []FeeEstimate{{ProcessorID: "stripe", Fee: 320, Known: true}},
+				Ineligible: map[string]string{"paystack": "currency USD not supported"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, asString := range []bool{false, true} {
+			var got RoutingDecision
+			roundTrip(t, tt.in, &got, asString)
+			if !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("%s (string %v): got %#v, want %#v", tt.name, asString, got, tt.in)
+			}
+		}
+	}
+}
+
+func TestScanNULL(t *testing.T) {
+	metadata := Metadata{"kept": "yes"}
+	details := PaymentMethodDetails{"kept": "yes"}
+	var attempts ProcessorAttempts
+	var decision RoutingDecision
+
+	for _, dest := range []interface{ Scan(interface{}) error }{&metadata, &details, &attempts, &decision} {
+		if err := dest.Scan(nil); err != nil {
+			t.Errorf("Scan(nil) into %T error = %v", dest, err)
+		}
+	}
+	if metadata["kept"] != "yes" || details["kept"] != "yes" {
+		t.Error("Scan(nil) changed the destination")
+	}
+	if attempts != nil || decision.Rule != "" {
+		t.Errorf("Scan(nil) set attempts %v, decision %v", attempts, decision)
+	}
+}
+
+func TestScanRejectsOtherTypes(t *testing.T) {
+	var metadata Metadata
+	if err := metadata.Scan(42); err == nil {
+		t.Error("Scan(42) error = nil, want error")
+	}
+	var decision RoutingDecision
+	if err := decision.Scan([]byte("not json")); err == nil {
+		t.Error("Scan(invalid JSON) error = nil, want error")
+	}
+}
@@ -6,26 +6,56 @@ import "time"
 type PaymentStatus string
 
 const (
-	StatusPending    PaymentStatus = "pending"
-	StatusAuthorized PaymentStatus = "authorized"
-	StatusCompleted  PaymentStatus = "completed"
-	StatusFailed     PaymentStatus = "failed"
-	StatusRefunded   PaymentStatus = "refunded"
+	StatusPending           PaymentStatus = "pending"
+	StatusAuthorized        PaymentStatus = "authorized"
+	StatusCaptured          PaymentStatus = "captured"
+	StatusCompleted         PaymentStatus = "completed"
+	StatusVoided            PaymentStatus = "voided"
+	StatusFailed            PaymentStatus = "failed"
+	StatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	StatusRefunded          PaymentStatus = "refunded"
+	StatusDisputed          PaymentStatus = "disputed"
 )
 
 type Payment struct {
-	ID            string
-	ExternalID    string
-	Amount        int64
-	Currency      string
-	Status        PaymentStatus
-	PaymentMethod PaymentMethod
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Metadata      map[string]string
+	ID            string        `json:"id"`
+	ExternalID    string        `json:"external_id,omitempty"`
+	Amount        int64         `json:"amount"`
+	Currency      string        `json:"currency"`
+	Status        PaymentStatus `json:"status"`
+	PaymentMethod PaymentMethod `json:"payment_method"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	Metadata      Metadata      `json:"metadata,omitempty"`
+
+	// ProcessorID is the ID the handling processor was registered under in
+	// the router. Capture and refund are always sent back to it.
+	ProcessorID string `json:"processor_id,omitempty"`
+	// ProcessorPaymentID is the provider-side object ID, e.g. the Stripe
+	// PaymentIntent ID or the Flutterwave transaction ID.
+	ProcessorPaymentID string `json:"processor_payment_id,omitempty"`
+
+	CapturedAmount int64 `json:"captured_amount"`
+	RefundedAmount int64 `json:"refunded_amount"`
+
+	// MerchantID identifies whose balance the payment settles to
+	MerchantID string `json:"merchant_id,omitempty"`
+	// ProcessorFee is the fee the processor withheld, once reported
+	ProcessorFee int64 `json:"processor_fee,omitempty"`
+	// EstimatedFee is the fee schedule's estimate for the processor used
+	EstimatedFee int64 `json:"estimated_fee,omitempty"`
+	// Attempts lists the processors tried, in order, when authorizing
+	Attempts ProcessorAttempts `json:"attempts,omitempty"`
+	// Routing is how the router chose the processors
+	Routing *RoutingDecision `json:"routing,omitempty"`
 }
 
 type PaymentMethod struct {
-	Type    string
-	Details map[string]interface{}
-}
\ No newline at end of file
+	Type    string               `json:"type"`
+	Details PaymentMethodDetails `json:"details,omitempty"`
+}
+
+// RefundableAmount is what is left to refund of the captured amount
+func (p *Payment) RefundableAmount() int64 {
+	return p.CapturedAmount - p.RefundedAmount
+}
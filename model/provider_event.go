@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// ProviderEvent is a verified webhook notification from a payment provider
+type ProviderEvent struct {
+	Provider string
+	// EventID is unique per provider and used to drop redeliveries
+	EventID string
+	Type    string
+	// Reference identifies the payment at the provider: our ExternalID or
+	// the provider's own payment ID
+	Reference string
+	// Status is the payment status the event implies, empty when the event
+	// does not change the payment
+	Status     PaymentStatus
+	Payload    []byte
+	ReceivedAt time.Time
+}
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// DiscrepancyKind classifies a reconciliation finding
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyMissing is a settled payment of ours absent from the report
+	DiscrepancyMissing DiscrepancyKind = "missing"
+	// DiscrepancyExtra is a report row that matches none of our payments
+	DiscrepancyExtra          DiscrepancyKind = "extra"
+	DiscrepancyAmountMismatch DiscrepancyKind = "amount_mismatch"
+	DiscrepancyStatusMismatch DiscrepancyKind = "status_mismatch"
+)
+
+// ReconciliationReport summarises one settlement file checked against the
+// payments table
+type ReconciliationReport struct {
+	ID          string    `json:"id"`
+	ProcessorID string    `json:"processor_id"`
+	Source      string    `json:"source"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Rows        int       `json:"rows"`
+	Matched     int       `json:"matched"`
+	Missing     int       `json:"missing"`
+	Extra       int       `json:"extra"`
+	// AmountMismatched and StatusMismatched count rows, a row can be both
+	AmountMismatched int                          `json:"amount_mismatched"`
+	StatusMismatched int                          `json:"status_mismatched"`
+	CreatedAt        time.Time                    `json:"created_at"`
+	Discrepancies    []*ReconciliationDiscrepancy `json:"discrepancies,omitempty"`
+}
+
+// ReconciliationDiscrepancy is one finding. Expected values come from our
+// payments table, actual values from the processor's report.
+type ReconciliationDiscrepancy struct {
+	Kind           DiscrepancyKind `json:"kind"`
+	PaymentID      string          `json:"payment_id,omitempty"`
+	Reference      string          `json:"reference"`
+	Currency       string          `json:"currency"`
+	ExpectedAmount int64           `json:"expected_amount"`
+	ActualAmount   int64           `json:"actual_amount"`
+	ExpectedStatus string          `json:"expected_status,omitempty"`
+	ActualStatus   string          `json:"actual_status,omitempty"`
+	Detail         string          `json:"detail,omitempty"`
+}
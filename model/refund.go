@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Refund is a single, possibly partial, refund against a captured payment
+type Refund struct {
+	ID        string       `json:"id"`
+	PaymentID string       `json:"payment_id"`
+	Amount    int64        `json:"amount"`
+	Currency  string       `json:"currency"`
+	Reason    string       `json:"reason,omitempty"`
+	Status    RefundStatus `json:"status"`
+	// ProcessorRefundID is the provider-side refund object ID
+	ProcessorRefundID string    `json:"processor_refund_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
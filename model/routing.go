@@ -0,0 +1,54 @@
+package model
+
+import "database/sql/driver"
+
+// RoutingDecision records how the router chose processors for a payment
+type RoutingDecision struct {
+	Rule     string `json:"rule"`
+	Strategy string `json:"strategy"`
+	// Processors in the order they were to be tried
+	Processors []string `json:"processors"`
+	// Split is the processor a weighted rule assigned the payment to
+	Split string `json:"split,omitempty"`
+	// Scores break down a scored rule's ranking, best first
+	Scores []ProcessorScore `json:"scores,omitempty"`
+	// Fees are a cheapest rule's estimates, cheapest first
+	Fees []FeeEstimate `json:"fees,omitempty"`
+	// Ineligible lists processors left out because they cannot handle
+	// the payment, with the reason
+	Ineligible map[string]string `json:"ineligible,omitempty"`
+}
+
+// FeeEstimate is what a processor was expected to charge. Known is false
+// when its fee schedule does not cover the payment.
+type FeeEstimate struct {
+	ProcessorID string `json:"processor_id"`
+	Fee         int64  `json:"fee"`
+	Known       bool   `json:"known"`
+}
+
+// ProcessorScore is one processor's standing when a scored rule ranked it
+type ProcessorScore struct {
+	ProcessorID string  `json:"processor_id"`
+	Score       float64 `json:"score"`
+	// Segment is the statistics segment the score was based on, e.g.
+	// "NGN:506099", "NGN" or "" for all of the processor's traffic
+	Segment      string  `json:"segment"`
+	Samples      int     `json:"samples"`
+	ApprovalRate float64 `json:"approval_rate"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyMs    float64 `json:"latency_ms"`
+}
+
+// RoutingDecision is stored as JSONB; payments from before routing was
+// recorded have none
+func (d RoutingDecision) Value() (driver.Value, error) {
+	return jsonValue(d)
+}
+
+func (d *RoutingDecision) Scan(src interface{}) error {
+	return scanJSON(src, (*routingDecision)(d))
+}
+
+// routingDecision drops RoutingDecision's methods so scanJSON does not recurse
+type routingDecision RoutingDecision
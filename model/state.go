@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTransition is matched by every TransitionError
+var ErrInvalidTransition = errors.New("invalid payment status transition")
+
+// TransitionError reports a status change the state machine does not allow
+type TransitionError struct {
+	From PaymentStatus
+	To   PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid payment status transition from %q to %q", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// transitions lists the statuses reachable from each status. Completed is a
+// single-step sale (authorized and captured at once) and behaves like captured.
+var transitions = map[PaymentStatus][]PaymentStatus{
+	StatusPending:           {StatusAuthorized, StatusCaptured, StatusCompleted, StatusFailed},
+	StatusAuthorized:        {StatusCaptured, StatusVoided, StatusFailed},
+	StatusCaptured:          {StatusPartiallyRefunded, StatusRefunded, StatusDisputed},
+	StatusCompleted:         {StatusPartiallyRefunded, StatusRefunded, StatusDisputed},
+	StatusPartiallyRefunded: {StatusRefunded, StatusDisputed},
+	StatusDisputed:          {StatusCaptured, StatusRefunded},
+	StatusVoided:            {},
+	StatusFailed:            {},
+	StatusRefunded:          {},
+}
+
+// IsTerminal reports whether no further transitions are possible
+func (s PaymentStatus) IsTerminal() bool {
+	return len(transitions[s]) == 0
+}
+
+// ValidateTransition checks that a payment may move from one status to
+// another. Staying in the same status is always allowed.
+func ValidateTransition(from, to PaymentStatus) error {
+	if from == to {
+		return nil
+	}
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// Complete records a single-step sale: the payment moves to completed with
+// its full amount captured, so the completion is saved and published with
+// the amount already set
+func (p *Payment) Complete() error {
+	if err := p.TransitionTo(StatusCompleted); err != nil {
+		return err
+	}
+	if p.CapturedAmount == 0 {
+		p.CapturedAmount = p.Amount
+	}
+	return nil
+}
+
+// TransitionTo moves the payment to a new status if the state machine allows it
+func (p *Payment) TransitionTo(status PaymentStatus) error {
+	if err := ValidateTransition(p.Status, status); err != nil {
+		return err
+	}
+	if p.Status != status {
+		p.Status = status
+		p.UpdatedAt = time.Now().UTC()
+	}
+	return nil
+}
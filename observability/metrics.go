@@ -1,6 +1,8 @@
 package observability
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -21,9 +23,71 @@ var (
 		},
 		[]string{"method"},
 	)
+
+	processorAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "processor_call_attempts_total",
+			Help: "Calls made to payment processors, by outcome",
+		},
+		[]string{"processor", "operation", "outcome"},
+	)
+
+	processorAttemptDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "processor_call_duration_seconds",
+			Help:    "Duration of individual calls to payment processors",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10},
+		},
+		[]string{"processor", "operation"},
+	)
+
+	circuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "processor_circuit_state",
+			Help: "Circuit breaker state per processor; the current state is 1",
+		},
+		[]string{"processor", "state"},
+	)
+
+	routingSplitPayments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_split_payments_total",
+			Help: "Payments routed by weighted rules, by the split they were assigned and outcome",
+		},
+		[]string{"rule", "processor", "outcome"},
+	)
 )
 
+var circuitStates = []string{"closed", "open", "half_open"}
+
 func init() {
 	prometheus.MustRegister(paymentRequests)
 	prometheus.MustRegister(paymentProcessingTime)
-}
\ No newline at end of file
+	prometheus.MustRegister(processorAttempts)
+	prometheus.MustRegister(processorAttemptDuration)
+	prometheus.MustRegister(circuitState)
+	prometheus.MustRegister(routingSplitPayments)
+}
+
+// ObserveProcessorAttempt records one call to a processor
+func ObserveProcessorAttempt(processor, operation, outcome string, elapsed time.Duration) {
+	processorAttempts.WithLabelValues(processor, operation, outcome).Inc()
+	processorAttemptDuration.WithLabelValues(processor, operation).Observe(elapsed.Seconds())
+}
+
+// SetCircuitState records a processor's circuit breaker state
+func SetCircuitState(processor, state string) {
+	for _, s := range circuitStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		circuitState.WithLabelValues(processor, s).Set(value)
+	}
+}
+
+// ObserveSplitPayment records the outcome of a payment a weighted rule
+// assigned to processor
+func ObserveSplitPayment(rule, processor, outcome string) {
+	routingSplitPayments.WithLabelValues(rule, processor, outcome).Inc()
+}
@@ -0,0 +1,45 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/vault"
+)
+
+// CardVault resolves card tokens into card data just before a provider call
+type CardVault interface {
+	Detokenize(ctx context.Context, token string) (*vault.Card, error)
+}
+
+// AuthorizationVault also keeps the reusable authorizations a provider
+// issues for a vaulted card, bound to the customer they were issued to.
+// Authorization returns vault.ErrAuthorizationNotFound when there is none.
+type AuthorizationVault interface {
+	CardVault
+	Authorization(ctx context.Context, token, processor, customer string) (string, error)
+	SaveAuthorization(ctx context.Context, token, processor, customer, code string) error
+}
+
+// cardFromPayment detokenizes the card referenced by the payment method.
+// Within a vault session the card is detokenized once, so processors
+// tried after the first still get the CVV. Adapters must not keep the
+// result beyond the provider request.
+func cardFromPayment(ctx context.Context, cards CardVault, payment *model.Payment) (*vault.Card, error) {
+	token, _ := payment.PaymentMethod.Details["token"].(string)
+	if token == "" {
+		return nil, errors.New("card payment has no vault token")
+	}
+	if card, ok := vault.SessionCard(ctx, token); ok {
+		return card, nil
+	}
+
+	card, err := cards.Detokenize(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detokenize card: %w", err)
+	}
+	vault.KeepForSession(ctx, token, card)
+	return card, nil
+}
@@ -0,0 +1,115 @@
+package processors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/vault"
+)
+
+// onceVault hands out the CVV once per token, like the real vault, and
+// keeps authorizations by token, processor and customer
+type onceVault struct {
+	cvvs           map[string]string
+	authorizations map[string]string
+}
+
+func (v *onceVault) Detokenize(ctx context.Context, token string) (*vault.Card, error) {
+	cvv := v.cvvs[token]
+	delete(v.cvvs, token)
+	return &vault.Card{Number: "4242424242424242", CVV: cvv, ExpMonth: "12", ExpYear: "2030"}, nil
+}
+
+func (v *onceVault) Authorization(ctx context.Context, token, processor, customer string) (string, error) {
+	code, ok := v.authorizations[token+"/"+processor+"/"+customer]
+	if !ok {
+		return "", vault.ErrAuthorizationNotFound
+	}
+	return code, nil
+}
+
+func (v *onceVault) SaveAuthorization(ctx context.Context, token, processor, customer, code string) error {
+	if v.authorizations == nil {
+		v.authorizations = make(map[string]string)
+	}
+	v.authorizations[token+"/"+processor+"/"+customer] = code
+	return nil
+}
+
+// cardProcessor reads the card as an adapter would, then returns err
+type cardProcessor struct {
+	cards CardVault
+	err   error
+	cvv   string
+}
+
+func (p *cardProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
+	card, err := cardFromPayment(ctx, p.cards, payment)
+	if err != nil {
+		return err
+	}
+	p.cvv = card.CVV
+	if p.err != nil {
+		return p.err
+	}
+	return payment.TransitionTo(model.StatusCompleted)
+}
+
+func (p *cardProcessor) Capture(ctx context.Context, paymentID string, amount int64) error {
+	return nil
+}
+func (p *cardProcessor) Refund(ctx context.Context, refund *model.Refund) error { return nil }
+func (p *cardProcessor) Void(ctx context.Context, paymentID string) error       { return nil }
+
+func TestCascadedAuthorizationKeepsCVV(t *testing.T) {
+	cards := &onceVault{cvvs: map[string]string{"tok_1": "123"}}
+	first := &cardProcessor{cards: cards, err: &model.DeclineError{Processor: "first", Code: "issuer_not_available", Soft: true}}
+	second := &cardProcessor{cards: cards}
+
+	router := engine.NewProcessorRouter()
+	router.RegisterProcessor("first", first)
+	router.RegisterProcessor("second", second)
+	if err := router.ReplaceRules([]engine.RoutingRule{{
+		Name:         "cascade",
+		Condition:    func(*model.Payment) bool { return true },
+		ProcessorIDs: []string{"first", "second"},
+	}}, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	payment := &model.Payment{
+		ID:            "pay_1",
+		Amount:        1000,
+		Currency:      "USD",
+		Status:        model.StatusPending,
+		PaymentMethod: model.PaymentMethod{Type: "card", Details: model.PaymentMethodDetails{"token": "tok_1"}},
+	}
+	if err := router.Authorize(context.Background(), payment); err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	if first.cvv != "123" || second.cvv != "123" {
+		t.Errorf("processors got CVVs %q and %q, want both %q", first.cvv, second.cvv, "123")
+	}
+	if payment.ProcessorID != "second" {
+		t.Errorf("ProcessorID = %q, want second", payment.ProcessorID)
+	}
+}
+
+func TestSessionEndsWithAuthorization(t *testing.T) {
+	ctx, release := vault.WithSession(context.Background())
+	vault.KeepForSession(ctx, "tok_1", &vault.Card{CVV: "123"})
+	if _, ok := vault.SessionCard(ctx, "tok_1"); !ok {
+		t.Fatal("card not kept for the session")
+	}
+
+	release()
+	if _, ok := vault.SessionCard(ctx, "tok_1"); ok {
+		t.Error("card still available after release")
+	}
+	if _, ok := vault.SessionCard(context.Background(), "tok_1"); ok {
+		t.Error("card available without a session")
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/thoraf20/payment-processor/engine"
 	"github.com/thoraf20/payment-processor/model"
 	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/retry"
 	"go.uber.org/zap"
 )
 
@@ -23,6 +27,8 @@ type FlutterwaveProcessor struct {
 	baseURL    string
 	httpClient *http.Client
 	repo       repository.PaymentRepository
+	cards      CardVault
+	retrier    *retry.Retrier
 	logger     *zap.Logger
 }
 
@@ -35,15 +41,18 @@ func convertToMapInterface(input map[string]string) map[string]interface{} {
 	return converted
 }
 
-func NewFlutterwaveProcessor(apiKey string, repo repository.PaymentRepository, logger *zap.Logger) *FlutterwaveProcessor {
+func NewFlutterwaveProcessor(apiKey string, repo repository.PaymentRepository, cards CardVault, logger *zap.Logger) *FlutterwaveProcessor {
+	logger = logger.With(zap.String("processor", "flutterwave"))
 	return &FlutterwaveProcessor{
 		apiKey:  apiKey,
 		baseURL: flutterwaveBaseURL,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
-		repo:   repo,
-		logger: logger.With(zap.String("processor", "flutterwave")),
+		repo:    repo,
+		cards:   cards,
+		retrier: retry.NewRetrier(retry.DefaultPolicy, logger),
+		logger:  logger,
 	}
 }
 
@@ -76,17 +85,28 @@ type flutterwaveResponse struct {
 		AuthModel    string `json:"auth_model"`
 		Currency     string `json:"currency"`
 		Amount       float64 `json:"amount"`
+		AppFee       float64 `json:"app_fee"`
 		RedirectURL  string `json:"redirect_url"`
 	} `json:"data"`
 }
 
+// Capabilities: card charges, captured on authorization
+func (f *FlutterwaveProcessor) Capabilities() engine.Capabilities {
+	return engine.Capabilities{
+		Currencies:     []string{"NGN", "USD", "GBP", "EUR", "GHS", "KES", "ZAR", "UGX", "TZS", "RWF", "XAF", "XOF"},
+		PaymentMethods: []string{"card"},
+		PartialRefunds: true,
+		ThreeDSecure:   true,
+	}
+}
+
 func (f *FlutterwaveProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
 
 	// Generate unique transaction reference
 	txRef := fmt.Sprintf("flw-%s-%d", payment.ID, time.Now().Unix())
 
 	reqBody := flutterwaveChargeRequest{
-		Amount:     float64(payment.Amount) / 100, // Convert to currency unit
+		Amount:     model.ToMajorUnits(payment.Amount, payment.Currency),
 		Currency:   payment.Currency,
 		TxRef:      txRef,
 		PaymentType: "card",
@@ -95,13 +115,16 @@ func (f *FlutterwaveProcessor) Authorize(ctx context.Context, payment *model.Pay
 
 	// Add card details if present
 	if payment.PaymentMethod.Type == "card" {
-		details := payment.PaymentMethod.Details
-			reqBody.Card = flutterwaveCardDetails{
-				Number:      details["number"].(string),
-				Cvv:         details["cvv"].(string),
-				ExpiryMonth: details["exp_month"].(string),
-				ExpiryYear:  details["exp_year"].(string),
-			}
+		card, err := cardFromPayment(ctx, f.cards, payment)
+		if err != nil {
+			return err
+		}
+		reqBody.Card = flutterwaveCardDetails{
+			Number:      card.Number,
+			Cvv:         card.CVV,
+			ExpiryMonth: card.ExpMonth,
+			ExpiryYear:  card.ExpYear,
+		}
 	}
 
 	// Save initial payment state with Flutterwave reference
@@ -110,19 +133,26 @@ func (f *FlutterwaveProcessor) Authorize(ctx context.Context, payment *model.Pay
 		return fmt.Errorf("failed to save payment: %w", err)
 	}
 
-	resp, err := f.makeRequest(ctx, "/charges?type=card", reqBody)
+	resp, err := f.makeRequest(ctx, "charge", http.MethodPost, "/charges?type=card", reqBody)
 	if err != nil {
 		return fmt.Errorf("flutterwave API error: %w", err)
 	}
 
+	payment.ProcessorPaymentID = strconv.Itoa(resp.Data.ID)
+	if resp.Data.AppFee > 0 {
+		payment.ProcessorFee = model.FromMajorUnits(resp.Data.AppFee, payment.Currency)
+	}
+
 	switch resp.Data.Status {
 	case "successful":
-		payment.Status = model.StatusCompleted
+		if err := payment.Complete(); err != nil {
+			return err
+		}
 	case "pending":
-		payment.Status = model.StatusPending
+		// Stays pending until the transaction is verified
 	default:
-		payment.Status = model.StatusFailed
-		return fmt.Errorf("payment failed: %s", resp.Data.Processor)
+		// The engine records the failure
+		return model.NewDeclineError("flutterwave", resp.Data.Processor, resp.Message)
 	}
 
 	// Update payment with processor response
@@ -137,7 +167,7 @@ func (f *FlutterwaveProcessor) Capture(ctx context.Context, paymentID string, am
 	}
 
 	// external call to flutterwave to verify transaction
-	resp, err := f.makeRequest(ctx, fmt.Sprintf("/transactions/%s/verify", payment.ExternalID), nil)
+	resp, err := f.makeRequest(ctx, "verify", http.MethodGet, fmt.Sprintf("/transactions/%s/verify", payment.ProcessorPaymentID), nil)
 	if err != nil {
 		return err
 	}
@@ -146,23 +176,28 @@ func (f *FlutterwaveProcessor) Capture(ctx context.Context, paymentID string, am
 		return fmt.Errorf("cannot capture - transaction status: %s", resp.Data.Status)
 	}
 
-	payment.Status = model.StatusCompleted
-	return f.repo.Save(ctx, payment)
+	return nil
 }
 
-func (f *FlutterwaveProcessor) Refund(ctx context.Context, paymentID string, amount int64) error {
-	payment, err := f.repo.Get(ctx, paymentID)
+// Void is not offered by Flutterwave; card charges settle immediately and
+// have to be refunded instead
+func (f *FlutterwaveProcessor) Void(ctx context.Context, paymentID string) error {
+	return errors.New("flutterwave does not support voiding payments")
+}
+
+func (f *FlutterwaveProcessor) Refund(ctx context.Context, refund *model.Refund) error {
+	payment, err := f.repo.Get(ctx, refund.PaymentID)
 	if err != nil {
 		return fmt.Errorf("failed to get payment: %w", err)
 	}
 
 	req := struct {
-		Amount int64 `json:"amount"`
+		Amount float64 `json:"amount"`
 	}{
-		Amount: amount,
+		Amount: model.ToMajorUnits(refund.Amount, refund.Currency),
 	}
 
-	resp, err := f.makeRequest(ctx, fmt.Sprintf("/transactions/%s/refund", payment.ExternalID), req)
+	resp, err := f.makeRequest(ctx, "refund", http.MethodPost, fmt.Sprintf("/transactions/%s/refund", payment.ProcessorPaymentID), req)
 	if err != nil {
 		return err
 	}
@@ -171,11 +206,16 @@ func (f *FlutterwaveProcessor) Refund(ctx context.Context, paymentID string, amo
 		return fmt.Errorf("refund failed: %s", resp.Message)
 	}
 
-	payment.Status = model.StatusRefunded
-	return f.repo.Save(ctx, payment)
+	refund.ProcessorRefundID = strconv.Itoa(resp.Data.ID)
+	if resp.Data.Status == "completed" {
+		refund.Status = model.RefundSucceeded
+	}
+	return nil
 }
 
-func (f *FlutterwaveProcessor) makeRequest(ctx context.Context, path string, body interface{}) (*flutterwaveResponse, error) {
+// makeRequest calls the Flutterwave API through the retrier. Only GETs are
+// retried freely; a repeated POST could charge or refund twice.
+func (f *FlutterwaveProcessor) makeRequest(ctx context.Context, operation, method, path string, body interface{}) (*flutterwaveResponse, error) {
 	url := f.baseURL + path
 
 	var reqBody []byte
@@ -187,37 +227,49 @@ func (f *FlutterwaveProcessor) makeRequest(ctx context.Context, path string, bod
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	var result flutterwaveResponse
+	call := retry.Call{Processor: "flutterwave", Operation: operation, Idempotent: method == http.MethodGet}
+	err := f.retrier.Do(ctx, call, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+f.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+f.apiKey)
 
-	f.logger.Debug("Making request to Flutterwave",
-		zap.String("url", url),
-		zap.Any("request", body),
-	)
+		// The request body is not logged: it can carry card data
+		f.logger.Debug("Making request to Flutterwave",
+			zap.String("method", method),
+			zap.String("url", url),
+		)
 
-	resp, err := f.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
-	}
-	defer resp.Body.Close()
+		resp, err := f.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("HTTP request failed: %w", err)
+		}
+		defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		var errorResp struct {
-			Message string `json:"message"`
+		if resp.StatusCode >= 400 {
+			var errorResp struct {
+				Message string `json:"message"`
+			}
+			json.NewDecoder(resp.Body).Decode(&errorResp)
+			return fmt.Errorf("flutterwave error: %w", &retry.StatusError{
+				StatusCode: resp.StatusCode,
+				Message:    errorResp.Message,
+				Wait:       retry.ParseRetryAfter(resp.Header.Get("Retry-After")),
+			})
 		}
-		json.NewDecoder(resp.Body).Decode(&errorResp)
-		return nil, fmt.Errorf("flutterwave error: %s", errorResp.Message)
-	}
 
-	var result flutterwaveResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &result, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,182 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/retry"
+	"go.uber.org/zap"
+)
+
+// testRetrier retries like the default policy without the waiting
+var testRetrier = retry.NewRetrier(retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, zap.NewNop())
+
+// stubPayments keeps payments in memory; adapters only Save and Get
+type stubPayments struct {
+	repository.PaymentRepository
+	mu       sync.Mutex
+	payments map[string]model.Payment
+}
+
+func newStubPayments(payments ...*model.Payment) *stubPayments {
+	s := &stubPayments{payments: make(map[string]model.Payment)}
+	for _, payment := range payments {
+		s.payments[payment.ID] = *payment
+	}
+	return s
+}
+
+func (s *stubPayments) Save(ctx context.Context, payment *model.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments[payment.ID] = *payment
+	return nil
+}
+
+func (s *stubPayments) Get(ctx context.Context, id string) (*model.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[id]
+	if !ok {
+		return nil, repository.ErrPaymentNotFound
+	}
+	return &payment, nil
+}
+
+// scriptedServer answers the nth request with responses[n], repeating the
+// last one, and counts the requests it gets
+func scriptedServer(t *testing.T, path string, responses ...func(w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected request to %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		n := int(hits.Add(1)) - 1
+		if n >= len(responses) {
+			n = len(responses) - 1
+		}
+		responses[n](w)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func respond(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func cardPayment(id string) *model.Payment {
+	return &model.Payment{
+		ID:            id,
+		Amount:        1000,
+		Currency:      "NGN",
+		Status:        model.StatusPending,
+		Metadata:      map[string]string{"email": "customer@example.com"},
+		PaymentMethod: model.PaymentMethod{Type: "card", Details: model.PaymentMethodDetails{"token": "tok_1"}},
+	}
+}
+
+func newTestFlutterwave(baseURL string, repo repository.PaymentRepository) *FlutterwaveProcessor {
+	f := NewFlutterwaveProcessor("test-key", repo, &onceVault{cvvs: map[string]string{"tok_1": "123"}}, zap.NewNop())
+	f.baseURL = baseURL
+	f.retrier = testRetrier
+	return f
+}
+
+func TestFlutterwaveRetriesVerify(t *testing.T) {
+	srv, hits := scriptedServer(t, "/transactions/42/verify",
+		respond(http.StatusServiceUnavailable, `{"status":"error","message":"unavailable"}`),
+		respond(http.StatusTooManyRequests, `{"status":"error","message":"slow down"}`),
+		respond(http.StatusOK, `{"status":"success","data":{"id":42,"status":"successful"}}`),
+	)
+	payment := cardPayment("pay_1")
+	payment.ProcessorPaymentID = "42"
+	f := newTestFlutterwave(srv.URL, newStubPayments(payment))
+
+	if err := f.Capture(context.Background(), "pay_1", 1000); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("verify called %d times, want 3", got)
+	}
+}
+
+func TestFlutterwaveDoesNotRetryCharge(t *testing.T) {
+	srv, hits := scriptedServer(t, "/charges",
+		respond(http.StatusInternalServerError, `{"status":"error","message":"internal error"}`),
+	)
+	f := newTestFlutterwave(srv.URL, newStubPayments())
+
+	err := f.Authorize(context.Background(), cardPayment("pay_1"))
+	if err == nil {
+		t.Fatal("Authorize() succeeded, want error")
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("charge called %d times, want 1", got)
+	}
+}
+
+func TestFlutterwaveDecline(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantSoft bool
+	}{
+		{"hard", "Insufficient Funds", false},
+		{"soft", "Issuer or Switch Inoperative", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := scriptedServer(t, "/charges",
+				respond(http.StatusOK, `{"status":"success","message":"Charge failed","data":{"id":7,"status":"failed","processor_response":"`+tt.response+`"}}`),
+			)
+			f := newTestFlutterwave(srv.URL, newStubPayments())
+
+			err := f.Authorize(context.Background(), cardPayment("pay_1"))
+			var decline *model.DeclineError
+			if !errors.As(err, &decline) {
+				t.Fatalf("Authorize() error = %v, want a DeclineError", err)
+			}
+			if decline.Processor != "flutterwave" || decline.Code != tt.response || decline.Soft != tt.wantSoft {
+				t.Errorf("decline = %+v, want code %q soft %v", decline, tt.response, tt.wantSoft)
+			}
+			if got := hits.Load(); got != 1 {
+				t.Errorf("charge called %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestFlutterwaveCompletionCarriesCapturedAmount(t *testing.T) {
+	srv, _ := scriptedServer(t, "/charges",
+		respond(http.StatusOK, `{"status":"success","message":"Charge completed","data":{"id":7,"status":"successful","app_fee":14}}`),
+	)
+	repo := newStubPayments()
+	f := newTestFlutterwave(srv.URL, repo)
+
+	if err := f.Authorize(context.Background(), cardPayment("pay_1")); err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	// The save that completes the payment publishes payment.completed,
+	// which the ledger posts from
+	saved, _ := repo.Get(context.Background(), "pay_1")
+	if saved.Status != model.StatusCompleted || saved.CapturedAmount != 1000 {
+		t.Errorf("saved payment %s with captured amount %d, want completed with 1000", saved.Status, saved.CapturedAmount)
+	}
+}
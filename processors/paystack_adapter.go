@@ -0,0 +1,351 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/retry"
+	"github.com/thoraf20/payment-processor/vault"
+	"go.uber.org/zap"
+)
+
+const paystackBaseURL = "https://api.paystack.co"
+
+// PaystackError is a failed Paystack call or a declined charge
+type PaystackError struct {
+	StatusCode      int
+	Message         string
+	GatewayResponse string
+	RetryAfter      time.Duration
+}
+
+func (e *PaystackError) Error() string {
+	if e.GatewayResponse != "" {
+		return fmt.Sprintf("paystack error (%d): %s: %s", e.StatusCode, e.Message, e.GatewayResponse)
+	}
+	return fmt.Sprintf("paystack error (%d): %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the call failed in a way worth retrying;
+// declines never are
+func (e *PaystackError) Retryable() bool {
+	return retry.RetryableStatus(e.StatusCode)
+}
+
+func (e *PaystackError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+func (e *PaystackError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+type PaystackProcessor struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+	repo       repository.PaymentRepository
+	cards      AuthorizationVault
+	retrier    *retry.Retrier
+	logger     *zap.Logger
+}
+
+// NewPaystackProcessor creates the adapter. An empty baseURL uses the live API.
+func NewPaystackProcessor(apiKey, baseURL string, repo repository.PaymentRepository, cards AuthorizationVault, logger *zap.Logger) *PaystackProcessor {
+	if baseURL == "" {
+		baseURL = paystackBaseURL
+	}
+	logger = logger.With(zap.String("processor", "paystack"))
+	return &PaystackProcessor{
+		apiKey:  apiKey,
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		repo:    repo,
+		cards:   cards,
+		retrier: retry.NewRetrier(retry.DefaultPolicy, logger),
+		logger:  logger,
+	}
+}
+
+// Paystack API Request/Response Types. Amounts are in the currency subunit
+// (kobo for NGN), which is how payments store them already.
+type paystackResponse struct {
+	Status  bool            `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type paystackCard struct {
+	Number      string `json:"number"`
+	Cvv         string `json:"cvv,omitempty"`
+	ExpiryMonth string `json:"expiry_month"`
+	ExpiryYear  string `json:"expiry_year"`
+}
+
+type paystackChargeRequest struct {
+	Email             string            `json:"email"`
+	Amount            int64             `json:"amount"`
+	Currency          string            `json:"currency"`
+	Reference         string            `json:"reference"`
+	Card              *paystackCard     `json:"card,omitempty"`
+	AuthorizationCode string            `json:"authorization_code,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+}
+
+type paystackTransaction struct {
+	ID              int64  `json:"id"`
+	Reference       string `json:"reference"`
+	Status          string `json:"status"`
+	Amount          int64  `json:"amount"`
+	Currency        string `json:"currency"`
+	GatewayResponse string `json:"gateway_response"`
+	Fees            int64  `json:"fees"` // In the currency subunit
+	Authorization   struct {
+		AuthorizationCode string `json:"authorization_code"`
+		Reusable          bool   `json:"reusable"`
+	} `json:"authorization"`
+}
+
+type paystackRefund struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+// Capabilities: card charges, captured on authorization
+func (p *PaystackProcessor) Capabilities() engine.Capabilities {
+	return engine.Capabilities{
+		Currencies:     []string{"NGN", "GHS", "ZAR", "KES", "USD"},
+		PaymentMethods: []string{"card"},
+		PartialRefunds: true,
+		ThreeDSecure:   true,
+	}
+}
+
+// Authorize charges a card. A card Paystack has already issued a reusable
+// authorization for, to the same customer email, is charged through that
+// authorization instead. Authorization codes only ever live in the vault.
+func (p *PaystackProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
+	email := payment.Metadata["email"]
+	if email == "" {
+		return errors.New("paystack requires a customer email in metadata")
+	}
+	if payment.PaymentMethod.Type != "card" {
+		return fmt.Errorf("paystack adapter does not support %q payments", payment.PaymentMethod.Type)
+	}
+
+	reference := fmt.Sprintf("pst-%s-%d", payment.ID, time.Now().Unix())
+	reqBody := paystackChargeRequest{
+		Email:     email,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Reference: reference,
+		Metadata:  payment.Metadata,
+	}
+
+	path := "/charge"
+	token, _ := payment.PaymentMethod.Details["token"].(string)
+	code, err := p.storedAuthorization(ctx, token, email)
+	if err != nil {
+		return err
+	}
+	if code != "" {
+		path = "/transaction/charge_authorization"
+		reqBody.AuthorizationCode = code
+	} else {
+		card, err := cardFromPayment(ctx, p.cards, payment)
+		if err != nil {
+			return err
+		}
+		reqBody.Card = &paystackCard{
+			Number:      card.Number,
+			Cvv:         card.CVV,
+			ExpiryMonth: card.ExpMonth,
+			ExpiryYear:  card.ExpYear,
+		}
+	}
+
+	// Save initial payment state with Paystack reference
+	payment.ExternalID = reference
+	if err := p.repo.Save(ctx, payment); err != nil {
+		return fmt.Errorf("failed to save payment: %w", err)
+	}
+
+	var tx paystackTransaction
+	if err := p.makeRequest(ctx, "charge", http.MethodPost, path, reqBody, &tx); err != nil {
+		return fmt.Errorf("paystack API error: %w", err)
+	}
+
+	// Keep reusable authorizations so the card can be charged again. The
+	// charge has gone through either way, so failing to store one only
+	// costs the next charge its shortcut.
+	if tx.Authorization.Reusable && tx.Authorization.AuthorizationCode != "" && token != "" {
+		if err := p.cards.SaveAuthorization(ctx, token, "paystack", email, tx.Authorization.AuthorizationCode); err != nil {
+			p.logger.Warn("Failed to store reusable authorization",
+				zap.String("payment_id", payment.ID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return p.applyCharge(payment, &tx)
+}
+
+// storedAuthorization returns the reusable authorization kept for the card
+// and customer, or "" when there is none
+func (p *PaystackProcessor) storedAuthorization(ctx context.Context, token, email string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	code, err := p.cards.Authorization(ctx, token, "paystack", email)
+	if errors.Is(err, vault.ErrAuthorizationNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up authorization: %w", err)
+	}
+	return code, nil
+}
+
+// applyCharge records the outcome of a charge on the payment
+func (p *PaystackProcessor) applyCharge(payment *model.Payment, tx *paystackTransaction) error {
+	payment.ProcessorPaymentID = strconv.FormatInt(tx.ID, 10)
+	if tx.Fees > 0 {
+		payment.ProcessorFee = tx.Fees
+	}
+
+	switch tx.Status {
+	case "success":
+		return payment.Complete()
+	case "pending", "ongoing", "send_pin", "send_otp", "send_phone", "send_birthday", "open_url":
+		// Stays pending until the transaction is verified
+		return nil
+	default:
+		// The engine records the failure
+		return model.NewDeclineError("paystack", tx.GatewayResponse, "charge "+tx.Status)
+	}
+}
+
+func (p *PaystackProcessor) Capture(ctx context.Context, paymentID string, amount int64) error {
+	// Paystack captures on charge, this verifies the transaction went through
+	payment, err := p.repo.Get(ctx, paymentID)
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	var tx paystackTransaction
+	if err := p.makeRequest(ctx, "verify", http.MethodGet, "/transaction/verify/"+payment.ExternalID, nil, &tx); err != nil {
+		return err
+	}
+
+	if tx.Status != "success" {
+		return fmt.Errorf("cannot capture - transaction status: %s", tx.Status)
+	}
+
+	return nil
+}
+
+// Void is not offered by Paystack; charges have to be refunded instead
+func (p *PaystackProcessor) Void(ctx context.Context, paymentID string) error {
+	return errors.New("paystack does not support voiding payments")
+}
+
+func (p *PaystackProcessor) Refund(ctx context.Context, refund *model.Refund) error {
+	payment, err := p.repo.Get(ctx, refund.PaymentID)
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	req := struct {
+		Transaction string `json:"transaction"`
+		Amount      int64  `json:"amount"`
+	}{
+		Transaction: payment.ProcessorPaymentID,
+		Amount:      refund.Amount,
+	}
+
+	var result paystackRefund
+	if err := p.makeRequest(ctx, "refund", http.MethodPost, "/refund", req, &result); err != nil {
+		return err
+	}
+
+	refund.ProcessorRefundID = strconv.FormatInt(result.ID, 10)
+	if result.Status == "processed" {
+		refund.Status = model.RefundSucceeded
+	}
+	return nil
+}
+
+// makeRequest calls the Paystack API through the retrier. Only GETs are
+// retried freely; a repeated POST could charge or refund twice.
+func (p *PaystackProcessor) makeRequest(ctx context.Context, operation, method, path string, body, out interface{}) error {
+	url := p.baseURL + path
+
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	call := retry.Call{Processor: "paystack", Operation: operation, Idempotent: method == http.MethodGet}
+	return p.retrier.Do(ctx, call, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+		// The request body is not logged: it can carry card data
+		p.logger.Debug("Making request to Paystack",
+			zap.String("method", method),
+			zap.String("url", url),
+		)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("HTTP request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		retryAfter := retry.ParseRetryAfter(resp.Header.Get("Retry-After"))
+		var result paystackResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			if resp.StatusCode >= 400 {
+				return &PaystackError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode), RetryAfter: retryAfter}
+			}
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		if resp.StatusCode >= 400 || !result.Status {
+			paystackErr := &PaystackError{StatusCode: resp.StatusCode, Message: result.Message, RetryAfter: retryAfter}
+			// Declines carry the issuer's reason in data.gateway_response
+			var tx paystackTransaction
+			if json.Unmarshal(result.Data, &tx) == nil {
+				paystackErr.GatewayResponse = tx.GatewayResponse
+			}
+			return paystackErr
+		}
+
+		if out != nil && len(result.Data) > 0 {
+			if err := json.Unmarshal(result.Data, out); err != nil {
+				return fmt.Errorf("failed to decode response data: %w", err)
+			}
+		}
+		return nil
+	})
+}
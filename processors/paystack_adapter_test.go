@@ -0,0 +1,140 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+func newTestPaystack(baseURL string, repo repository.PaymentRepository) *PaystackProcessor {
+	p := NewPaystackProcessor("test-key", baseURL, repo, &onceVault{cvvs: map[string]string{"tok_1": "123"}}, zap.NewNop())
+	p.retrier = testRetrier
+	return p
+}
+
+func TestPaystackRetriesVerify(t *testing.T) {
+	srv, hits := scriptedServer(t, "/transaction/verify/pst-pay_1",
+		respond(http.StatusBadGateway, `{"status":false,"message":"bad gateway"}`),
+		respond(http.StatusTooManyRequests, `{"status":false,"message":"rate limited"}`),
+		respond(http.StatusOK, `{"status":true,"message":"Verification successful","data":{"id":42,"status":"success"}}`),
+	)
+	payment := cardPayment("pay_1")
+	payment.ExternalID = "pst-pay_1"
+	p := newTestPaystack(srv.URL, newStubPayments(payment))
+
+	if err := p.Capture(context.Background(), "pay_1", 1000); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("verify called %d times, want 3", got)
+	}
+}
+
+func TestPaystackDoesNotRetryCharge(t *testing.T) {
+	srv, hits := scriptedServer(t, "/charge",
+		respond(http.StatusServiceUnavailable, `{"status":false,"message":"service unavailable"}`),
+	)
+	p := newTestPaystack(srv.URL, newStubPayments())
+
+	err := p.Authorize(context.Background(), cardPayment("pay_1"))
+	var paystackErr *PaystackError
+	if !errors.As(err, &paystackErr) || paystackErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Authorize() error = %v, want a 503 PaystackError", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("charge called %d times, want 1", got)
+	}
+}
+
+func TestPaystackDecline(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantSoft bool
+	}{
+		{"hard", "Declined", false},
+		{"soft", "Issuer or Switch Inoperative", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := scriptedServer(t, "/charge",
+				respond(http.StatusOK, `{"status":true,"message":"Charge attempted","data":{"id":7,"reference":"pst-pay_1","status":"failed","gateway_response":"`+tt.response+`"}}`),
+			)
+			p := newTestPaystack(srv.URL, newStubPayments())
+
+			err := p.Authorize(context.Background(), cardPayment("pay_1"))
+			var decline *model.DeclineError
+			if !errors.As(err, &decline) {
+				t.Fatalf("Authorize() error = %v, want a DeclineError", err)
+			}
+			if decline.Processor != "paystack" || decline.Code != tt.response || decline.Soft != tt.wantSoft {
+				t.Errorf("decline = %+v, want code %q soft %v", decline, tt.response, tt.wantSoft)
+			}
+			if got := hits.Load(); got != 1 {
+				t.Errorf("charge called %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestPaystackKeepsReusableAuthorizationInVault(t *testing.T) {
+	type charge struct {
+		path string
+		body paystackChargeRequest
+	}
+	var charges []charge
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body paystackChargeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		charges = append(charges, charge{path: r.URL.Path, body: body})
+		respond(http.StatusOK, `{"status":true,"message":"Charge attempted","data":{"id":7,"status":"success","authorization":{"authorization_code":"AUTH_1","reusable":true}}}`)(w)
+	}))
+	t.Cleanup(srv.Close)
+
+	cards := &onceVault{cvvs: map[string]string{"tok_1": "123"}}
+	p := NewPaystackProcessor("test-key", srv.URL, newStubPayments(), cards, zap.NewNop())
+	p.retrier = testRetrier
+
+	// A code sent by the client is never used
+	first := cardPayment("pay_1")
+	first.PaymentMethod.Details["authorization_code"] = "AUTH_forged"
+	if err := p.Authorize(context.Background(), first); err != nil {
+		t.Fatalf("Authorize(first) error = %v", err)
+	}
+	if charges[0].path != "/charge" || charges[0].body.AuthorizationCode != "" || charges[0].body.Card == nil {
+		t.Errorf("first charge = %+v, want a card charge", charges[0])
+	}
+	if first.PaymentMethod.Details["authorization_code"] != "AUTH_forged" {
+		t.Error("authorization code written to the payment")
+	}
+	if code, _ := cards.Authorization(context.Background(), "tok_1", "paystack", "customer@example.com"); code != "AUTH_1" {
+		t.Errorf("vaulted authorization = %q, want AUTH_1", code)
+	}
+
+	// The same card and customer are charged through the authorization
+	if err := p.Authorize(context.Background(), cardPayment("pay_2")); err != nil {
+		t.Fatalf("Authorize(second) error = %v", err)
+	}
+	if charges[1].path != "/transaction/charge_authorization" || charges[1].body.AuthorizationCode != "AUTH_1" || charges[1].body.Card != nil {
+		t.Errorf("second charge = %+v, want a charge of AUTH_1", charges[1])
+	}
+
+	// Another customer paying with the card does not get the authorization
+	other := cardPayment("pay_3")
+	other.Metadata["email"] = "other@example.com"
+	if err := p.Authorize(context.Background(), other); err != nil {
+		t.Fatalf("Authorize(other customer) error = %v", err)
+	}
+	if charges[2].path != "/charge" || charges[2].body.AuthorizationCode != "" {
+		t.Errorf("other customer's charge = %+v, want a card charge", charges[2])
+	}
+}
@@ -10,33 +10,55 @@ import (
 	"github.com/stripe/stripe-go/v72/paymentintent"
 	"github.com/stripe/stripe-go/v72/paymentmethod"
 	"github.com/stripe/stripe-go/v72/refund"
+	"github.com/thoraf20/payment-processor/engine"
 	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"github.com/thoraf20/payment-processor/retry"
 )
 
 type StripeProcessor struct {
 	apiKey string
+	repo   repository.PaymentRepository
+	cards  CardVault
 }
 
-func NewStripeProcessor(apiKey string) *StripeProcessor {
+func NewStripeProcessor(apiKey string, repo repository.PaymentRepository, cards CardVault) *StripeProcessor {
 	stripe.Key = apiKey
-	return &StripeProcessor{apiKey: apiKey}
+	return &StripeProcessor{apiKey: apiKey, repo: repo, cards: cards}
+}
+
+// Capabilities: card payments in any currency Stripe settles
+func (s *StripeProcessor) Capabilities() engine.Capabilities {
+	return engine.Capabilities{
+		PaymentMethods:  []string{"card"},
+		SeparateCapture: true,
+		PartialRefunds:  true,
+		ThreeDSecure:    true,
+	}
 }
 
 func (s *StripeProcessor) Authorize(ctx context.Context, payment *model.Payment) error {
+	card, err := cardFromPayment(ctx, s.cards, payment)
+	if err != nil {
+		return err
+	}
+
 	// First create a PaymentMethod
 	pmParams := &stripe.PaymentMethodParams{
 		Type: stripe.String("card"),
 		Card: &stripe.PaymentMethodCardParams{
-			Number:   stripe.String(payment.PaymentMethod.Details["number"].(string)),
-			ExpMonth: stripe.String(fmt.Sprintf("%v", payment.PaymentMethod.Details["exp_month"])),
-			ExpYear:  stripe.String(fmt.Sprintf("%v", payment.PaymentMethod.Details["exp_year"])),
-			CVC:      stripe.String(payment.PaymentMethod.Details["cvc"].(string)),
+			Number:   stripe.String(card.Number),
+			ExpMonth: stripe.String(card.ExpMonth),
+			ExpYear:  stripe.String(card.ExpYear),
 		},
 	}
+	if card.CVV != "" {
+		pmParams.Card.CVC = stripe.String(card.CVV)
+	}
 
 	pm, err := paymentmethod.New(pmParams)
 	if err != nil {
-		return fmt.Errorf("failed to create payment method: %w", err)
+		return fmt.Errorf("failed to create payment method: %w", stripeError(err))
 	}
 
 	// Then create and confirm the PaymentIntent
@@ -46,28 +68,54 @@ func (s *StripeProcessor) Authorize(ctx context.Context, payment *model.Payment)
 		PaymentMethod: stripe.String(pm.ID),
 		Confirm:       stripe.Bool(true),
 	}
+	// Settlement reports carry the metadata, which is how reconciliation
+	// finds the payment
+	params.AddMetadata("payment_id", payment.ID)
+	payment.ExternalID = payment.ID
 
-	_, err = paymentintent.New(params)
+	pi, err := paymentintent.New(params)
 	if err != nil {
-		return fmt.Errorf("failed to create payment intent: %w", err)
+		return fmt.Errorf("failed to create payment intent: %w", stripeError(err))
 	}
 
-	return nil
+	payment.ProcessorPaymentID = pi.ID
+
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return payment.Complete()
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return payment.TransitionTo(model.StatusAuthorized)
+	case stripe.PaymentIntentStatusProcessing, stripe.PaymentIntentStatusRequiresAction:
+		// Stays pending until Stripe reports the outcome
+		return nil
+	default:
+		return fmt.Errorf("payment intent %s ended in status %s", pi.ID, pi.Status)
+	}
 }
 
 func (s *StripeProcessor) Capture(ctx context.Context, paymentID string, amount int64) error {
+	intentID, err := s.intentID(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+
 	params := &stripe.PaymentIntentCaptureParams{
 		AmountToCapture: stripe.Int64(amount),
 	}
-	_, err := paymentintent.Capture(paymentID, params)
-	return err
+	_, err = paymentintent.Capture(intentID, params)
+	return stripeError(err)
 }
 
-func (s *StripeProcessor) Refund(ctx context.Context, paymentID string, amount int64) error {
+func (s *StripeProcessor) Refund(ctx context.Context, rf *model.Refund) error {
+	intentID, err := s.intentID(ctx, rf.PaymentID)
+	if err != nil {
+		return err
+	}
+
 	// First get the PaymentIntent to check its status
-	pi, err := paymentintent.Get(paymentID, nil)
+	pi, err := paymentintent.Get(intentID, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get payment intent: %w", err)
+		return fmt.Errorf("failed to get payment intent: %w", stripeError(err))
 	}
 
 	// Only allow refunds on succeeded payments
@@ -76,9 +124,64 @@ func (s *StripeProcessor) Refund(ctx context.Context, paymentID string, amount i
 	}
 
 	params := &stripe.RefundParams{
-		PaymentIntent: stripe.String(paymentID),
-		Amount:        stripe.Int64(amount),
+		PaymentIntent: stripe.String(intentID),
+		Amount:        stripe.Int64(rf.Amount),
+	}
+	params.AddMetadata("refund_id", rf.ID)
+
+	r, err := refund.New(params)
+	if err != nil {
+		return stripeError(err)
+	}
+
+	rf.ProcessorRefundID = r.ID
+	switch r.Status {
+	case stripe.RefundStatusSucceeded:
+		rf.Status = model.RefundSucceeded
+	case stripe.RefundStatusFailed, stripe.RefundStatusCanceled:
+		return fmt.Errorf("refund %s ended in status %s", r.ID, r.Status)
+	}
+	return nil
+}
+
+func (s *StripeProcessor) Void(ctx context.Context, paymentID string) error {
+	intentID, err := s.intentID(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = paymentintent.Cancel(intentID, nil)
+	return stripeError(err)
+}
+
+// stripeError maps Stripe card errors to declines and server-side errors
+// to retryable status errors, so the router can tell them apart
+func stripeError(err error) error {
+	var se *stripe.Error
+	if !errors.As(err, &se) {
+		return err
+	}
+	if se.Type == stripe.ErrorTypeCard {
+		code := string(se.DeclineCode)
+		if code == "" {
+			code = string(se.Code)
+		}
+		return model.NewDeclineError("stripe", code, se.Msg)
+	}
+	if retry.RetryableStatus(se.HTTPStatusCode) {
+		return fmt.Errorf("stripe error: %w", &retry.StatusError{StatusCode: se.HTTPStatusCode, Message: se.Msg})
 	}
-	_, err = refund.New(params)
 	return err
-}
\ No newline at end of file
+}
+
+// intentID resolves our payment ID to the PaymentIntent recorded at authorization
+func (s *StripeProcessor) intentID(ctx context.Context, paymentID string) (string, error) {
+	payment, err := s.repo.Get(ctx, paymentID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get payment: %w", err)
+	}
+	if payment.ProcessorPaymentID == "" {
+		return "", fmt.Errorf("no stripe payment intent recorded for payment %s", paymentID)
+	}
+	return payment.ProcessorPaymentID, nil
+}
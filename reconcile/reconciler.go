@@ -0,0 +1,307 @@
+// Package reconcile checks processor settlement reports against the
+// payments table and records what does not agree.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+var ErrUnsupportedProcessor = errors.New("no settlement format for processor")
+
+// Parsers maps processor IDs to their settlement file format
+var Parsers = map[string]Parser{
+	"stripe":      ParseStripe,
+	"flutterwave": ParseFlutterwave,
+}
+
+// Period bounds the payments expected in a report. A zero bound is taken
+// from the earliest or latest row.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+type Reconciler struct {
+	payments repository.PaymentRepository
+	reports  repository.ReconciliationRepository
+	logger   *zap.Logger
+}
+
+func NewReconciler(payments repository.PaymentRepository, reports repository.ReconciliationRepository, logger *zap.Logger) *Reconciler {
+	return &Reconciler{
+		payments: payments,
+		reports:  reports,
+		logger:   logger,
+	}
+}
+
+// Import parses a processor's settlement file, reconciles it and stores
+// the report. source names the file for the report.
+func (r *Reconciler) Import(ctx context.Context, processorID, source string, file io.Reader, period Period) (*model.ReconciliationReport, error) {
+	parse, ok := Parsers[processorID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedProcessor, processorID)
+	}
+	rows, err := parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	report, fees, err := r.reconcile(ctx, processorID, rows, period)
+	if err != nil {
+		return nil, err
+	}
+	report.Source = source
+
+	for paymentID, fee := range fees {
+		if err := r.payments.RecordProcessorFee(ctx, paymentID, fee); err != nil {
+			return nil, fmt.Errorf("failed to record fee for payment %s: %w", paymentID, err)
+		}
+	}
+
+	if err := r.reports.Save(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to save reconciliation report: %w", err)
+	}
+	r.logger.Info("Reconciled settlement report",
+		zap.String("report_id", report.ID),
+		zap.String("processor", processorID),
+		zap.Int("rows", report.Rows),
+		zap.Int("matched", report.Matched),
+		zap.Int("missing", report.Missing),
+		zap.Int("extra", report.Extra),
+		zap.Int("amount_mismatched", report.AmountMismatched),
+		zap.Int("status_mismatched", report.StatusMismatched),
+	)
+	return report, nil
+}
+
+// Reconcile matches rows to payments and builds an unsaved report. It
+// changes nothing.
+func (r *Reconciler) Reconcile(ctx context.Context, processorID string, rows []Row, period Period) (*model.ReconciliationReport, error) {
+	report, _, err := r.reconcile(ctx, processorID, rows, period)
+	return report, err
+}
+
+// reconcile builds the report along with the fees settled charges report
+// for payments that have none recorded, by payment ID
+func (r *Reconciler) reconcile(ctx context.Context, processorID string, rows []Row, period Period) (*model.ReconciliationReport, map[string]int64, error) {
+	report := &model.ReconciliationReport{
+		ID:            uuid.New().String(),
+		ProcessorID:   processorID,
+		Rows:          len(rows),
+		CreatedAt:     time.Now().UTC(),
+		Discrepancies: []*model.ReconciliationDiscrepancy{},
+	}
+	flag := func(d *model.ReconciliationDiscrepancy) {
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	seen := make(map[string]*model.Payment)
+	refunded := make(map[string]int64)
+	fees := make(map[string]int64)
+	for _, row := range rows {
+		if report.PeriodStart.IsZero() || row.CreatedAt.Before(report.PeriodStart) {
+			report.PeriodStart = row.CreatedAt
+		}
+		if row.CreatedAt.After(report.PeriodEnd) {
+			report.PeriodEnd = row.CreatedAt
+		}
+
+		payment, err := r.lookup(ctx, processorID, row)
+		if err != nil {
+			return nil, nil, err
+		}
+		if payment == nil {
+			report.Extra++
+			flag(&model.ReconciliationDiscrepancy{
+				Kind:         model.DiscrepancyExtra,
+				Reference:    firstNonEmpty(row.Reference, row.ProcessorReference, row.ID),
+				Currency:     row.Currency,
+				ActualAmount: row.Amount,
+				ActualStatus: string(row.Type),
+				Detail:       "no payment matches this " + string(row.Type),
+			})
+			continue
+		}
+		seen[payment.ID] = payment
+
+		if d := checkRow(payment, row); d != nil {
+			if d.Kind == model.DiscrepancyAmountMismatch {
+				report.AmountMismatched++
+			} else {
+				report.StatusMismatched++
+			}
+			flag(d)
+			continue
+		}
+		if row.Type == RowRefund {
+			refunded[payment.ID] += row.Amount
+		}
+		if fee, ok := settledFee(payment, row); ok {
+			fees[payment.ID] = fee
+		}
+		report.Matched++
+	}
+
+	// Refunds may settle after the period, so only over-refunding is a
+	// discrepancy
+	for paymentID, amount := range refunded {
+		payment := seen[paymentID]
+		if amount > payment.RefundedAmount {
+			report.AmountMismatched++
+			flag(&model.ReconciliationDiscrepancy{
+				Kind:           model.DiscrepancyAmountMismatch,
+				PaymentID:      payment.ID,
+				Reference:      payment.ExternalID,
+				Currency:       payment.Currency,
+				ExpectedAmount: payment.RefundedAmount,
+				ActualAmount:   amount,
+				Detail:         "processor refunded more than we recorded",
+			})
+		}
+	}
+
+	if !period.Start.IsZero() {
+		report.PeriodStart = period.Start
+	}
+	if !period.End.IsZero() {
+		report.PeriodEnd = period.End
+	}
+	if report.PeriodStart.IsZero() || report.PeriodEnd.IsZero() {
+		return report, fees, nil
+	}
+
+	// Anything we settled in the period should appear in the report
+	payments, err := r.payments.List(ctx, repository.PaymentFilter{
+		ProcessorID:   processorID,
+		CreatedAfter:  report.PeriodStart,
+		CreatedBefore: report.PeriodEnd.Add(time.Second),
+		SortOrder:     repository.SortAsc,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list payments for period: %w", err)
+	}
+	for _, payment := range payments {
+		if _, ok := seen[payment.ID]; ok || !settled(payment.Status) {
+			continue
+		}
+		report.Missing++
+		flag(&model.ReconciliationDiscrepancy{
+			Kind:           model.DiscrepancyMissing,
+			PaymentID:      payment.ID,
+			Reference:      firstNonEmpty(payment.ExternalID, payment.ProcessorPaymentID),
+			Currency:       payment.Currency,
+			ExpectedAmount: payment.CapturedAmount,
+			ExpectedStatus: string(payment.Status),
+			Detail:         "payment is not in the settlement report",
+		})
+	}
+
+	return report, fees, nil
+}
+
+// lookup finds the payment for a row by our reference, then by the
+// processor's
+func (r *Reconciler) lookup(ctx context.Context, processorID string, row Row) (*model.Payment, error) {
+	for _, reference := range []string{row.Reference, row.ProcessorReference} {
+		if reference == "" {
+			continue
+		}
+		payment, err := r.payments.GetByProcessorReference(ctx, processorID, reference)
+		if errors.Is(err, repository.ErrPaymentNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to match %s: %w", reference, err)
+		}
+		return payment, nil
+	}
+	return nil, nil
+}
+
+// settledFee returns the fee a settled charge reports for a payment whose
+// processor did not report one when charging
+func settledFee(payment *model.Payment, row Row) (int64, bool) {
+	if row.Type != RowCharge || !row.Settled() || row.Fee <= 0 || payment.ProcessorFee != 0 {
+		return 0, false
+	}
+	return row.Fee, true
+}
+
+// checkRow compares a matched row with the payment
+func checkRow(payment *model.Payment, row Row) *model.ReconciliationDiscrepancy {
+	d := &model.ReconciliationDiscrepancy{
+		PaymentID:      payment.ID,
+		Reference:      firstNonEmpty(row.Reference, row.ProcessorReference),
+		Currency:       row.Currency,
+		ExpectedStatus: string(payment.Status),
+		ActualStatus:   firstNonEmpty(row.Status, "settled"),
+		ActualAmount:   row.Amount,
+	}
+
+	switch row.Type {
+	case RowCharge:
+		d.ExpectedAmount = payment.CapturedAmount
+		if row.Settled() != settled(payment.Status) {
+			d.Kind = model.DiscrepancyStatusMismatch
+			d.Detail = "processor and payment disagree on whether the charge settled"
+			return d
+		}
+		if row.Settled() && (row.Amount != payment.CapturedAmount || !strings.EqualFold(row.Currency, payment.Currency)) {
+			d.Kind = model.DiscrepancyAmountMismatch
+			d.Detail = "settled amount differs from the captured amount"
+			return d
+		}
+	case RowRefund:
+		d.ExpectedAmount = payment.RefundedAmount
+		if payment.RefundedAmount == 0 {
+			d.Kind = model.DiscrepancyStatusMismatch
+			d.ActualStatus = "refunded"
+			d.Detail = "processor refunded a payment we have not refunded"
+			return d
+		}
+		if !strings.EqualFold(row.Currency, payment.Currency) {
+			d.Kind = model.DiscrepancyAmountMismatch
+			d.Detail = "refund currency differs from the payment currency"
+			return d
+		}
+	}
+	return nil
+}
+
+// settled reports whether a payment's funds should appear in settlement
+func settled(status model.PaymentStatus) bool {
+	switch status {
+	case model.StatusCaptured, model.StatusCompleted, model.StatusPartiallyRefunded,
+		model.StatusRefunded, model.StatusDisputed:
+		return true
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r *Reconciler) Report(ctx context.Context, id string) (*model.ReconciliationReport, error) {
+	return r.reports.Get(ctx, id)
+}
+
+func (r *Reconciler) Reports(ctx context.Context, processorID string, limit int) ([]*model.ReconciliationReport, error) {
+	return r.reports.List(ctx, processorID, limit)
+}
@@ -0,0 +1,136 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+// stubPayments serves fixed payments and records every write
+type stubPayments struct {
+	payments map[string]*model.Payment
+	saved    int
+	fees     map[string]int64
+}
+
+func (s *stubPayments) Save(ctx context.Context, payment *model.Payment) error {
+	s.saved++
+	return nil
+}
+
+func (s *stubPayments) Get(ctx context.Context, id string) (*model.Payment, error) {
+	if p, ok := s.payments[id]; ok {
+		return p, nil
+	}
+	return nil, repository.ErrPaymentNotFound
+}
+
+func (s *stubPayments) GetByProcessorReference(ctx context.Context, processorID, reference string) (*model.Payment, error) {
+	for _, p := range s.payments {
+		if p.ProcessorID == processorID && (p.ExternalID == reference || p.ProcessorPaymentID == reference) {
+			return p, nil
+		}
+	}
+	return nil, repository.ErrPaymentNotFound
+}
+
+func (s *stubPayments) List(ctx context.Context, filter repository.PaymentFilter) ([]*model.Payment, error) {
+	return nil, nil
+}
+
+func (s *stubPayments) ReserveRefund(ctx context.Context, paymentID string, amount int64) (*model.Payment, error) {
+	s.saved++
+	return s.payments[paymentID], nil
+}
+
+func (s *stubPayments) ReleaseRefund(ctx context.Context, paymentID string, amount int64) error {
+	s.saved++
+	return nil
+}
+
+func (s *stubPayments) RecordProcessorFee(ctx context.Context, paymentID string, fee int64) error {
+	s.fees[paymentID] = fee
+	return nil
+}
+
+type stubReports struct {
+	saved []*model.ReconciliationReport
+}
+
+func (s *stubReports) Save(ctx context.Context, report *model.ReconciliationReport) error {
+	s.saved = append(s.saved, report)
+	return nil
+}
+
+func (s *stubReports) Get(ctx context.Context, id string) (*model.ReconciliationReport, error) {
+	return nil, repository.ErrReconciliationReportNotFound
+}
+
+func (s *stubReports) List(ctx context.Context, processorID string, limit int) ([]*model.ReconciliationReport, error) {
+	return nil, nil
+}
+
+func newStubPayments() *stubPayments {
+	return &stubPayments{
+		fees: make(map[string]int64),
+		payments: map[string]*model.Payment{
+			"pay_1": {ID: "pay_1", ExternalID: "pay_1", ProcessorID: "stripe", ProcessorPaymentID: "pi_1",
+				Currency: "USD", Status: model.StatusCompleted, CapturedAmount: 10000},
+			// Fee already reported when charging
+			"pay_2": {ID: "pay_2", ExternalID: "pay_2", ProcessorID: "stripe", ProcessorPaymentID: "pi_2",
+				Currency: "USD", Status: model.StatusCompleted, CapturedAmount: 125050, ProcessorFee: 3600},
+		},
+	}
+}
+
+const stripeFees = `balance_transaction_id,created_utc,currency,gross,fee,reporting_category,payment_intent_id,payment_metadata[payment_id]
+txn_1,2024-05-01 10:00:00,usd,100.00,3.20,charge,pi_1,pay_1
+txn_2,2024-05-01 11:30:00,usd,1250.50,36.56,charge,pi_2,pay_2
+`
+
+func TestReconcileHasNoSideEffects(t *testing.T) {
+	payments := newStubPayments()
+	reconciler := NewReconciler(payments, &stubReports{}, zap.NewNop())
+
+	rows, err := ParseStripe(strings.NewReader(stripeFees))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := reconciler.Reconcile(context.Background(), "stripe", rows, Period{})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	if report.Matched != 2 {
+		t.Errorf("Matched = %d, want 2", report.Matched)
+	}
+	if payments.saved != 0 || len(payments.fees) != 0 {
+		t.Errorf("Reconcile wrote %d payments and %d fees, want none", payments.saved, len(payments.fees))
+	}
+}
+
+func TestImportRecordsMissingFees(t *testing.T) {
+	payments := newStubPayments()
+	reports := &stubReports{}
+	reconciler := NewReconciler(payments, reports, zap.NewNop())
+
+	report, err := reconciler.Import(context.Background(), "stripe", "test.csv", strings.NewReader(stripeFees), Period{})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if len(reports.saved) != 1 || report.Source != "test.csv" {
+		t.Errorf("saved %d reports with source %q, want 1 with test.csv", len(reports.saved), report.Source)
+	}
+	if payments.saved != 0 {
+		t.Errorf("Import saved %d whole payments, want none", payments.saved)
+	}
+	want := map[string]int64{"pay_1": 320}
+	if len(payments.fees) != 1 || payments.fees["pay_1"] != want["pay_1"] {
+		t.Errorf("recorded fees %v, want %v", payments.fees, want)
+	}
+}
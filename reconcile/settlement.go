@@ -0,0 +1,242 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+var ErrMissingColumn = errors.New("settlement file is missing a required column")
+
+type RowType string
+
+const (
+	RowCharge RowType = "charge"
+	RowRefund RowType = "refund"
+)
+
+// Row is one settled movement from a processor's report, normalised to
+// minor units
+type Row struct {
+	ID   string
+	Type RowType
+	// Reference is our ExternalID as echoed back by the processor
+	Reference string
+	// ProcessorReference is the processor's own payment ID
+	ProcessorReference string
+	Amount             int64
+	Fee                int64
+	Currency           string
+	// Status is empty when the report only lists settled rows
+	Status    string
+	CreatedAt time.Time
+}
+
+// Settled reports whether the processor considers the row paid out
+func (r Row) Settled() bool {
+	switch strings.ToLower(r.Status) {
+	case "", "successful", "success", "succeeded", "completed", "paid":
+		return true
+	}
+	return false
+}
+
+// Parser reads a processor's settlement file
+type Parser func(r io.Reader) ([]Row, error)
+
+// ParseStripe reads a Stripe balance transaction export, either the
+// itemized balance change report or the dashboard export. Every charge in
+// it has succeeded; payouts, fees and other non-payment rows are skipped.
+// Rows are matched by the payment_id metadata the Stripe adapter sets,
+// falling back to the PaymentIntent ID.
+func ParseStripe(r io.Reader) ([]Row, error) {
+	return parseCSV(r, func(rec record) (*Row, error) {
+		var rowType RowType
+		switch strings.ToLower(rec.get("reporting_category", "type")) {
+		case "charge", "payment":
+			rowType = RowCharge
+		case "refund", "payment_refund":
+			rowType = RowRefund
+		default:
+			return nil, nil
+		}
+
+		currency := strings.ToUpper(rec.get("currency"))
+		amount, err := parseMinorUnits(rec.get("gross", "amount"), currency)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := parseMinorUnits(rec.get("fee"), currency)
+		if err != nil {
+			return nil, err
+		}
+		created, err := parseTimestamp(rec.get("created_utc", "created (utc)", "created"))
+		if err != nil {
+			return nil, err
+		}
+
+		return &Row{
+			ID:                 rec.get("balance_transaction_id", "id"),
+			Type:               rowType,
+			Reference:          rec.get("payment_metadata[payment_id]", "metadata[payment_id]", "payment_id (metadata)"),
+			ProcessorReference: rec.get("payment_intent_id", "source_id", "source"),
+			Amount:             abs(amount),
+			Fee:                abs(fee),
+			Currency:           currency,
+			CreatedAt:          created,
+		}, nil
+	}, "currency")
+}
+
+// ParseFlutterwave reads a Flutterwave settlement export. Rows are matched
+// by tx_ref, which is our ExternalID, falling back to the transaction ID.
+func ParseFlutterwave(r io.Reader) ([]Row, error) {
+	return parseCSV(r, func(rec record) (*Row, error) {
+		rowType := RowCharge
+		if strings.Contains(strings.ToLower(rec.get("type", "transaction type")), "refund") {
+			rowType = RowRefund
+		}
+
+		currency := strings.ToUpper(rec.get("currency"))
+		amount, err := parseMinorUnits(rec.get("amount", "charged amount"), currency)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := parseMinorUnits(rec.get("app_fee", "fee", "transaction fee"), currency)
+		if err != nil {
+			return nil, err
+		}
+		created, err := parseTimestamp(rec.get("created_at", "transaction date", "date"))
+		if err != nil {
+			return nil, err
+		}
+
+		return &Row{
+			ID:                 rec.get("flw_ref", "flutterwave reference"),
+			Type:               rowType,
+			Reference:          rec.get("tx_ref", "transaction reference", "merchant reference"),
+			ProcessorReference: rec.get("transaction id", "tx_id", "id"),
+			Amount:             abs(amount),
+			Fee:                abs(fee),
+			Currency:           currency,
+			Status:             rec.get("status"),
+			CreatedAt:          created,
+		}, nil
+	}, "currency")
+}
+
+// record is a CSV row addressed by case-insensitive header name
+type record struct {
+	columns map[string]int
+	values  []string
+}
+
+// get returns the value of the first named column present in the file
+func (r record) get(names ...string) string {
+	for _, name := range names {
+		if i, ok := r.columns[name]; ok && i < len(r.values) {
+			return strings.TrimSpace(r.values[i])
+		}
+	}
+	return ""
+}
+
+func parseCSV(r io.Reader, parse func(record) (*Row, error), required ...string) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingColumn, name)
+		}
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read settlement file: %w", err)
+		}
+
+		row, err := parse(record{columns: columns, values: values})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if row != nil {
+			rows = append(rows, *row)
+		}
+	}
+	return rows, nil
+}
+
+// parseMinorUnits converts a decimal amount such as "1,234.50" to minor
+// units without going through floating point
+func parseMinorUnits(s, currency string) (int64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, nil
+	}
+
+	decimals := model.MinorUnitDigits(currency)
+
+	whole, frac, _ := strings.Cut(s, ".")
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > decimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places", s, decimals)
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+
+	negative := strings.HasPrefix(whole, "-")
+	n, err := strconv.ParseInt(strings.TrimPrefix(whole, "-")+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package reconcile
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const stripeItemized = `balance_transaction_id,created_utc,currency,gross,fee,net,reporting_category,payment_intent_id,payment_metadata[payment_id]
+txn_1,2024-05-01 10:00:00,usd,100.00,3.20,96.80,charge,pi_1,pay_1
+txn_2,2024-05-01 11:30:00,usd,"1,250.50",36.56,"1,213.94",charge,pi_2,pay_2
+txn_3,2024-05-02 09:00:00,usd,-40.00,0.00,-40.00,refund,pi_1,pay_1
+txn_4,2024-05-03 00:00:00,usd,-1000.00,0.00,-1000.00,payout,,
+txn_5,2024-05-03 12:00:00,jpy,5000,190,4810,charge,pi_3,pay_3
+`
+
+// The dashboard export names its columns differently
+const stripeDashboard = `id,Type,Source,Amount,Fee,Currency,Created (UTC),payment_id (metadata)
+txn_1,payment,py_1,25.00,1.03,usd,2024-05-01 10:00,pay_1
+`
+
+const flutterwaveExport = `Transaction ID,tx_ref,flw_ref,Amount,App_fee,Currency,Status,Type,Created_at
+1001,flw-pay_1-1714557600,FLW-1,1500.00,21.00,NGN,successful,card,2024-05-01T10:00:00Z
+1002,flw-pay_2-1714557700,FLW-2,5000,190,UGX,successful,card,2024-05-01T10:01:40Z
+1003,flw-pay_3-1714557800,FLW-3,2500,0,XOF,failed,card,2024-05-01T10:03:20Z
+1004,flw-pay_1-1714557600,FLW-4,500.00,0,NGN,successful,refund,2024-05-02T08:00:00Z
+`
+
+func TestParseStripe(t *testing.T) {
+	rows, err := ParseStripe(strings.NewReader(stripeItemized))
+	if err != nil {
+		t.Fatalf("ParseStripe() error = %v", err)
+	}
+
+	want := []Row{
+		{ID: "txn_1", Type: RowCharge, Reference: "pay_1", ProcessorReference: "pi_1", Amount: 10000, Fee: 320, Currency: "USD",
+			CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: "txn_2", Type: RowCharge, Reference: "pay_2", ProcessorReference: "pi_2", Amount: 125050, Fee: 3656, Currency: "USD",
+			CreatedAt: time.Date(2024, 5, 1, 11, 30, 0, 0, time.UTC)},
+		{ID: "txn_3", Type: RowRefund, Reference: "pay_1", ProcessorReference: "pi_1", Amount: 4000, Currency: "USD",
+			CreatedAt: time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)},
+		{ID: "txn_5", Type: RowCharge, Reference: "pay_3", ProcessorReference: "pi_3", Amount: 5000, Fee: 190, Currency: "JPY",
+			CreatedAt: time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ParseStripe() =\n%+v\nwant\n%+v", rows, want)
+	}
+}
+
+func TestParseStripeDashboardExport(t *testing.T) {
+	rows, err := ParseStripe(strings.NewReader(stripeDashboard))
+	if err != nil {
+		t.Fatalf("ParseStripe() error = %v", err)
+	}
+
+	want := []Row{{ID: "txn_1", Type: RowCharge, Reference: "pay_1", ProcessorReference: "py_1", Amount: 2500, Fee: 103,
+		Currency: "USD", CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ParseStripe() =\n%+v\nwant\n%+v", rows, want)
+	}
+}
+
+func TestParseFlutterwave(t *testing.T) {
+	rows, err := ParseFlutterwave(strings.NewReader(flutterwaveExport))
+	if err != nil {
+		t.Fatalf("ParseFlutterwave() error = %v", err)
+	}
+
+	want := []Row{
+		{ID: "FLW-1", Type: RowCharge, Reference: "flw-pay_1-1714557600", ProcessorReference: "1001", Amount: 150000, Fee: 2100,
+			Currency: "NGN", Status: "successful", CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		// UGX and XOF have no minor unit
+		{ID: "FLW-2", Type: RowCharge, Reference: "flw-pay_2-1714557700", ProcessorReference: "1002", Amount: 5000, Fee: 190,
+			Currency: "UGX", Status: "successful", CreatedAt: time.Date(2024, 5, 1, 10, 1, 40, 0, time.UTC)},
+		{ID: "FLW-3", Type: RowCharge, Reference: "flw-pay_3-1714557800", ProcessorReference: "1003", Amount: 2500,
+			Currency: "XOF", Status: "failed", CreatedAt: time.Date(2024, 5, 1, 10, 3, 20, 0, time.UTC)},
+		{ID: "FLW-4", Type: RowRefund, Reference: "flw-pay_1-1714557600", ProcessorReference: "1004", Amount: 50000,
+			Currency: "NGN", Status: "successful", CreatedAt: time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ParseFlutterwave() =\n%+v\nwant\n%+v", rows, want)
+	}
+	if rows[2].Settled() {
+		t.Error("failed row reported as settled")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  Parser
+		file   string
+		target error
+	}{
+		{"missing currency column", ParseStripe, "id,amount\ntxn_1,1.00\n", ErrMissingColumn},
+		{"too many decimals", ParseFlutterwave, "currency,amount\nUGX,10.50\n", nil},
+		{"bad amount", ParseStripe, "currency,gross,reporting_category\nusd,abc,charge\n", nil},
+		{"bad timestamp", ParseFlutterwave, "currency,amount,created_at\nNGN,1.00,yesterday\n", nil},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.parse(strings.NewReader(tt.file))
+		if err == nil {
+			t.Errorf("%s: error = nil", tt.name)
+			continue
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.target)
+		}
+	}
+}
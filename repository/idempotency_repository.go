@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrIdempotencyLockLost is returned by Complete when the key's lease
+// expired and another request has taken it over
+var ErrIdempotencyLockLost = errors.New("idempotency key is held by another request")
+
+type IdempotencyStatus string
+
+const (
+	IdempotencyInProgress IdempotencyStatus = "in_progress"
+	IdempotencyCompleted  IdempotencyStatus = "completed"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key
+type IdempotencyRecord struct {
+	Key          string
+	Fingerprint  string
+	Status       IdempotencyStatus
+	ResponseCode int
+	// ResponseContentType is empty when the response set none
+	ResponseContentType string
+	ResponseBody        []byte
+	// LockToken identifies the request holding the key
+	LockToken string
+	LockedAt  time.Time
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type IdempotencyRepository interface {
+	// Claim atomically takes the key for the caller and returns the record
+	// holding its lock token. It returns claimed=false together with the
+	// existing record when another request already owns it. An in-progress
+	// key is only taken over once its lease has run out.
+	Claim(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (record *IdempotencyRecord, claimed bool, err error)
+	// Complete stores the response, provided lockToken still holds the key;
+	// otherwise it returns ErrIdempotencyLockLost
+	Complete(ctx context.Context, key, lockToken string, responseCode int, contentType string, responseBody []byte) error
+}
+
+type DbIdempotencyRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewIdempotencyRepository(db *sql.DB, logger *zap.Logger) *DbIdempotencyRepository {
+	return &DbIdempotencyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *DbIdempotencyRepository) Claim(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (*IdempotencyRecord, bool, error) {
+	// A key can be taken over once it has expired, or when the previous
+	// holder's lease on the same request has run out, i.e. it crashed.
+	query := `INSERT INTO idempotency_keys (key, fingerprint, status, lock_token, locked_at, created_at, expires_at)
+	          VALUES ($1, $2, $3, $6, now(), now(), now() + $4 * interval '1 second')
+	          ON CONFLICT (key) DO UPDATE SET
+	          fingerprint = EXCLUDED.fingerprint, status = EXCLUDED.status,
+	          response_code = NULL, response_content_type = NULL, response_body = NULL,
+	          lock_token = EXCLUDED.lock_token, locked_at = EXCLUDED.locked_at,
+	          created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+	          WHERE idempotency_keys.expires_at < now()
+	             OR (idempotency_keys.status = $3
+	                 AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+	                 AND idempotency_keys.locked_at < now() - $5 * interval '1 second')
+	          RETURNING locked_at, created_at, expires_at`
+
+	record := IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		Status:      IdempotencyInProgress,
+		LockToken:   uuid.New().String(),
+	}
+	err := r.db.QueryRowContext(ctx, query,
+		key,
+		fingerprint,
+		IdempotencyInProgress,
+		ttl.Seconds(),
+		lease.Seconds(),
+		record.LockToken,
+	).Scan(&record.LockedAt, &record.CreatedAt, &record.ExpiresAt)
+	if err == nil {
+		return &record, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	existing, err := r.get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (r *DbIdempotencyRepository) Complete(ctx context.Context, key, lockToken string, responseCode int, contentType string, responseBody []byte) error {
+	query := `UPDATE idempotency_keys SET status = $3, response_code = $4, response_content_type = $5, response_body = $6
+	          WHERE key = $1 AND lock_token = $2 AND status = $7`
+
+	res, err := r.db.ExecContext(ctx, query, key, lockToken, IdempotencyCompleted, responseCode, contentType, responseBody, IdempotencyInProgress)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrIdempotencyLockLost
+	}
+	return nil
+}
+
+func (r *DbIdempotencyRepository) get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	query := `SELECT key, fingerprint, status, response_code, response_content_type, response_body, lock_token, locked_at, created_at, expires_at
+	          FROM idempotency_keys WHERE key = $1`
+
+	var record IdempotencyRecord
+	var code sql.NullInt64
+	var contentType sql.NullString
+	err := r.db.QueryRowContext(ctx, query, key).Scan(
+		&record.Key,
+		&record.Fingerprint,
+		&record.Status,
+		&code,
+		&contentType,
+		&record.ResponseBody,
+		&record.LockToken,
+		&record.LockedAt,
+		&record.CreatedAt,
+		&record.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	record.ResponseCode = int(code.Int64)
+	record.ResponseContentType = contentType.String
+
+	return &record, nil
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thoraf20/payment-processor/events"
+	"go.uber.org/zap"
+)
+
+// writeOutboxEvent records a domain event inside the caller's transaction so
+// it is committed if and only if the state change is
+func writeOutboxEvent(ctx context.Context, tx *sql.Tx, eventType, aggregateType, aggregateID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox_events (event_id, event_type, aggregate_type, aggregate_id, payload, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6)`,
+		uuid.New().String(),
+		eventType,
+		aggregateType,
+		aggregateID,
+		data,
+		time.Now().UTC(),
+	)
+	return err
+}
+
+const (
+	// outboxRelayLockID lets one relay at a time publish, so events go out
+	// in commit order however many instances run
+	outboxRelayLockID = 72_150_002
+	// outboxMaxAttempts failed publishes dead-letter an event
+	outboxMaxAttempts = 10
+)
+
+type DbOutboxRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewOutboxRepository(db *sql.DB, logger *zap.Logger) *DbOutboxRepository {
+	return &DbOutboxRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *DbOutboxRepository) PublishBatch(ctx context.Context, limit int, publish func(events.Event) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Another relay holding the lock is publishing; it will get to ours
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxRelayLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, event_id, event_type, aggregate_type, aggregate_id, payload, created_at
+	          FROM outbox_events WHERE published_at IS NULL AND dead_lettered_at IS NULL
+	          ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	var batch []events.Event
+	for rows.Next() {
+		var id int64
+		var event events.Event
+		var payload []byte
+		err := rows.Scan(&id, &event.ID, &event.Type, &event.AggregateType, &event.AggregateID, &payload, &event.OccurredAt)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		event.Payload = payload
+		ids = append(ids, id)
+		batch = append(batch, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	for i, event := range batch {
+		if publishErr = publish(event); publishErr != nil {
+			// An event that keeps failing is dead-lettered so it no longer
+			// holds back the events behind it
+			var deadLettered bool
+			err := tx.QueryRowContext(ctx, `UPDATE outbox_events SET attempts = attempts + 1, last_error = $2,
+			          dead_lettered_at = CASE WHEN attempts + 1 >= $3 THEN now() END
+			          WHERE id = $1 RETURNING dead_lettered_at IS NOT NULL`,
+				ids[i], publishErr.Error(), outboxMaxAttempts).Scan(&deadLettered)
+			if err != nil {
+				return 0, err
+			}
+			if !deadLettered {
+				break
+			}
+			r.logger.Error("Dead-lettered outbox event",
+				zap.String("event_id", event.ID),
+				zap.String("event_type", event.Type),
+				zap.Error(publishErr))
+			publishErr = nil
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE outbox_events SET published_at = now(), attempts = attempts + 1 WHERE id = $1`, ids[i]); err != nil {
+			return 0, err
+		}
+		published++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish event: %w", publishErr)
+	}
+	return published, nil
+}
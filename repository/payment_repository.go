@@ -3,23 +3,72 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/thoraf20/payment-processor/events"
 	"github.com/thoraf20/payment-processor/model"
 
 	"go.uber.org/zap"
 )
 
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrInvalidCursor   = errors.New("invalid pagination cursor")
+	// ErrRefundExceedsCaptured means the refund would take the refunded
+	// amount past the captured amount
+	ErrRefundExceedsCaptured = errors.New("refund exceeds the refundable amount")
+)
+
+const (
+	SortByCreatedAt = "created_at"
+	SortByAmount    = "amount"
+
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// PaymentFilter selects payments for List. Zero values mean "no constraint".
 type PaymentFilter struct {
-	Status    string
-	Currency  string
-	StartDate string
-	EndDate   string
+	Status        model.PaymentStatus
+	Currency      string
+	ProcessorID   string
+	MinAmount     int64
+	MaxAmount     int64
+	Metadata      map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// SortBy is SortByCreatedAt (default) or SortByAmount, SortOrder is
+	// SortDesc (default) or SortAsc. Ties are broken by ID.
+	SortBy    string
+	SortOrder string
+
+	// Limit caps the number of payments returned. StartingAfter is the ID of
+	// the last payment of the previous page.
+	Limit         int
+	StartingAfter string
 }
 
 type PaymentRepository interface {
 	Save(ctx context.Context, payment *model.Payment) error
 	Get(ctx context.Context, id string) (*model.Payment, error)
+	// GetByProcessorReference finds a payment by the reference a processor
+	// knows it by: our ExternalID or the processor's own payment ID
+	GetByProcessorReference(ctx context.Context, processorID, reference string) (*model.Payment, error)
 	List(ctx context.Context, filter PaymentFilter) ([]*model.Payment, error)
+	// ReserveRefund atomically adds amount to the refunded amount unless
+	// that would exceed the captured amount, and returns the updated
+	// payment. Save never writes the refunded amount.
+	ReserveRefund(ctx context.Context, paymentID string, amount int64) (*model.Payment, error)
+	// ReleaseRefund gives back a reservation whose refund failed
+	ReleaseRefund(ctx context.Context, paymentID string, amount int64) error
+	// RecordProcessorFee sets the processor fee of a payment that has none
+	// recorded yet, and publishes payment.fee_recorded when it does
+	RecordProcessorFee(ctx context.Context, paymentID string, fee int64) error
 }
 
 type DbPaymentRepository struct {
@@ -35,40 +84,254 @@ func NewPaymentRepository(db *sql.DB, logger *zap.Logger) *DbPaymentRepository {
 }
 
 func (r *DbPaymentRepository) Save(ctx context.Context, payment *model.Payment) error {
-	// Your implementation here
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the current row so concurrent writers cannot skip a state check
+	var current model.PaymentStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM payments WHERE id = $1 FOR UPDATE`, payment.ID).Scan(&current)
+	isNew := err == sql.ErrNoRows
+	switch {
+	case isNew:
+	case err != nil:
+		return err
+	default:
+		if err := model.ValidateTransition(current, payment.Status); err != nil {
+			return err
+		}
+	}
+
 	query := `INSERT INTO payments (id, external_id, amount, currency, status, payment_method_type, 
-	          payment_method_details, created_at, updated_at, metadata)
-	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	          payment_method_details, created_at, updated_at, metadata, processor_id, processor_payment_id,
+	          captured_amount, refunded_amount, merchant_id, processor_fee, attempts, routing,
+	          estimated_fee)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 	          ON CONFLICT (id) DO UPDATE SET
 	          external_id = $2, amount = $3, currency = $4, status = $5,
 	          payment_method_type = $6, payment_method_details = $7,
-	          updated_at = $9, metadata = $10`
+	          updated_at = $9, metadata = $10, processor_id = $11, processor_payment_id = $12,
+	          captured_amount = $13, merchant_id = $15, processor_fee = $16,
+	          attempts = $17, routing = $18, estimated_fee = $19`
 	
-	_, err := r.db.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		payment.ID,
 		payment.ExternalID,
 		payment.Amount,
 		payment.Currency,
 		payment.Status,
 		payment.PaymentMethod.Type,
-		payment.PaymentMethod.Details,
+		payment.PaymentMethod.Details, // JSONB, card number and CVV stripped
 		payment.CreatedAt,
 		payment.UpdatedAt,
 		payment.Metadata,
+		payment.ProcessorID,
+		payment.ProcessorPaymentID,
+		payment.CapturedAmount,
+		payment.RefundedAmount,
+		payment.MerchantID,
+		payment.ProcessorFee,
+		payment.Attempts,
+		payment.Routing,
+		payment.EstimatedFee,
 	)
+	if err != nil {
+		return err
+	}
+
+	if isNew {
+		if err := writeOutboxEvent(ctx, tx, events.PaymentCreated, "payment", payment.ID, payment); err != nil {
+			return err
+		}
+	}
+	if (isNew && payment.Status != model.StatusPending) || (!isNew && current != payment.Status) {
+		if err := writeOutboxEvent(ctx, tx, "payment."+string(payment.Status), "payment", payment.ID, payment); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *DbPaymentRepository) ReserveRefund(ctx context.Context, paymentID string, amount int64) (*model.Payment, error) {
+	query := `UPDATE payments SET refunded_amount = refunded_amount + $2, updated_at = NOW()
+	          WHERE id = $1 AND refunded_amount + $2 <= captured_amount
+	          RETURNING ` + paymentColumns
+
+	payment, err := scanPayment(r.db.QueryRowContext(ctx, query, paymentID, amount))
+	if err == sql.ErrNoRows {
+		if _, err := r.Get(ctx, paymentID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefundExceedsCaptured
+	}
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func (r *DbPaymentRepository) ReleaseRefund(ctx context.Context, paymentID string, amount int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE payments SET refunded_amount = refunded_amount - $2, updated_at = NOW()
+	          WHERE id = $1`, paymentID, amount)
 	return err
 }
 
+func (r *DbPaymentRepository) RecordProcessorFee(ctx context.Context, paymentID string, fee int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payment, err := scanPayment(tx.QueryRowContext(ctx, `UPDATE payments SET processor_fee = $2
+	          WHERE id = $1 AND processor_fee = 0
+	          RETURNING `+paymentColumns, paymentID, fee))
+	if err == sql.ErrNoRows {
+		// Already recorded, when charging or by an earlier report
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeOutboxEvent(ctx, tx, events.PaymentFeeRecorded, "payment", payment.ID, payment); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const paymentColumns = `id, external_id, amount, currency, status, payment_method_type,
+	payment_method_details, created_at, updated_at, metadata, processor_id, processor_payment_id,
+	captured_amount, refunded_amount, merchant_id, processor_fee, attempts, routing,
+	estimated_fee`
+
 func (r *DbPaymentRepository) Get(ctx context.Context, id string) (*model.Payment, error) {
-	query := `SELECT id, external_id, amount, currency, status, payment_method_type, 
-	         payment_method_details, created_at, updated_at, metadata
-	         FROM payments WHERE id = $1`
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE id = $1`
 	
-	row := r.db.QueryRowContext(ctx, query, id)
+	payment, err := scanPayment(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
 	
+	return payment, nil
+}
+
+func (r *DbPaymentRepository) GetByProcessorReference(ctx context.Context, processorID, reference string) (*model.Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments
+	          WHERE processor_id = $1 AND (external_id = $2 OR processor_payment_id = $2)
+	          LIMIT 1`
+
+	payment, err := scanPayment(r.db.QueryRowContext(ctx, query, processorID, reference))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+func (r *DbPaymentRepository) List(ctx context.Context, filter PaymentFilter) ([]*model.Payment, error) {
+	sortColumn := SortByCreatedAt
+	if filter.SortBy == SortByAmount {
+		sortColumn = SortByAmount
+	}
+	direction, comparison := "DESC", "<"
+	if filter.SortOrder == SortAsc {
+		direction, comparison = "ASC", ">"
+	}
+
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.Currency != "" {
+		where("currency = $%d", filter.Currency)
+	}
+	if filter.ProcessorID != "" {
+		where("processor_id = $%d", filter.ProcessorID)
+	}
+	if filter.MinAmount > 0 {
+		where("amount >= $%d", filter.MinAmount)
+	}
+	if filter.MaxAmount > 0 {
+		where("amount <= $%d", filter.MaxAmount)
+	}
+	if len(filter.Metadata) > 0 {
+		metadata, err := json.Marshal(filter.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		where("metadata @> $%d::jsonb", string(metadata))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		where("created_at >= $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where("created_at < $%d", filter.CreatedBefore)
+	}
+
+	// Keyset pagination: continue strictly after the cursor row in sort
+	// order, so rows inserted meanwhile never shift or repeat a page
+	if filter.StartingAfter != "" {
+		cursor, err := r.Get(ctx, filter.StartingAfter)
+		if err == ErrPaymentNotFound {
+			return nil, ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var cursorValue interface{} = cursor.CreatedAt
+		if sortColumn == SortByAmount {
+			cursorValue = cursor.Amount
+		}
+		args = append(args, cursorValue, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", sortColumn, comparison, len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + paymentColumns + ` FROM payments`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s`, sortColumn, direction, direction)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*model.Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func scanPayment(row rowScanner) (*model.Payment, error) {
 	var payment model.Payment
-	var details []byte // Assuming JSON storage for payment method details
-	
+	var routing model.RoutingDecision
 	err := row.Scan(
 		&payment.ID,
 		&payment.ExternalID,
@@ -76,27 +339,25 @@ func (r *DbPaymentRepository) Get(ctx context.Context, id string) (*model.Paymen
 		&payment.Currency,
 		&payment.Status,
 		&payment.PaymentMethod.Type,
-		&details,
+		&payment.PaymentMethod.Details,
 		&payment.CreatedAt,
 		&payment.UpdatedAt,
 		&payment.Metadata,
+		&payment.ProcessorID,
+		&payment.ProcessorPaymentID,
+		&payment.CapturedAmount,
+		&payment.RefundedAmount,
+		&payment.MerchantID,
+		&payment.ProcessorFee,
+		&payment.Attempts,
+		&routing,
+		&payment.EstimatedFee,
 	)
-	
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Or return a custom "not found" error
-		}
 		return nil, err
 	}
-	
-	// Convert details bytes to map[string]interface{}
-	// You'll need to implement this based on how you store the details
-	// payment.PaymentMethod.Details = parseDetails(details)
-	
+	if routing.Rule != "" {
+		payment.Routing = &routing
+	}
 	return &payment, nil
 }
-
-func (r *DbPaymentRepository) List(ctx context.Context, filter PaymentFilter) ([]*model.Payment, error) {
-	// Implement your listing logic here
-	return nil, nil
-}
\ No newline at end of file
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+type ProviderEventRepository interface {
+	// Claim stores the raw event and claims it for processing. It returns
+	// false when the event was already processed, or when another delivery
+	// claimed it less than lease ago and has not finished.
+	Claim(ctx context.Context, event *model.ProviderEvent, lease time.Duration) (bool, error)
+	MarkProcessed(ctx context.Context, provider, eventID string, processingErr error) error
+}
+
+type DbProviderEventRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewProviderEventRepository(db *sql.DB, logger *zap.Logger) *DbProviderEventRepository {
+	return &DbProviderEventRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *DbProviderEventRepository) Claim(ctx context.Context, event *model.ProviderEvent, lease time.Duration) (bool, error) {
+	// A redelivery takes the event over only if processing it failed, or
+	// if the delivery holding it crashed. The row lock taken by the upsert
+	// makes concurrent deliveries see each other's claim.
+	query := `INSERT INTO provider_events (provider, event_id, event_type, reference, payload, received_at, claimed_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, now())
+	          ON CONFLICT (provider, event_id) DO UPDATE SET
+	          claimed_at = EXCLUDED.claimed_at, processed_at = NULL, error = NULL
+	          WHERE provider_events.error IS NOT NULL
+	             OR (provider_events.processed_at IS NULL
+	                 AND provider_events.claimed_at < now() - $7 * interval '1 second')
+	          RETURNING event_id`
+
+	var eventID string
+	err := r.db.QueryRowContext(ctx, query,
+		event.Provider,
+		event.EventID,
+		event.Type,
+		event.Reference,
+		event.Payload,
+		event.ReceivedAt,
+		lease.Seconds(),
+	).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *DbProviderEventRepository) MarkProcessed(ctx context.Context, provider, eventID string, processingErr error) error {
+	var errMsg sql.NullString
+	if processingErr != nil {
+		errMsg = sql.NullString{String: processingErr.Error(), Valid: true}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE provider_events SET processed_at = now(), error = $3 WHERE provider = $1 AND event_id = $2`,
+		provider, eventID, errMsg,
+	)
+	return err
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+var ErrReconciliationReportNotFound = errors.New("reconciliation report not found")
+
+type ReconciliationRepository interface {
+	// Save stores a report together with its discrepancies
+	Save(ctx context.Context, report *model.ReconciliationReport) error
+	// Get returns a report with its discrepancies
+	Get(ctx context.Context, id string) (*model.ReconciliationReport, error)
+	// List returns report summaries, newest first
+	List(ctx context.Context, processorID string, limit int) ([]*model.ReconciliationReport, error)
+}
+
+type DbReconciliationRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewReconciliationRepository(db *sql.DB, logger *zap.Logger) *DbReconciliationRepository {
+	return &DbReconciliationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const reportColumns = `id, processor_id, source, period_start, period_end, row_count, matched, missing, extra,
+	amount_mismatched, status_mismatched, created_at`
+
+func (r *DbReconciliationRepository) Save(ctx context.Context, report *model.ReconciliationReport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO reconciliation_reports (`+reportColumns+`)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		report.ID,
+		report.ProcessorID,
+		report.Source,
+		report.PeriodStart,
+		report.PeriodEnd,
+		report.Rows,
+		report.Matched,
+		report.Missing,
+		report.Extra,
+		report.AmountMismatched,
+		report.StatusMismatched,
+		report.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range report.Discrepancies {
+		_, err = tx.ExecContext(ctx, `INSERT INTO reconciliation_discrepancies
+		          (report_id, kind, payment_id, reference, currency, expected_amount, actual_amount,
+		           expected_status, actual_status, detail)
+		          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			report.ID,
+			d.Kind,
+			d.PaymentID,
+			d.Reference,
+			d.Currency,
+			d.ExpectedAmount,
+			d.ActualAmount,
+			d.ExpectedStatus,
+			d.ActualStatus,
+			d.Detail,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *DbReconciliationRepository) Get(ctx context.Context, id string) (*model.ReconciliationReport, error) {
+	query := `SELECT ` + reportColumns + ` FROM reconciliation_reports WHERE id = $1`
+
+	report, err := scanReport(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrReconciliationReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT kind, payment_id, reference, currency, expected_amount,
+	          actual_amount, expected_status, actual_status, detail
+	          FROM reconciliation_discrepancies WHERE report_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Discrepancies = []*model.ReconciliationDiscrepancy{}
+	for rows.Next() {
+		var d model.ReconciliationDiscrepancy
+		if err := rows.Scan(
+			&d.Kind,
+			&d.PaymentID,
+			&d.Reference,
+			&d.Currency,
+			&d.ExpectedAmount,
+			&d.ActualAmount,
+			&d.ExpectedStatus,
+			&d.ActualStatus,
+			&d.Detail,
+		); err != nil {
+			return nil, err
+		}
+		report.Discrepancies = append(report.Discrepancies, &d)
+	}
+	return report, rows.Err()
+}
+
+func (r *DbReconciliationRepository) List(ctx context.Context, processorID string, limit int) ([]*model.ReconciliationReport, error) {
+	query := `SELECT ` + reportColumns + ` FROM reconciliation_reports
+	          WHERE ($1 = '' OR processor_id = $1)
+	          ORDER BY created_at DESC, id DESC`
+	args := []interface{}{processorID}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*model.ReconciliationReport{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func scanReport(row rowScanner) (*model.ReconciliationReport, error) {
+	var report model.ReconciliationReport
+	err := row.Scan(
+		&report.ID,
+		&report.ProcessorID,
+		&report.Source,
+		&report.PeriodStart,
+		&report.PeriodEnd,
+		&report.Rows,
+		&report.Matched,
+		&report.Missing,
+		&report.Extra,
+		&report.AmountMismatched,
+		&report.StatusMismatched,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
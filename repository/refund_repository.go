@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/thoraf20/payment-processor/events"
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+var ErrRefundNotFound = errors.New("refund not found")
+
+type RefundRepository interface {
+	Save(ctx context.Context, refund *model.Refund) error
+	Get(ctx context.Context, id string) (*model.Refund, error)
+	ListByPayment(ctx context.Context, paymentID string) ([]*model.Refund, error)
+}
+
+type DbRefundRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewRefundRepository(db *sql.DB, logger *zap.Logger) *DbRefundRepository {
+	return &DbRefundRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const refundColumns = `id, payment_id, amount, currency, reason, status, processor_refund_id, created_at, updated_at`
+
+func (r *DbRefundRepository) Save(ctx context.Context, refund *model.Refund) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current model.RefundStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM refunds WHERE id = $1 FOR UPDATE`, refund.ID).Scan(&current)
+	isNew := err == sql.ErrNoRows
+	if err != nil && !isNew {
+		return err
+	}
+
+	query := `INSERT INTO refunds (` + refundColumns + `)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	          ON CONFLICT (id) DO UPDATE SET
+	          status = $6, processor_refund_id = $7, updated_at = $9`
+
+	_, err = tx.ExecContext(ctx, query,
+		refund.ID,
+		refund.PaymentID,
+		refund.Amount,
+		refund.Currency,
+		refund.Reason,
+		refund.Status,
+		refund.ProcessorRefundID,
+		refund.CreatedAt,
+		refund.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if isNew {
+		if err := writeOutboxEvent(ctx, tx, events.RefundCreated, "refund", refund.ID, refund); err != nil {
+			return err
+		}
+	}
+	if (isNew && refund.Status != model.RefundPending) || (!isNew && current != refund.Status) {
+		if err := writeOutboxEvent(ctx, tx, "refund."+string(refund.Status), "refund", refund.ID, refund); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *DbRefundRepository) Get(ctx context.Context, id string) (*model.Refund, error) {
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE id = $1`
+
+	refund, err := scanRefund(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrRefundNotFound
+	}
+	return refund, err
+}
+
+func (r *DbRefundRepository) ListByPayment(ctx context.Context, paymentID string) ([]*model.Refund, error) {
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE payment_id = $1 ORDER BY created_at, id`
+
+	rows, err := r.db.QueryContext(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []*model.Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, rows.Err()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRefund(row rowScanner) (*model.Refund, error) {
+	var refund model.Refund
+	err := row.Scan(
+		&refund.ID,
+		&refund.PaymentID,
+		&refund.Amount,
+		&refund.Currency,
+		&refund.Reason,
+		&refund.Status,
+		&refund.ProcessorRefundID,
+		&refund.CreatedAt,
+		&refund.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
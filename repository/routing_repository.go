@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrRuleSetNotFound = errors.New("no routing rule set stored")
+	// ErrRuleSetConflict means another change saved the version first
+	ErrRuleSetConflict = errors.New("routing rules were changed concurrently")
+)
+
+// RoutingRepository stores runtime routing configuration. Every change is
+// written together with its audit entry.
+type RoutingRepository interface {
+	// LatestRuleSet returns the newest rule set version
+	LatestRuleSet(ctx context.Context) (*model.RoutingRuleSet, error)
+	// SaveRuleSet stores set as a new version, which must not exist yet
+	SaveRuleSet(ctx context.Context, set *model.RoutingRuleSet, audit *model.AuditEntry) error
+	ProcessorSettings(ctx context.Context) ([]*model.ProcessorSetting, error)
+	SaveProcessorSetting(ctx context.Context, setting *model.ProcessorSetting, audit *model.AuditEntry) error
+	// AuditLog returns entries newest first
+	AuditLog(ctx context.Context, limit int) ([]*model.AuditEntry, error)
+}
+
+type DbRoutingRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewRoutingRepository(db *sql.DB, logger *zap.Logger) *DbRoutingRepository {
+	return &DbRoutingRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *DbRoutingRepository) LatestRuleSet(ctx context.Context) (*model.RoutingRuleSet, error) {
+	var set model.RoutingRuleSet
+	var config []byte
+	err := r.db.QueryRowContext(ctx, `SELECT version, config, created_by, created_at
+	          FROM routing_rule_sets ORDER BY version DESC LIMIT 1`).
+		Scan(&set.Version, &config, &set.CreatedBy, &set.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrRuleSetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	set.Config = config
+	return &set, nil
+}
+
+func (r *DbRoutingRepository) SaveRuleSet(ctx context.Context, set *model.RoutingRuleSet, audit *model.AuditEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO routing_rule_sets (version, config, created_by)
+	          VALUES ($1, $2, $3)
+	          ON CONFLICT (version) DO NOTHING`,
+		set.Version, []byte(set.Config), set.CreatedBy)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRuleSetConflict
+	}
+
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *DbRoutingRepository) ProcessorSettings(ctx context.Context) ([]*model.ProcessorSetting, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT processor_id, enabled, updated_by, updated_at
+	          FROM processor_settings ORDER BY processor_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := []*model.ProcessorSetting{}
+	for rows.Next() {
+		var s model.ProcessorSetting
+		if err := rows.Scan(&s.ProcessorID, &s.Enabled, &s.UpdatedBy, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, &s)
+	}
+	return settings, rows.Err()
+}
+
+func (r *DbRoutingRepository) SaveProcessorSetting(ctx context.Context, setting *model.ProcessorSetting, audit *model.AuditEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO processor_settings (processor_id, enabled, updated_by, updated_at)
+	          VALUES ($1, $2, $3, NOW())
+	          ON CONFLICT (processor_id) DO UPDATE SET
+	          enabled = $2, updated_by = $3, updated_at = NOW()`,
+		setting.ProcessorID, setting.Enabled, setting.UpdatedBy)
+	if err != nil {
+		return err
+	}
+
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *DbRoutingRepository) AuditLog(ctx context.Context, limit int) ([]*model.AuditEntry, error) {
+	query := `SELECT id, actor, action, target, before, after, created_at
+	          FROM admin_audit_log ORDER BY id DESC`
+	var args []interface{}
+	if limit > 0 {
+		query += ` LIMIT $1`
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*model.AuditEntry{}
+	for rows.Next() {
+		var e model.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &before, &after, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+func insertAudit(ctx context.Context, tx *sql.Tx, entry *model.AuditEntry) error {
+	return tx.QueryRowContext(ctx, `INSERT INTO admin_audit_log (actor, action, target, before, after)
+	          VALUES ($1, $2, $3, $4, $5)
+	          RETURNING id, created_at`,
+		entry.Actor, entry.Action, entry.Target, nullJSON(entry.Before), nullJSON(entry.After)).
+		Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// nullJSON stores an empty document as NULL
+func nullJSON(doc []byte) interface{} {
+	if len(doc) == 0 {
+		return nil
+	}
+	return doc
+}
@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// DeliveryFilter selects deliveries for the admin API
+type DeliveryFilter struct {
+	Status     model.DeliveryStatus
+	EndpointID string
+	Limit      int
+}
+
+type WebhookRepository interface {
+	CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error
+	GetEndpoint(ctx context.Context, id string) (*model.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error)
+	// SubscribedEndpoints returns the enabled endpoints listening for eventType
+	SubscribedEndpoints(ctx context.Context, eventType string) ([]*model.WebhookEndpoint, error)
+
+	// CreateDelivery queues a delivery; queuing the same event twice for an
+	// endpoint is a no-op
+	CreateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+	GetDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, filter DeliveryFilter) ([]*model.WebhookDelivery, error)
+	// ClaimDue leases up to limit due deliveries so that no other instance
+	// picks them up until lease has passed
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error)
+	// RecordAttempt stores an attempt together with the delivery's new state
+	RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.DeliveryAttempt) error
+	ListAttempts(ctx context.Context, deliveryID string) ([]*model.DeliveryAttempt, error)
+	// Redeliver makes a delivery due immediately, reviving dead ones
+	Redeliver(ctx context.Context, id string) (*model.WebhookDelivery, error)
+}
+
+type DbWebhookRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewWebhookRepository(db *sql.DB, logger *zap.Logger) *DbWebhookRepository {
+	return &DbWebhookRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const (
+	endpointColumns = `id, url, secret, event_types, enabled, created_at`
+	deliveryColumns = `id, endpoint_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_error, created_at, updated_at`
+)
+
+func (r *DbWebhookRepository) CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error {
+	query := `INSERT INTO webhook_endpoints (` + endpointColumns + `) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := r.db.ExecContext(ctx, query,
+		endpoint.ID,
+		endpoint.URL,
+		endpoint.Secret,
+		pq.Array(endpoint.EventTypes),
+		endpoint.Enabled,
+		endpoint.CreatedAt,
+	)
+	return err
+}
+
+func (r *DbWebhookRepository) GetEndpoint(ctx context.Context, id string) (*model.WebhookEndpoint, error) {
+	query := `SELECT ` + endpointColumns + ` FROM webhook_endpoints WHERE id = $1`
+
+	endpoint, err := scanEndpoint(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookEndpointNotFound
+	}
+	return endpoint, err
+}
+
+func (r *DbWebhookRepository) ListEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error) {
+	return r.queryEndpoints(ctx, `SELECT `+endpointColumns+` FROM webhook_endpoints ORDER BY created_at`)
+}
+
+func (r *DbWebhookRepository) SubscribedEndpoints(ctx context.Context, eventType string) ([]*model.WebhookEndpoint, error) {
+	return r.queryEndpoints(ctx, `SELECT `+endpointColumns+` FROM webhook_endpoints
+	          WHERE enabled AND ($1 = ANY(event_types) OR '*' = ANY(event_types))`, eventType)
+}
+
+func (r *DbWebhookRepository) queryEndpoints(ctx context.Context, query string, args ...interface{}) ([]*model.WebhookEndpoint, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*model.WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, rows.Err()
+}
+
+func (r *DbWebhookRepository) CreateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	query := `INSERT INTO webhook_deliveries (` + deliveryColumns + `)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	          ON CONFLICT (endpoint_id, event_id) DO NOTHING`
+
+	_, err := r.db.ExecContext(ctx, query,
+		delivery.ID,
+		delivery.EndpointID,
+		delivery.EventID,
+		delivery.EventType,
+		[]byte(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastError,
+		delivery.CreatedAt,
+		delivery.UpdatedAt,
+	)
+	return err
+}
+
+func (r *DbWebhookRepository) GetDelivery(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id = $1`
+
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (r *DbWebhookRepository) ListDeliveries(ctx context.Context, filter DeliveryFilter) ([]*model.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+	          WHERE ($1 = '' OR status = $1) AND ($2 = '' OR endpoint_id = $2)
+	          ORDER BY created_at DESC, id DESC`
+	args := []interface{}{filter.Status, filter.EndpointID}
+	if filter.Limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, filter.Limit)
+	}
+
+	return r.queryDeliveries(ctx, query, args...)
+}
+
+func (r *DbWebhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error) {
+	// Pushing next_attempt_at past the lease hides the rows from other
+	// workers while this one makes its HTTP calls
+	query := `UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 second'
+	          WHERE id IN (
+	              SELECT id FROM webhook_deliveries
+	              WHERE status = $3 AND next_attempt_at <= now()
+	              ORDER BY next_attempt_at
+	              LIMIT $1
+	              FOR UPDATE SKIP LOCKED
+	          )
+	          RETURNING ` + deliveryColumns
+
+	return r.queryDeliveries(ctx, query, limit, lease.Seconds(), model.DeliveryPending)
+}
+
+func (r *DbWebhookRepository) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.DeliveryAttempt) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery_attempts
+	          (delivery_id, attempt, status_code, error, duration_ms, attempted_at)
+	          VALUES ($1, $2, $3, $4, $5, $6)`,
+		attempt.DeliveryID,
+		attempt.Attempt,
+		sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: attempt.StatusCode != 0},
+		attempt.Error,
+		attempt.DurationMs,
+		attempt.AttemptedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries
+	          SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5, updated_at = $6
+	          WHERE id = $1`,
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastError,
+		delivery.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *DbWebhookRepository) ListAttempts(ctx context.Context, deliveryID string) ([]*model.DeliveryAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT delivery_id, attempt, status_code, error, duration_ms, attempted_at
+	          FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY attempt`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*model.DeliveryAttempt{}
+	for rows.Next() {
+		var attempt model.DeliveryAttempt
+		var statusCode sql.NullInt64
+		err := rows.Scan(
+			&attempt.DeliveryID,
+			&attempt.Attempt,
+			&statusCode,
+			&attempt.Error,
+			&attempt.DurationMs,
+			&attempt.AttemptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempt.StatusCode = int(statusCode.Int64)
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (r *DbWebhookRepository) Redeliver(ctx context.Context, id string) (*model.WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries SET status = $2, next_attempt_at = now(), updated_at = now()
+	          WHERE id = $1
+	          RETURNING ` + deliveryColumns
+
+	deliveries, err := r.queryDeliveries(ctx, query, id, model.DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	return deliveries[0], nil
+}
+
+func (r *DbWebhookRepository) queryDeliveries(ctx context.Context, query string, args ...interface{}) ([]*model.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*model.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanEndpoint(row rowScanner) (*model.WebhookEndpoint, error) {
+	var endpoint model.WebhookEndpoint
+	err := row.Scan(
+		&endpoint.ID,
+		&endpoint.URL,
+		&endpoint.Secret,
+		pq.Array(&endpoint.EventTypes),
+		&endpoint.Enabled,
+		&endpoint.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func scanDelivery(row rowScanner) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var payload []byte
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.EndpointID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	return &delivery, nil
+}
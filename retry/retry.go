@@ -0,0 +1,232 @@
+// Package retry is the shared retry layer for processor adapters. It
+// classifies failures as retryable or terminal, retries with exponential
+// backoff and jitter inside the caller's deadline, and records every
+// attempt.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/thoraf20/payment-processor/observability"
+	"go.uber.org/zap"
+)
+
+// Policy bounds how hard a call is retried
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts: 3,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// Call describes the operation being attempted
+type Call struct {
+	Processor string
+	Operation string
+	// Idempotent calls are safe to repeat after any retryable failure.
+	// Other calls are only repeated when the provider cannot have acted
+	// on them: the connection was never made, or it answered 429.
+	Idempotent bool
+}
+
+// StatusError is a non-2xx response from a provider
+type StatusError struct {
+	StatusCode int
+	Message    string
+	// Wait is the provider's Retry-After, if it sent one
+	Wait time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *StatusError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+func (e *StatusError) Retryable() bool {
+	return RetryableStatus(e.StatusCode)
+}
+
+func (e *StatusError) RetryDelay() time.Duration {
+	return e.Wait
+}
+
+// RetryableStatus reports whether a response status is worth retrying
+func RetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500 && code != http.StatusNotImplemented
+}
+
+// ParseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date
+func ParseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Classification is the retry layer's view of an error
+type Classification struct {
+	Retryable bool
+	// Unsent means the provider never acted on the request, so even a
+	// non-idempotent call may be repeated
+	Unsent bool
+	// After is the delay the provider asked for
+	After time.Duration
+}
+
+// Classify decides whether err is worth retrying. Errors can opt in by
+// implementing Retryable() bool and, optionally, RetryDelay() time.Duration
+// and HTTPStatus() int.
+func Classify(err error) Classification {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return Classification{}
+	}
+
+	var custom interface{ Retryable() bool }
+	if errors.As(err, &custom) {
+		c := Classification{Retryable: custom.Retryable()}
+		var delayed interface{ RetryDelay() time.Duration }
+		if errors.As(err, &delayed) {
+			c.After = delayed.RetryDelay()
+		}
+		// A rate-limited request was turned away before it was processed
+		var status interface{ HTTPStatus() int }
+		if errors.As(err, &status) && status.HTTPStatus() == http.StatusTooManyRequests {
+			c.Unsent = true
+		}
+		return c
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return Classification{Retryable: true, Unsent: true}
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return Classification{Retryable: true, Unsent: true}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Classification{Retryable: true}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+		return Classification{Retryable: true}
+	}
+	return Classification{}
+}
+
+// IsRetryable reports whether err is worth retrying for an idempotent call
+func IsRetryable(err error) bool {
+	return Classify(err).Retryable
+}
+
+// Retrier runs calls under a Policy
+type Retrier struct {
+	policy Policy
+	logger *zap.Logger
+}
+
+func NewRetrier(policy Policy, logger *zap.Logger) *Retrier {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &Retrier{policy: policy, logger: logger}
+}
+
+// Do runs fn until it succeeds, fails terminally or runs out of attempts.
+// A retry is never started if its delay would overrun ctx's deadline; the
+// last error is returned instead.
+func (r *Retrier) Do(ctx context.Context, call Call, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := fn(ctx)
+		elapsed := time.Since(start)
+
+		if err == nil {
+			r.record(call, attempt, "success", elapsed, nil)
+			return nil
+		}
+
+		c := Classify(err)
+		if !c.Retryable || !(call.Idempotent || c.Unsent) {
+			r.record(call, attempt, "terminal", elapsed, err)
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			r.record(call, attempt, "exhausted", elapsed, err)
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+		}
+
+		delay := c.After
+		if delay <= 0 {
+			delay = r.backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			r.record(call, attempt, "deadline", elapsed, err)
+			return fmt.Errorf("%w (no time left to retry after %d attempts)", err, attempt)
+		}
+		r.record(call, attempt, "retry", elapsed, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff doubles the base delay per attempt, capped at MaxDelay, and
+// picks uniformly from its upper half so that callers spread out
+func (r *Retrier) backoff(attempt int) time.Duration {
+	delay := r.policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > r.policy.MaxDelay {
+		delay = r.policy.MaxDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+func (r *Retrier) record(call Call, attempt int, outcome string, elapsed time.Duration, err error) {
+	observability.ObserveProcessorAttempt(call.Processor, call.Operation, outcome, elapsed)
+
+	fields := []zap.Field{
+		zap.String("processor", call.Processor),
+		zap.String("operation", call.Operation),
+		zap.Int("attempt", attempt),
+		zap.String("outcome", outcome),
+		zap.Duration("duration", elapsed),
+	}
+	if err == nil {
+		r.logger.Debug("Processor call attempt", fields...)
+		return
+	}
+	r.logger.Warn("Processor call attempt failed", append(fields, zap.Error(err))...)
+}
@@ -0,0 +1,199 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// declined opts out of retries the way provider decline errors do
+type declined struct{}
+
+func (declined) Error() string   { return "card declined" }
+func (declined) Retryable() bool { return false }
+
+func TestClassify(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	read := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	tests := []struct {
+		name string
+		err  error
+		want Classification
+	}{
+		{"nil", nil, Classification{}},
+		{"rate limited", &StatusError{StatusCode: http.StatusTooManyRequests, Wait: 2 * time.Second}, Classification{Retryable: true, Unsent: true, After: 2 * time.Second}},
+		{"server error", &StatusError{StatusCode: http.StatusServiceUnavailable}, Classification{Retryable: true}},
+		{"bad gateway", &StatusError{StatusCode: http.StatusBadGateway}, Classification{Retryable: true}},
+		{"not implemented", &StatusError{StatusCode: http.StatusNotImplemented}, Classification{}},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, Classification{}},
+		{"unauthorized", &StatusError{StatusCode: http.StatusUnauthorized}, Classification{}},
+		{"wrapped status", fmt.Errorf("stripe: %w", &StatusError{StatusCode: http.StatusInternalServerError}), Classification{Retryable: true}},
+		{"dial", dial, Classification{Retryable: true, Unsent: true}},
+		{"connection refused", fmt.Errorf("post: %w", syscall.ECONNREFUSED), Classification{Retryable: true, Unsent: true}},
+		{"connection reset", read, Classification{Retryable: true}},
+		{"timeout", timeoutError{}, Classification{Retryable: true}},
+		{"deadline", context.DeadlineExceeded, Classification{Retryable: true}},
+		{"eof", io.EOF, Classification{Retryable: true}},
+		{"unexpected eof", io.ErrUnexpectedEOF, Classification{Retryable: true}},
+		{"canceled", context.Canceled, Classification{}},
+		{"canceled status", fmt.Errorf("%w: %w", context.Canceled, &StatusError{StatusCode: http.StatusServiceUnavailable}), Classification{}},
+		{"opted out", declined{}, Classification{}},
+		{"unknown", errors.New("invalid currency"), Classification{}},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("%s: Classify() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := ParseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("ParseRetryAfter(3) = %v, want 3s", got)
+	}
+	for _, header := range []string{"", "0", "-1", "soon", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)} {
+		if got := ParseRetryAfter(header); got != 0 {
+			t.Errorf("ParseRetryAfter(%q) = %v, want 0", header, got)
+		}
+	}
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := ParseRetryAfter(at); got <= 0 || got > time.Minute {
+		t.Errorf("ParseRetryAfter(%q) = %v, want up to a minute", at, got)
+	}
+}
+
+func testRetrier() *Retrier {
+	return NewRetrier(Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}, zap.NewNop())
+}
+
+// failing returns errs in turn, then succeeds
+func failing(calls *int, errs ...error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	limited := &StatusError{StatusCode: http.StatusTooManyRequests}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		errs       []error
+		wantCalls  int
+		wantErr    bool
+	}{
+		{"idempotent recovers", true, []error{unavailable, io.EOF}, 3, false},
+		{"idempotent exhausted", true, []error{unavailable, unavailable, unavailable}, 3, true},
+		{"terminal", true, []error{&StatusError{StatusCode: http.StatusBadRequest}}, 1, true},
+		// The provider may have charged before failing, so a POST is not repeated
+		{"post server error", false, []error{unavailable}, 1, true},
+		{"post timeout", false, []error{timeoutError{}}, 1, true},
+		{"post reset", false, []error{io.ErrUnexpectedEOF}, 1, true},
+		// ...unless the provider never acted on it
+		{"post rate limited", false, []error{limited}, 2, false},
+		{"post not connected", false, []error{refused}, 2, false},
+	}
+	for _, tt := range tests {
+		calls := 0
+		call := Call{Processor: "stripe", Operation: "authorize", Idempotent: tt.idempotent}
+		err := testRetrier().Do(context.Background(), call, failing(&calls, tt.errs...))
+		if calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Do() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDoKeepsLastError(t *testing.T) {
+	calls := 0
+	last := &StatusError{StatusCode: http.StatusBadGateway}
+	err := testRetrier().Do(context.Background(), Call{Idempotent: true},
+		failing(&calls, &StatusError{StatusCode: http.StatusServiceUnavailable}, io.EOF, last))
+
+	var status *StatusError
+	if !errors.As(err, &status) || status != last {
+		t.Fatalf("Do() error = %v, want the last attempt's error", err)
+	}
+	if !strings.Contains(err.Error(), "gave up after 3 attempts") {
+		t.Errorf("Do() error = %q, want the attempt count", err)
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	// Backoff alone would wait far past the deadline
+	r := NewRetrier(Policy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}, zap.NewNop())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	wait := 50 * time.Millisecond
+	err := r.Do(ctx, Call{}, failing(&calls, &StatusError{StatusCode: http.StatusTooManyRequests, Wait: wait}))
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("%d calls, want 2", calls)
+	}
+	if elapsed := time.Since(start); elapsed < wait || elapsed > time.Second {
+		t.Errorf("retried after %v, want the provider's %v", elapsed, wait)
+	}
+}
+
+func TestDoStopsWhenRetryAfterPassesDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := testRetrier().Do(ctx, Call{Idempotent: true},
+		failing(&calls, &StatusError{StatusCode: http.StatusServiceUnavailable, Wait: time.Minute}))
+	if err == nil || !strings.Contains(err.Error(), "no time left to retry") {
+		t.Errorf("Do() error = %v, want the deadline to stop the retry", err)
+	}
+	if calls != 1 {
+		t.Errorf("%d calls, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Do() waited %v before giving up", elapsed)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	r := NewRetrier(Policy{MaxAttempts: 100, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, zap.NewNop())
+	for attempt := 1; attempt <= 80; attempt++ {
+		want := 100 * time.Millisecond << (attempt - 1)
+		if attempt > 4 {
+			want = time.Second
+		}
+		if got := r.backoff(attempt); got < want/2 || got > want {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v]", attempt, got, want/2, want)
+		}
+	}
+}
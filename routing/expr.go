@@ -0,0 +1,563 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// Expressions are a small, typed language over payment fields:
+//
+//	currency == "NGN"
+//	currency == "USD" && amount > 50000
+//	card.bin_country in ["NG", "GH"] || metadata["channel"] == "ussd"
+//	not (method == "card")
+//
+// Fields:
+//
+//	currency         string, upper case
+//	amount           number, in the currency's minor unit
+//	method           string, the payment method type
+//	card.brand       string
+//	card.bin         string, the card number's first six digits
+//	card.bin_country string, ISO country of the issuing bank
+//	merchant         string
+//	metadata.KEY     string, also metadata["KEY"]; "" when unset
+//
+// Operators are ==, !=, <, <=, >, >= (numbers only), in and not in against
+// a list literal, and &&, ||, ! or their spellings and, or, not. Expressions
+// are type checked when compiled, so a rule that loads never fails at
+// evaluation time.
+
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeNumber
+	typeBool
+	typeList
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeNumber:
+		return "number"
+	case typeBool:
+		return "bool"
+	default:
+		return "list"
+	}
+}
+
+// Env is what an expression is evaluated against
+type Env struct {
+	Payment *model.Payment
+	// BINCountries resolve a card BIN to its issuing country
+	BINCountries model.BINCountries
+}
+
+// Expr is a compiled boolean expression
+type Expr struct {
+	source string
+	root   node
+}
+
+// Compile parses and type checks an expression, which must be boolean
+func Compile(source string) (*Expr, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	if root.typ() != typeBool {
+		return nil, fmt.Errorf("expression is a %s, not a condition", root.typ())
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval reports whether the payment matches
+func (e *Expr) Eval(env Env) bool {
+	return e.root.eval(env).(bool)
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != c {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			text := source[i+1 : end]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(source[i : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+				}
+				text = unquoted
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			end := i + 1
+			for end < len(source) && (source[end] >= '0' && source[end] <= '9' || source[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: source[i:end], pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(source) && (source[end] == '_' || source[end] == '.' ||
+				unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: source[i:end], pos: i})
+			i = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(source)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the given operators or
+// keywords
+func (p *parser) accept(texts ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp && tok.kind != tokIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, got %s at offset %d", text, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool("or", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{and: false, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool("and", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool("not", operand); err != nil {
+			return nil, err
+		}
+		return &negation{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	negated := false
+	if tok := p.peek(); tok.kind == tokIdent && tok.text == "not" {
+		if after := p.tokens[p.pos+1]; after.kind == tokIdent && after.text == "in" {
+			p.pos++
+			negated = true
+		}
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "in")
+	if !ok {
+		if negated {
+			return nil, p.expect("in")
+		}
+		return left, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "in":
+		list, isList := right.(*listLiteral)
+		if !isList {
+			return nil, fmt.Errorf("in needs a list, e.g. [\"NGN\", \"GHS\"]")
+		}
+		if len(list.items) > 0 && list.elem != left.typ() {
+			return nil, fmt.Errorf("cannot look up a %s in a list of %s", left.typ(), list.elem)
+		}
+		var n node = &membership{item: left, list: list}
+		if negated {
+			n = &negation{operand: n}
+		}
+		return n, nil
+	case "<", "<=", ">", ">=":
+		if left.typ() != typeNumber || right.typ() != typeNumber {
+			return nil, fmt.Errorf("%s compares numbers, got %s and %s", op, left.typ(), right.typ())
+		}
+	default:
+		if left.typ() != right.typ() || left.typ() == typeList {
+			return nil, fmt.Errorf("cannot compare %s %s %s", left.typ(), op, right.typ())
+		}
+	}
+	return &comparison{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return &literal{value: tok.text, t: typeString}, nil
+	case tokNumber:
+		n, err := strconv.ParseInt(strings.ReplaceAll(tok.text, "_", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at offset %d", tok, tok.pos)
+		}
+		return &literal{value: n, t: typeNumber}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &literal{value: tok.text == "true", t: typeBool}, nil
+		case "metadata":
+			// metadata["key"]
+			if err := p.expect("["); err != nil {
+				return nil, err
+			}
+			key := p.next()
+			if key.kind != tokString {
+				return nil, fmt.Errorf("expected a metadata key string, got %s at offset %d", key, key.pos)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return metadataField(key.text), nil
+		}
+		return lookupField(tok)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *parser) parseList() (node, error) {
+	list := &listLiteral{}
+	if _, ok := p.accept("]"); ok {
+		return list, nil
+	}
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		lit, ok := item.(*literal)
+		if !ok {
+			return nil, fmt.Errorf("lists may only hold literals")
+		}
+		if len(list.items) > 0 && lit.t != list.elem {
+			return nil, fmt.Errorf("list mixes %s and %s", list.elem, lit.t)
+		}
+		list.elem = lit.t
+		list.items = append(list.items, lit.value)
+
+		if _, ok := p.accept("]"); ok {
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func checkBool(op string, operands ...node) error {
+	for _, operand := range operands {
+		if operand.typ() != typeBool {
+			return fmt.Errorf("%s needs conditions, got a %s", op, operand.typ())
+		}
+	}
+	return nil
+}
+
+// Fields
+
+type fieldFunc func(env Env) interface{}
+
+func detail(env Env, key string) string {
+	if v, ok := env.Payment.PaymentMethod.Details[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+var fields = map[string]struct {
+	t   valueType
+	get fieldFunc
+}{
+	"currency": {typeString, func(env Env) interface{} { return strings.ToUpper(env.Payment.Currency) }},
+	"amount":   {typeNumber, func(env Env) interface{} { return env.Payment.Amount }},
+	"method":   {typeString, func(env Env) interface{} { return env.Payment.PaymentMethod.Type }},
+	"merchant": {typeString, func(env Env) interface{} { return env.Payment.MerchantID }},
+	"card.brand": {typeString, func(env Env) interface{} {
+		return detail(env, "brand")
+	}},
+	"card.bin": {typeString, func(env Env) interface{} {
+		return detail(env, "bin")
+	}},
+	"card.bin_country": {typeString, func(env Env) interface{} {
+		return env.Payment.CardCountry(env.BINCountries)
+	}},
+}
+
+func lookupField(tok token) (node, error) {
+	if key, ok := strings.CutPrefix(tok.text, "metadata."); ok && key != "" {
+		return metadataField(key), nil
+	}
+	f, ok := fields[tok.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at offset %d", tok.text, tok.pos)
+	}
+	return &field{name: tok.text, t: f.t, get: f.get}, nil
+}
+
+func metadataField(key string) node {
+	return &field{name: "metadata." + key, t: typeString, get: func(env Env) interface{} {
+		return env.Payment.Metadata[key]
+	}}
+}
+
+// AST
+
+type node interface {
+	typ() valueType
+	eval(env Env) interface{}
+}
+
+type literal struct {
+	value interface{}
+	t     valueType
+}
+
+func (n *literal) typ() valueType         { return n.t }
+func (n *literal) eval(_ Env) interface{} { return n.value }
+
+type listLiteral struct {
+	items []interface{}
+	elem  valueType
+}
+
+func (n *listLiteral) typ() valueType         { return typeList }
+func (n *listLiteral) eval(_ Env) interface{} { return n.items }
+
+type field struct {
+	name string
+	t    valueType
+	get  fieldFunc
+}
+
+func (n *field) typ() valueType           { return n.t }
+func (n *field) eval(env Env) interface{} { return n.get(env) }
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n *comparison) typ() valueType { return typeBool }
+
+func (n *comparison) eval(env Env) interface{} {
+	left, right := n.left.eval(env), n.right.eval(env)
+	switch n.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+	l, r := left.(int64), right.(int64)
+	switch n.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+type membership struct {
+	item node
+	list *listLiteral
+}
+
+func (n *membership) typ() valueType { return typeBool }
+
+func (n *membership) eval(env Env) interface{} {
+	item := n.item.eval(env)
+	for _, candidate := range n.list.items {
+		if item == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n *logical) typ() valueType { return typeBool }
+
+func (n *logical) eval(env Env) interface{} {
+	left := n.left.eval(env).(bool)
+	if n.and {
+		return left && n.right.eval(env).(bool)
+	}
+	return left || n.right.eval(env).(bool)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n *negation) typ() valueType           { return typeBool }
+func (n *negation) eval(env Env) interface{} { return !n.operand.eval(env).(bool) }
@@ -0,0 +1,161 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+func testEnv() Env {
+	return Env{
+		Payment: &model.Payment{
+			Amount:     150000,
+			Currency:   "ngn",
+			MerchantID: "m1",
+			PaymentMethod: model.PaymentMethod{
+				Type:    "card",
+				Details: model.PaymentMethodDetails{"bin": "539983", "brand": "mastercard"},
+			},
+			Metadata: model.Metadata{"channel": "ussd", "note": `say "hi"`},
+		},
+		BINCountries: model.BINCountries{"5399": "ng", "4": "us"},
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		// Fields
+		{`currency == "NGN"`, true},
+		{`currency == "ngn"`, false},
+		{`amount > 100000 && amount <= 150_000`, true},
+		{`amount >= -1`, true},
+		{`method == "card" && merchant == "m1"`, true},
+		{`card.brand == "mastercard" and card.bin == "539983"`, true},
+		{`card.bin_country == "NG"`, true},
+		{`card.bin_country == "US"`, false},
+		{`metadata.channel == "ussd"`, true},
+		{`metadata["channel"] == 'ussd'`, true},
+		{`metadata.missing == ""`, true},
+		{`metadata.note == "say \"hi\""`, true},
+		{`metadata.note == 'say "hi"'`, true},
+
+		// and binds tighter than or, not tighter than and
+		{`true or false and false`, true},
+		{`false and false or true`, true},
+		{`(true or false) and false`, false},
+		{`not false and false`, false},
+		{`not (false and false)`, true},
+		{`! true || true`, true},
+		{`not currency == "USD"`, true},
+		{`not not true`, true},
+
+		// Lists
+		{`currency in ["NGN", "GHS"]`, true},
+		{`currency in ["USD"]`, false},
+		{`currency not in ["NGN"]`, false},
+		{`currency not in ["USD", "EUR"]`, true},
+		{`currency in []`, false},
+		{`currency not in []`, true},
+		{`amount in [1, 150000]`, true},
+		{`card.bin_country in ["NG", "GH"] || metadata["channel"] == "web"`, true},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		expr, err := Compile(tt.source)
+		if err != nil {
+			t.Errorf("Compile(%s) error = %v", tt.source, err)
+			continue
+		}
+		if got := expr.Eval(env); got != tt.want {
+			t.Errorf("Eval(%s) = %v, want %v", tt.source, got, tt.want)
+		}
+		if expr.String() != tt.source {
+			t.Errorf("String() = %q, want %q", expr.String(), tt.source)
+		}
+	}
+}
+
+func TestExprBINCountry(t *testing.T) {
+	tests := []struct {
+		name    string
+		details model.PaymentMethodDetails
+		want    string
+	}{
+		{"longest prefix", model.PaymentMethodDetails{"bin": "539983"}, "NG"},
+		{"shorter prefix", model.PaymentMethodDetails{"bin": "424242"}, "US"},
+		{"recorded country wins", model.PaymentMethodDetails{"bin": "539983", "bin_country": "gh"}, "GH"},
+		{"unknown bin", model.PaymentMethodDetails{"bin": "999999"}, ""},
+		{"no card", nil, ""},
+	}
+	expr, err := Compile(`card.bin_country == metadata.want`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		env := testEnv()
+		env.Payment.PaymentMethod.Details = tt.details
+		env.Payment.Metadata = model.Metadata{"want": tt.want}
+		if !expr.Eval(env) {
+			t.Errorf("%s: card.bin_country != %q", tt.name, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		// Type errors
+		{`amount`, "expression is a number, not a condition"},
+		{`currency`, "expression is a string, not a condition"},
+		{`currency == 1`, "cannot compare string == number"},
+		{`currency != true`, "cannot compare string != bool"},
+		{`currency > "A"`, "> compares numbers, got string and string"},
+		{`amount in ["a"]`, "cannot look up a number in a list of string"},
+		{`currency in "NGN"`, "in needs a list"},
+		{`currency in ["NGN", 1]`, "list mixes string and number"},
+		{`currency in [method]`, "lists may only hold literals"},
+		{`currency == "NGN" and amount`, "and needs conditions, got a number"},
+		{`amount or true`, "or needs conditions, got a number"},
+		{`not currency`, "not needs conditions, got a string"},
+		{`["a"] == ["a"]`, "cannot compare list == list"},
+		{`country == "NG"`, `unknown field "country" at offset 0`},
+		{`metadata. == ""`, `unknown field "metadata."`},
+
+		// Strings
+		{`"NGN`, "unterminated string at offset 0"},
+		{`currency == "NGN`, "unterminated string at offset 12"},
+		{`currency == 'NGN`, "unterminated string at offset 12"},
+		{`currency == "NGN\"`, "unterminated string at offset 12"},
+		{`currency == "\q"`, "invalid string at offset 12"},
+
+		// Numbers
+		{`amount > 99999999999999999999`, `invalid number "99999999999999999999" at offset 9`},
+		{`amount > -`, "unexpected character '-' at offset 9"},
+		{`amount > 12abc`, `unexpected "abc" at offset 11`},
+
+		// Syntax
+		{`currency == "NGN" )`, `unexpected ")" at offset 18`},
+		{`(currency == "NGN"`, `expected ")", got end of expression`},
+		{`currency not "NGN"`, `unexpected "not" at offset 9`},
+		{`currency @ "NGN"`, "unexpected character '@' at offset 9"},
+		{`metadata[channel] == "x"`, "expected a metadata key string"},
+		{`currency in ["NGN" "GHS"]`, `expected ",", got "GHS"`},
+		{``, "unexpected end of expression at offset 0"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.source)
+		if err == nil {
+			t.Errorf("Compile(%s) succeeded, want error %q", tt.source, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Compile(%s) error = %q, want %q", tt.source, err, tt.wantErr)
+		}
+	}
+}
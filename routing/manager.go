@@ -0,0 +1,346 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrRuleNotFound = errors.New("routing rule not found")
+	ErrRuleExists   = errors.New("routing rule already exists")
+	ErrInvalidRules = errors.New("invalid routing rules")
+	// ErrFileManaged is returned for rule changes while the rules come
+	// from a rules file
+	ErrFileManaged = errors.New("routing rules are managed by the rules file")
+)
+
+// Audit log actions
+const (
+	ActionRuleAdded         = "routing.rule.added"
+	ActionRuleUpdated       = "routing.rule.updated"
+	ActionRuleDeleted       = "routing.rule.deleted"
+	ActionRulesReordered    = "routing.rules.reordered"
+	ActionProcessorEnabled  = "processor.enabled"
+	ActionProcessorDisabled = "processor.disabled"
+)
+
+// Manager changes routing at runtime. Rule sets and processor switches are
+// stored with an audit entry, applied to this instance straight away, and
+// picked up by other instances when they next Sync.
+type Manager struct {
+	repo   repository.RoutingRepository
+	router *engine.ProcessorRouter
+	// fileManaged is set when the rules come from a rules file, which
+	// then stays the only source of rules
+	fileManaged bool
+	logger      *zap.Logger
+
+	// mu serialises changes and syncs on this instance
+	mu      sync.Mutex
+	version int64
+}
+
+func NewManager(repo repository.RoutingRepository, router *engine.ProcessorRouter, fileManaged bool, logger *zap.Logger) *Manager {
+	return &Manager{
+		repo:        repo,
+		router:      router,
+		fileManaged: fileManaged,
+		logger:      logger,
+	}
+}
+
+// LiveRules is the rule set the router is using
+type LiveRules struct {
+	// Source is "file", "database" or "built-in"
+	Source  string       `json:"source"`
+	Version int64        `json:"version,omitempty"`
+	Default string       `json:"default"`
+	Rules   []RuleConfig `json:"rules"`
+}
+
+// Rules reports the router's rules in evaluation order
+func (m *Manager) Rules() *LiveRules {
+	m.mu.Lock()
+	live := &LiveRules{Source: "built-in", Version: m.version}
+	switch {
+	case m.fileManaged:
+		live.Source = "file"
+	case m.version > 0:
+		live.Source = "database"
+	}
+	m.mu.Unlock()
+
+	live.Default = m.router.DefaultProcessor()
+	live.Rules = []RuleConfig{}
+	for _, rule := range m.router.Rules() {
+		cfg := RuleConfig{
+			Name:     rule.Name,
+			Priority: rule.Priority,
+			When:     rule.Expression,
+			SplitBy:  rule.SplitBy,
+			Strategy: rule.Strategy,
+		}
+		if len(rule.Splits) > 0 {
+			for _, split := range rule.Splits {
+				cfg.Splits = append(cfg.Splits, SplitConfig{Processor: split.ProcessorID, Weight: split.Weight})
+			}
+		} else {
+			cfg.Processors = rule.Processors()
+		}
+		live.Rules = append(live.Rules, cfg)
+	}
+	return live
+}
+
+func (m *Manager) AddRule(ctx context.Context, actor string, rule RuleConfig) error {
+	return m.change(ctx, actor, ActionRuleAdded, rule.Name, func(file *File) error {
+		if indexOf(file.Rules, rule.Name) >= 0 {
+			return fmt.Errorf("%w: %q", ErrRuleExists, rule.Name)
+		}
+		file.Rules = append(file.Rules, rule)
+		return nil
+	})
+}
+
+// UpdateRule replaces the named rule. rule may rename it.
+func (m *Manager) UpdateRule(ctx context.Context, actor, name string, rule RuleConfig) error {
+	if rule.Name == "" {
+		rule.Name = name
+	}
+	return m.change(ctx, actor, ActionRuleUpdated, name, func(file *File) error {
+		i := indexOf(file.Rules, name)
+		if i < 0 {
+			return fmt.Errorf("%w: %q", ErrRuleNotFound, name)
+		}
+		if j := indexOf(file.Rules, rule.Name); j >= 0 && j != i {
+			return fmt.Errorf("%w: %q", ErrRuleExists, rule.Name)
+		}
+		file.Rules[i] = rule
+		return nil
+	})
+}
+
+func (m *Manager) DeleteRule(ctx context.Context, actor, name string) error {
+	return m.change(ctx, actor, ActionRuleDeleted, name, func(file *File) error {
+		i := indexOf(file.Rules, name)
+		if i < 0 {
+			return fmt.Errorf("%w: %q", ErrRuleNotFound, name)
+		}
+		file.Rules = append(file.Rules[:i], file.Rules[i+1:]...)
+		return nil
+	})
+}
+
+// ReorderRules sets the evaluation order. names must list every rule; the
+// rules get descending priorities in that order.
+func (m *Manager) ReorderRules(ctx context.Context, actor string, names []string) error {
+	return m.change(ctx, actor, ActionRulesReordered, "rules", func(file *File) error {
+		if len(names) != len(file.Rules) {
+			return fmt.Errorf("%w: reorder must list all %d rules", ErrInvalidRules, len(file.Rules))
+		}
+		ordered := make([]RuleConfig, 0, len(names))
+		for i, name := range names {
+			j := indexOf(file.Rules, name)
+			if j < 0 || indexOf(ordered, name) >= 0 {
+				return fmt.Errorf("%w: %q is unknown or listed twice", ErrInvalidRules, name)
+			}
+			rule := file.Rules[j]
+			rule.Priority = (len(names) - i) * 10
+			ordered = append(ordered, rule)
+		}
+		file.Rules = ordered
+		return nil
+	})
+}
+
+// change applies mutate to the latest stored rule set and stores the
+// result as the next version
+func (m *Manager) change(ctx context.Context, actor, action, target string, mutate func(*File) error) error {
+	if m.fileManaged {
+		return ErrFileManaged
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var file File
+	var version int64
+	latest, err := m.repo.LatestRuleSet(ctx)
+	switch {
+	case errors.Is(err, repository.ErrRuleSetNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to load routing rules: %w", err)
+	default:
+		if err := json.Unmarshal(latest.Config, &file); err != nil {
+			return fmt.Errorf("failed to decode routing rules version %d: %w", latest.Version, err)
+		}
+		version = latest.Version
+	}
+
+	before, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := mutate(&file); err != nil {
+		return err
+	}
+	set, err := file.Compile()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	if err := m.router.ValidateRules(set.Rules, set.Default); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	after, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	next := &model.RoutingRuleSet{Version: version + 1, Config: after, CreatedBy: actor}
+	audit := &model.AuditEntry{Actor: actor, Action: action, Target: target, Before: before, After: after}
+	if err := m.repo.SaveRuleSet(ctx, next, audit); err != nil {
+		return fmt.Errorf("failed to save routing rules: %w", err)
+	}
+
+	if err := m.router.ReplaceRules(set.Rules, set.Default); err != nil {
+		return err
+	}
+	m.version = next.Version
+	m.logger.Info("Routing rules changed",
+		zap.String("actor", actor),
+		zap.String("action", action),
+		zap.String("target", target),
+		zap.Int64("version", next.Version),
+	)
+	return nil
+}
+
+// SetProcessorEnabled switches a processor on or off for new payments
+func (m *Manager) SetProcessorEnabled(ctx context.Context, actor, processorID string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current *engine.ProcessorStatus
+	for _, status := range m.router.ProcessorStatuses() {
+		if status.ID == processorID {
+			current = &status
+			break
+		}
+	}
+	if current == nil {
+		return fmt.Errorf("%w: %q", engine.ErrUnknownProcessor, processorID)
+	}
+
+	action := ActionProcessorDisabled
+	if enabled {
+		action = ActionProcessorEnabled
+	}
+	before, _ := json.Marshal(map[string]bool{"enabled": current.Enabled})
+	after, _ := json.Marshal(map[string]bool{"enabled": enabled})
+	setting := &model.ProcessorSetting{ProcessorID: processorID, Enabled: enabled, UpdatedBy: actor}
+	audit := &model.AuditEntry{Actor: actor, Action: action, Target: processorID, Before: before, After: after}
+	if err := m.repo.SaveProcessorSetting(ctx, setting, audit); err != nil {
+		return fmt.Errorf("failed to save processor setting: %w", err)
+	}
+
+	if err := m.router.SetProcessorEnabled(processorID, enabled); err != nil {
+		return err
+	}
+	m.logger.Info("Processor switched",
+		zap.String("actor", actor),
+		zap.String("processor", processorID),
+		zap.Bool("enabled", enabled),
+	)
+	return nil
+}
+
+// AuditLog returns the latest admin changes, newest first
+func (m *Manager) AuditLog(ctx context.Context, limit int) ([]*model.AuditEntry, error) {
+	return m.repo.AuditLog(ctx, limit)
+}
+
+// Sync applies the stored processor switches and, unless a rules file is
+// in charge, the latest stored rule set
+func (m *Manager) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	settings, err := m.repo.ProcessorSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load processor settings: %w", err)
+	}
+	for _, setting := range settings {
+		err := m.router.SetProcessorEnabled(setting.ProcessorID, setting.Enabled)
+		if errors.Is(err, engine.ErrUnknownProcessor) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.fileManaged {
+		return nil
+	}
+	latest, err := m.repo.LatestRuleSet(ctx)
+	if errors.Is(err, repository.ErrRuleSetNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load routing rules: %w", err)
+	}
+	if latest.Version <= m.version {
+		return nil
+	}
+
+	// A version that does not apply is skipped until the next change
+	m.version = latest.Version
+	var file File
+	if err := json.Unmarshal(latest.Config, &file); err != nil {
+		return fmt.Errorf("failed to decode routing rules version %d: %w", latest.Version, err)
+	}
+	set, err := file.Compile()
+	if err != nil {
+		return fmt.Errorf("routing rules version %d: %w", latest.Version, err)
+	}
+	if err := m.router.ReplaceRules(set.Rules, set.Default); err != nil {
+		return fmt.Errorf("routing rules version %d: %w", latest.Version, err)
+	}
+	m.logger.Info("Routing rules synced", zap.Int64("version", latest.Version), zap.Int("rules", len(set.Rules)))
+	return nil
+}
+
+// Run syncs every interval until ctx is done
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Sync(ctx); err != nil {
+				m.logger.Error("Failed to sync routing configuration", zap.Error(err))
+			}
+		}
+	}
+}
+
+func indexOf(rules []RuleConfig, name string) int {
+	for i, rule := range rules {
+		if rule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,170 @@
+// Package routing loads declarative routing rules from a YAML or JSON file
+// and keeps the processor router in step with it.
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"github.com/thoraf20/payment-processor/model"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the rules file format:
+//
+//	default: stripe
+//	bin_countries:
+//	  "506099": NG
+//	rules:
+//	  - name: ngn-local
+//	    priority: 100
+//	    when: currency == "NGN"
+//	    processors: [flutterwave, paystack]
+//	  - name: large-usd
+//	    priority: 50
+//	    when: currency == "USD" && amount > 50000
+//	    processor: stripe
+//	  - name: ghs-card-trial
+//	    priority: 40
+//	    when: currency == "GHS" && method == "card"
+//	    split_by: customer
+//	    splits:
+//	      - {processor: paystack, weight: 90}
+//	      - {processor: flutterwave, weight: 10}
+//	  - name: ngn-best
+//	    priority: 30
+//	    when: currency == "NGN"
+//	    strategy: scored
+//	    processors: [paystack, flutterwave]
+type File struct {
+	// Default is tried when no rule matches; empty keeps the router's
+	Default string `json:"default" yaml:"default"`
+	// BINCountries maps BIN prefixes to the issuing country for
+	// card.bin_country. The longest matching prefix wins.
+	BINCountries model.BINCountries `json:"bin_countries" yaml:"bin_countries"`
+	Rules        []RuleConfig       `json:"rules" yaml:"rules"`
+}
+
+// RuleConfig is one rule as written in the file
+type RuleConfig struct {
+	Name      string `json:"name" yaml:"name"`
+	Priority  int    `json:"priority" yaml:"priority"`
+	When      string `json:"when" yaml:"when"`
+	Processor string `json:"processor,omitempty" yaml:"processor,omitempty"`
+	// Processors lists processors in cascade order, instead of Processor
+	Processors []string `json:"processors,omitempty" yaml:"processors,omitempty"`
+	// Splits divide the rule's payments by weight, instead of Processor(s)
+	Splits  []SplitConfig `json:"splits,omitempty" yaml:"splits,omitempty"`
+	SplitBy string        `json:"split_by,omitempty" yaml:"split_by,omitempty"`
+	// Strategy is "ordered" (the default), "scored" or "cheapest"
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+}
+
+type SplitConfig struct {
+	Processor string `json:"processor" yaml:"processor"`
+	Weight    int    `json:"weight" yaml:"weight"`
+}
+
+// RuleSet is a validated rules file, ready for the router
+type RuleSet = engine.RuleSet
+
+// LoadFile reads and compiles a rules file. Files ending in .json are
+// parsed as JSON, anything else as YAML.
+func LoadFile(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules: %w", err)
+	}
+
+	var file File
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse routing rules %s: %w", path, err)
+	}
+
+	set, err := file.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing rules %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// Compile checks every rule and turns its expression into a condition.
+// Processor IDs are checked by the router when the rules are applied.
+func (f *File) Compile() (*RuleSet, error) {
+	set := &RuleSet{Default: f.Default}
+	bins := f.BINCountries
+	var errs []error
+
+	seen := make(map[string]bool)
+	for i, cfg := range f.Rules {
+		name := cfg.Name
+		if name == "" {
+			errs = append(errs, fmt.Errorf("rule %d: name is required", i+1))
+			name = fmt.Sprintf("#%d", i+1)
+		} else if seen[name] {
+			errs = append(errs, fmt.Errorf("rule %q: duplicate name", name))
+		}
+		seen[name] = true
+
+		processors := cfg.Processors
+		if cfg.Processor != "" {
+			if len(processors) > 0 {
+				errs = append(errs, fmt.Errorf("rule %q: set processor or processors, not both", name))
+			}
+			processors = []string{cfg.Processor}
+		}
+		var splits []engine.Split
+		for _, split := range cfg.Splits {
+			splits = append(splits, engine.Split{ProcessorID: split.Processor, Weight: split.Weight})
+		}
+		if len(splits) > 0 && len(processors) > 0 {
+			errs = append(errs, fmt.Errorf("rule %q: set splits or processors, not both", name))
+		}
+		if len(splits) == 0 && len(processors) == 0 {
+			errs = append(errs, fmt.Errorf("rule %q: no processors", name))
+		}
+
+		if strings.TrimSpace(cfg.When) == "" {
+			errs = append(errs, fmt.Errorf("rule %q: when is required, use \"true\" to match everything", name))
+			continue
+		}
+		expr, err := Compile(cfg.When)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", name, err))
+			continue
+		}
+
+		set.Rules = append(set.Rules, engine.RoutingRule{
+			Name: name,
+			Condition: func(p *model.Payment) bool {
+				return expr.Eval(Env{Payment: p, BINCountries: bins})
+			},
+			Expression:   cfg.When,
+			ProcessorIDs: processors,
+			Splits:       splits,
+			SplitBy:      cfg.SplitBy,
+			Strategy:     cfg.Strategy,
+			Priority:     cfg.Priority,
+		})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/thoraf20/payment-processor/engine"
+	"go.uber.org/zap"
+)
+
+// Watcher reloads the rules file into the router whenever it changes or
+// the process receives SIGHUP. A file that fails to load or validate is
+// logged and the router keeps its current rules.
+type Watcher struct {
+	path     string
+	router   *engine.ProcessorRouter
+	interval time.Duration
+	logger   *zap.Logger
+
+	modTime time.Time
+	size    int64
+}
+
+func NewWatcher(path string, router *engine.ProcessorRouter, interval time.Duration, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		path:     path,
+		router:   router,
+		interval: interval,
+		logger:   logger.With(zap.String("rules_file", path)),
+	}
+}
+
+// Load applies the rules file to the router
+func (w *Watcher) Load() error {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+
+	set, err := LoadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if err := w.router.ReplaceRules(set.Rules, set.Default); err != nil {
+		return err
+	}
+
+	w.modTime, w.size = info.ModTime(), info.Size()
+	w.logger.Info("Routing rules loaded", zap.Int("rules", len(set.Rules)))
+	return nil
+}
+
+// Run polls the file for changes and listens for SIGHUP until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.reload("signal")
+		case <-tick:
+			info, err := os.Stat(w.path)
+			if err != nil {
+				w.logger.Warn("Failed to stat routing rules", zap.Error(err))
+				continue
+			}
+			if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+				continue
+			}
+			w.reload("file changed")
+		}
+	}
+}
+
+func (w *Watcher) reload(reason string) {
+	if err := w.Load(); err != nil {
+		w.logger.Error("Failed to reload routing rules, keeping the current rules",
+			zap.String("reason", reason),
+			zap.Error(err),
+		)
+		// Don't retry the same broken file on every tick
+		if info, statErr := os.Stat(w.path); statErr == nil {
+			w.modTime, w.size = info.ModTime(), info.Size()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"context"
+	"sync"
+)
+
+type sessionKey struct{}
+
+// session keeps detokenized cards for the length of one authorization
+type session struct {
+	mu    sync.Mutex
+	cards map[string]Card
+}
+
+// WithSession scopes detokenized cards to ctx: a card detokenized once,
+// CVV included, is handed out again to every processor an authorization
+// cascades to. release drops the cards and must be called when the
+// authorization is over.
+func WithSession(ctx context.Context) (context.Context, func()) {
+	s := &session{cards: make(map[string]Card)}
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cards = nil
+	}
+	return context.WithValue(ctx, sessionKey{}, s), release
+}
+
+// SessionCard returns a copy of the card kept for token in ctx's session
+func SessionCard(ctx context.Context, token string) (*Card, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	card, ok := s.cards[token]
+	if !ok {
+		return nil, false
+	}
+	return &card, true
+}
+
+// KeepForSession keeps card for the rest of ctx's session, if it has one
+func KeepForSession(ctx context.Context, token string, card *Card) {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cards != nil {
+		s.cards[token] = *card
+	}
+}
@@ -0,0 +1,436 @@
+// Package vault tokenizes card data so that raw card numbers never travel
+// beyond the API layer. Numbers are encrypted with a per-card data key that
+// is itself wrapped by the key-encryption key (KEK) from config. CVVs are
+// never written anywhere; they live in memory until the single
+// authorization that needs them. Reusable authorizations that providers
+// issue for a vaulted card are sealed the same way.
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrTokenNotFound         = errors.New("card token not found")
+	ErrInvalidCard           = errors.New("invalid card")
+	ErrAuthorizationNotFound = errors.New("authorization not found")
+)
+
+// cvvTTL bounds how long a CVV is held waiting for its authorization
+const cvvTTL = 10 * time.Minute
+
+// Card is raw card data. It only exists in memory.
+type Card struct {
+	Number   string
+	CVV      string
+	ExpMonth string
+	ExpYear  string
+}
+
+// Token is the opaque reference handed out in place of the card. It is
+// bound to the merchant that created it.
+type Token struct {
+	Token       string    `json:"token"`
+	MerchantID  string    `json:"merchant_id"`
+	Bin         string    `json:"bin"`
+	Last4       string    `json:"last4"`
+	Brand       string    `json:"brand"`
+	ExpMonth    string    `json:"exp_month"`
+	ExpYear     string    `json:"exp_year"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type heldCVV struct {
+	cvv     string
+	expires time.Time
+}
+
+type Vault struct {
+	db             *sql.DB
+	kek            cipher.AEAD
+	fingerprintKey []byte
+	logger         *zap.Logger
+
+	mu   sync.Mutex
+	cvvs map[string]heldCVV
+}
+
+// New creates a vault. kek is the base64 encoding of a 32 byte AES-256 key.
+func New(db *sql.DB, kek string, logger *zap.Logger) (*Vault, error) {
+	key, err := base64.StdEncoding.DecodeString(kek)
+	if err != nil {
+		return nil, fmt.Errorf("vault key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("vault key must be 32 bytes, got %d", len(key))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fingerprints use a key derived from the KEK so they cannot be
+	// brute-forced from the PAN space without it
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("card-fingerprint"))
+
+	return &Vault{
+		db:             db,
+		kek:            aead,
+		fingerprintKey: mac.Sum(nil),
+		logger:         logger,
+		cvvs:           make(map[string]heldCVV),
+	}, nil
+}
+
+// Tokenize stores the card for merchantID and returns its token. The CVV is
+// held in memory only, for the next Detokenize call.
+func (v *Vault) Tokenize(ctx context.Context, merchantID string, card Card) (*Token, error) {
+	number := strings.ReplaceAll(card.Number, " ", "")
+	if err := validateCard(number, card.ExpMonth, card.ExpYear, time.Now()); err != nil {
+		return nil, err
+	}
+
+	tokenID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		Token:       "tok_" + tokenID,
+		MerchantID:  merchantID,
+		Bin:         number[:6],
+		Last4:       number[len(number)-4:],
+		Brand:       Brand(number),
+		ExpMonth:    card.ExpMonth,
+		ExpYear:     card.ExpYear,
+		Fingerprint: v.fingerprint(number),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	encryptedPAN, wrappedKey, err := v.encrypt(token.Token, number)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO vault_cards (token, merchant_id, encrypted_pan, wrapped_key, bin, last4, brand, exp_month, exp_year, fingerprint, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err = v.db.ExecContext(ctx, query,
+		token.Token,
+		token.MerchantID,
+		encryptedPAN,
+		wrappedKey,
+		token.Bin,
+		token.Last4,
+		token.Brand,
+		token.ExpMonth,
+		token.ExpYear,
+		token.Fingerprint,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store card: %w", err)
+	}
+
+	if card.CVV != "" {
+		v.holdCVV(token.Token, card.CVV)
+	}
+
+	return token, nil
+}
+
+// Lookup returns the stored attributes of a token created by merchantID.
+// Tokens of other merchants are reported as not found.
+func (v *Vault) Lookup(ctx context.Context, merchantID, token string) (*Token, error) {
+	query := `SELECT token, merchant_id, bin, last4, brand, exp_month, exp_year, fingerprint, created_at
+	          FROM vault_cards WHERE token = $1 AND merchant_id = $2`
+
+	t := &Token{}
+	err := v.db.QueryRowContext(ctx, query, token, merchantID).Scan(
+		&t.Token,
+		&t.MerchantID,
+		&t.Bin,
+		&t.Last4,
+		&t.Brand,
+		&t.ExpMonth,
+		&t.ExpYear,
+		&t.Fingerprint,
+		&t.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Detokenize returns the card behind a token for immediate use by a
+// processor. The CVV is handed out at most once and only while still held.
+func (v *Vault) Detokenize(ctx context.Context, token string) (*Card, error) {
+	query := `SELECT encrypted_pan, wrapped_key, exp_month, exp_year FROM vault_cards WHERE token = $1`
+
+	var encryptedPAN, wrappedKey []byte
+	card := &Card{}
+	err := v.db.QueryRowContext(ctx, query, token).Scan(&encryptedPAN, &wrappedKey, &card.ExpMonth, &card.ExpYear)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := v.decrypt(token, encryptedPAN, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt card: %w", err)
+	}
+
+	card.Number = number
+	card.CVV = v.takeCVV(token)
+	return card, nil
+}
+
+// SaveAuthorization keeps a reusable authorization code that processor
+// issued for the card behind token, bound to customer. A newer code for
+// the same card and customer replaces the old one.
+func (v *Vault) SaveAuthorization(ctx context.Context, token, processor, customer, code string) error {
+	encryptedCode, wrappedKey, err := v.encrypt(authorizationAAD(token, processor, customer), code)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO vault_authorizations (token, processor, customer, encrypted_code, wrapped_key, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6)
+	          ON CONFLICT (token, processor, customer)
+	          DO UPDATE SET encrypted_code = EXCLUDED.encrypted_code, wrapped_key = EXCLUDED.wrapped_key, created_at = EXCLUDED.created_at`
+
+	_, err = v.db.ExecContext(ctx, query, token, processor, customer, encryptedCode, wrappedKey, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store authorization: %w", err)
+	}
+	return nil
+}
+
+// Authorization returns the code processor issued for the card behind
+// token to customer. Codes issued to other customers are not found.
+func (v *Vault) Authorization(ctx context.Context, token, processor, customer string) (string, error) {
+	query := `SELECT encrypted_code, wrapped_key FROM vault_authorizations WHERE token = $1 AND processor = $2 AND customer = $3`
+
+	var encryptedCode, wrappedKey []byte
+	err := v.db.QueryRowContext(ctx, query, token, processor, customer).Scan(&encryptedCode, &wrappedKey)
+	if err == sql.ErrNoRows {
+		return "", ErrAuthorizationNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	code, err := v.decrypt(authorizationAAD(token, processor, customer), encryptedCode, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt authorization: %w", err)
+	}
+	return code, nil
+}
+
+// authorizationAAD binds a sealed authorization to its row
+func authorizationAAD(token, processor, customer string) string {
+	return token + "\x00" + processor + "\x00" + customer
+}
+
+// encrypt seals plaintext under a fresh data key and wraps that key with
+// the KEK. The row's identity is bound in as associated data so rows cannot
+// be swapped.
+func (v *Vault) encrypt(associatedData, plaintext string) (sealed, wrappedKey []byte, err error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed, err = seal(dek, []byte(plaintext), []byte(associatedData))
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedKey, err = seal(v.kek, dataKey, []byte(associatedData))
+	if err != nil {
+		return nil, nil, err
+	}
+	return sealed, wrappedKey, nil
+}
+
+func (v *Vault) decrypt(associatedData string, sealed, wrappedKey []byte) (string, error) {
+	dataKey, err := open(v.kek, wrappedKey, []byte(associatedData))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, sealed, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// validateCard checks the number's length and Luhn check digit, and that
+// the card has not expired by now. Cards are valid through the last day of
+// their expiry month.
+func validateCard(number, expMonth, expYear string, now time.Time) error {
+	if len(number) < 12 || len(number) > 19 || strings.Trim(number, "0123456789") != "" {
+		return fmt.Errorf("%w: card number must be 12-19 digits", ErrInvalidCard)
+	}
+	if !luhnValid(number) {
+		return fmt.Errorf("%w: card number is not valid", ErrInvalidCard)
+	}
+
+	month, err := strconv.Atoi(expMonth)
+	if err != nil || month < 1 || month > 12 {
+		return fmt.Errorf("%w: expiry month must be 1-12", ErrInvalidCard)
+	}
+	year, err := strconv.Atoi(expYear)
+	if err != nil || (len(expYear) != 2 && len(expYear) != 4) {
+		return fmt.Errorf("%w: expiry year must be 2 or 4 digits", ErrInvalidCard)
+	}
+	if len(expYear) == 2 {
+		year += 2000
+	}
+	if !now.Before(time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)) {
+		return fmt.Errorf("%w: card has expired", ErrInvalidCard)
+	}
+	return nil
+}
+
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func (v *Vault) holdCVV(token, cvv string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	for t, held := range v.cvvs {
+		if now.After(held.expires) {
+			delete(v.cvvs, t)
+		}
+	}
+	v.cvvs[token] = heldCVV{cvv: cvv, expires: now.Add(cvvTTL)}
+}
+
+func (v *Vault) takeCVV(token string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	held, ok := v.cvvs[token]
+	delete(v.cvvs, token)
+	if !ok || time.Now().After(held.expires) {
+		return ""
+	}
+	return held.cvv
+}
+
+func (v *Vault) fingerprint(number string) string {
+	mac := hmac.New(sha256.New, v.fingerprintKey)
+	mac.Write([]byte(number))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Brand guesses the card network from the number's prefix
+func Brand(number string) string {
+	switch {
+	case strings.HasPrefix(number, "4"):
+		return "visa"
+	case hasPrefixInRange(number, 2, 51, 55), hasPrefixInRange(number, 4, 2221, 2720):
+		return "mastercard"
+	case strings.HasPrefix(number, "34"), strings.HasPrefix(number, "37"):
+		return "amex"
+	case hasPrefixInRange(number, 6, 506099, 506198), hasPrefixInRange(number, 6, 650002, 650027):
+		return "verve"
+	case strings.HasPrefix(number, "6011"), strings.HasPrefix(number, "65"):
+		return "discover"
+	default:
+		return "unknown"
+	}
+}
+
+func hasPrefixInRange(number string, digits, low, high int) bool {
+	if len(number) < digits {
+		return false
+	}
+	prefix, err := strconv.Atoi(number[:digits])
+	if err != nil {
+		return false
+	}
+	return prefix >= low && prefix <= high
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext and prefixes the random nonce
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
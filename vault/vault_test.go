@@ -0,0 +1,313 @@
+package vault
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryDB keeps vault_cards rows in memory, by token, and
+// vault_authorizations rows by token, processor and customer
+type memoryDB struct {
+	mu             sync.Mutex
+	rows           map[string][]driver.Value
+	authorizations map[string][]driver.Value
+}
+
+func newTestVault(t *testing.T) (*Vault, *memoryDB) {
+	t.Helper()
+	db := &memoryDB{rows: make(map[string][]driver.Value), authorizations: make(map[string][]driver.Value)}
+	kek := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	v, err := New(sql.OpenDB(db), kek, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, db
+}
+
+func (db *memoryDB) Connect(ctx context.Context) (driver.Conn, error) { return memoryConn{db}, nil }
+func (db *memoryDB) Driver() driver.Driver                            { return nil }
+
+type memoryConn struct{ db *memoryDB }
+
+func (c memoryConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("unexpected prepare: %s", query)
+}
+func (c memoryConn) Close() error              { return nil }
+func (c memoryConn) Begin() (driver.Tx, error) { return nil, errors.New("unexpected transaction") }
+
+// vault_cards columns in INSERT order
+var cardColumns = []string{"token", "merchant_id", "encrypted_pan", "wrapped_key", "bin", "last4", "brand", "exp_month", "exp_year", "fingerprint", "created_at"}
+
+func authorizationKey(args []driver.NamedValue) string {
+	return fmt.Sprint(args[0].Value, "/", args[1].Value, "/", args[2].Value)
+}
+
+func (c memoryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	row := make([]driver.Value, len(args))
+	for i, arg := range args {
+		row[i] = arg.Value
+	}
+
+	switch {
+	case strings.Contains(query, "INSERT INTO vault_cards"):
+		c.db.rows[row[0].(string)] = row
+	case strings.Contains(query, "INSERT INTO vault_authorizations"):
+		c.db.authorizations[authorizationKey(args)] = row
+	default:
+		return nil, fmt.Errorf("unexpected exec: %s", query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c memoryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	// The selected columns are read from the query itself
+	selected := strings.TrimSpace(query[len("SELECT"):strings.Index(query, "FROM")])
+	columns := strings.Split(selected, ", ")
+	rows := &memoryRows{columns: columns}
+
+	if strings.Contains(query, "FROM vault_authorizations") {
+		if row, ok := c.db.authorizations[authorizationKey(args)]; ok {
+			rows.values = [][]driver.Value{{row[3], row[4]}}
+		}
+		return rows, nil
+	}
+
+	row, ok := c.db.rows[args[0].Value.(string)]
+	if !ok || (len(args) > 1 && row[1] != args[1].Value) {
+		return rows, nil
+	}
+	values := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		for j, name := range cardColumns {
+			if name == column {
+				values[i] = row[j]
+			}
+		}
+	}
+	rows.values = [][]driver.Value{values}
+	return rows, nil
+}
+
+type memoryRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *memoryRows) Columns() []string { return r.columns }
+func (r *memoryRows) Close() error      { return nil }
+
+func (r *memoryRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func testCard() Card {
+	return Card{Number: "4242 4242 4242 4242", CVV: "123", ExpMonth: "12", ExpYear: "2099"}
+}
+
+func TestTokenizeDetokenizeRoundTrip(t *testing.T) {
+	v, db := newTestVault(t)
+	ctx := context.Background()
+
+	token, err := v.Tokenize(ctx, "m1", testCard())
+	if err != nil {
+		t.Fatalf("Tokenize() error = %v", err)
+	}
+	if token.Bin != "424242" || token.Last4 != "4242" || token.Brand != "visa" || token.MerchantID != "m1" {
+		t.Errorf("token = %+v", token)
+	}
+
+	stored := db.rows[token.Token]
+	if strings.Contains(string(stored[2].([]byte)), "4242424242424242") {
+		t.Fatal("card number stored in the clear")
+	}
+
+	card, err := v.Detokenize(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("Detokenize() error = %v", err)
+	}
+	if card.Number != "4242424242424242" || card.ExpMonth != "12" || card.ExpYear != "2099" {
+		t.Errorf("card = %+v", card)
+	}
+}
+
+func TestDetokenizeRejectsSwappedRows(t *testing.T) {
+	v, db := newTestVault(t)
+	ctx := context.Background()
+
+	first, err := v.Tokenize(ctx, "m1", testCard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testCard()
+	other.Number = "5555555555554444"
+	second, err := v.Tokenize(ctx, "m1", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each ciphertext is bound to its token as associated data
+	tests := []struct {
+		name   string
+		column int
+	}{
+		{"encrypted pan", 2},
+		{"wrapped key", 3},
+	}
+	for _, tt := range tests {
+		db.rows[first.Token][tt.column], db.rows[second.Token][tt.column] = db.rows[second.Token][tt.column], db.rows[first.Token][tt.column]
+		if _, err := v.Detokenize(ctx, first.Token); err == nil {
+			t.Errorf("%s: Detokenize() accepted another token's ciphertext", tt.name)
+		}
+		db.rows[first.Token][tt.column], db.rows[second.Token][tt.column] = db.rows[second.Token][tt.column], db.rows[first.Token][tt.column]
+	}
+}
+
+func TestDetokenizeHandsOutCVVOnce(t *testing.T) {
+	v, _ := newTestVault(t)
+	ctx := context.Background()
+
+	token, err := v.Tokenize(ctx, "m1", testCard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"123", ""} {
+		card, err := v.Detokenize(ctx, token.Token)
+		if err != nil {
+			t.Fatalf("Detokenize() #%d error = %v", i+1, err)
+		}
+		if card.CVV != want {
+			t.Errorf("Detokenize() #%d CVV = %q, want %q", i+1, card.CVV, want)
+		}
+	}
+}
+
+func TestExpiredCVVIsNotHandedOut(t *testing.T) {
+	v, _ := newTestVault(t)
+
+	v.cvvs["tok_1"] = heldCVV{cvv: "123", expires: time.Now().Add(-time.Second)}
+	if cvv := v.takeCVV("tok_1"); cvv != "" {
+		t.Errorf("takeCVV() = %q after expiry, want none", cvv)
+	}
+}
+
+func TestLookupIsBoundToMerchant(t *testing.T) {
+	v, _ := newTestVault(t)
+	ctx := context.Background()
+
+	token, err := v.Tokenize(ctx, "m1", testCard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := v.Lookup(ctx, "m1", token.Token)
+	if err != nil {
+		t.Fatalf("Lookup(owner) error = %v", err)
+	}
+	if found.Token != token.Token || found.Bin != "424242" || found.Fingerprint != token.Fingerprint {
+		t.Errorf("Lookup(owner) = %+v, want %+v", found, token)
+	}
+	if _, err := v.Lookup(ctx, "m2", token.Token); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Lookup(other merchant) error = %v, want %v", err, ErrTokenNotFound)
+	}
+	if _, err := v.Lookup(ctx, "m1", "tok_missing"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Lookup(missing) error = %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestAuthorizationIsBoundToCustomer(t *testing.T) {
+	v, db := newTestVault(t)
+	ctx := context.Background()
+
+	if err := v.SaveAuthorization(ctx, "tok_1", "paystack", "a@example.com", "AUTH_1"); err != nil {
+		t.Fatalf("SaveAuthorization() error = %v", err)
+	}
+	for _, row := range db.authorizations {
+		if strings.Contains(string(row[3].([]byte)), "AUTH_1") {
+			t.Fatal("authorization code stored in the clear")
+		}
+	}
+
+	code, err := v.Authorization(ctx, "tok_1", "paystack", "a@example.com")
+	if err != nil || code != "AUTH_1" {
+		t.Fatalf("Authorization() = %q, %v, want AUTH_1", code, err)
+	}
+	if _, err := v.Authorization(ctx, "tok_1", "paystack", "b@example.com"); !errors.Is(err, ErrAuthorizationNotFound) {
+		t.Errorf("Authorization(other customer) error = %v, want %v", err, ErrAuthorizationNotFound)
+	}
+
+	// A sealed code moved to another customer's row does not open
+	db.authorizations["tok_1/paystack/b@example.com"] = db.authorizations["tok_1/paystack/a@example.com"]
+	if _, err := v.Authorization(ctx, "tok_1", "paystack", "b@example.com"); err == nil {
+		t.Error("Authorization() opened a code sealed for another customer")
+	}
+}
+
+func TestTokenizeRejectsInvalidCards(t *testing.T) {
+	tests := []struct {
+		name string
+		card Card
+	}{
+		{"too short", Card{Number: "42424242", ExpMonth: "12", ExpYear: "2099"}},
+		{"too long", Card{Number: "42424242424242424242", ExpMonth: "12", ExpYear: "2099"}},
+		{"not digits", Card{Number: "4242-4242-4242-4242", ExpMonth: "12", ExpYear: "2099"}},
+		{"luhn", Card{Number: "4242424242424241", ExpMonth: "12", ExpYear: "2099"}},
+		{"month zero", Card{Number: "4242424242424242", ExpMonth: "0", ExpYear: "2099"}},
+		{"month 13", Card{Number: "4242424242424242", ExpMonth: "13", ExpYear: "2099"}},
+		{"missing expiry", Card{Number: "4242424242424242"}},
+		{"three digit year", Card{Number: "4242424242424242", ExpMonth: "12", ExpYear: "209"}},
+		{"expired", Card{Number: "4242424242424242", ExpMonth: "1", ExpYear: "2020"}},
+		{"expired short year", Card{Number: "4242424242424242", ExpMonth: "1", ExpYear: "20"}},
+	}
+
+	v, db := newTestVault(t)
+	for _, tt := range tests {
+		if _, err := v.Tokenize(context.Background(), "m1", tt.card); !errors.Is(err, ErrInvalidCard) {
+			t.Errorf("%s: Tokenize() error = %v, want %v", tt.name, err, ErrInvalidCard)
+		}
+	}
+	if len(db.rows) != 0 {
+		t.Errorf("%d invalid cards stored", len(db.rows))
+	}
+}
+
+func TestValidateCardExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		month, year string
+		valid       bool
+	}{
+		{"10", "2026", true}, // valid through the end of the month
+		{"10", "26", true},
+		{"11", "2026", true},
+		{"9", "2026", false},
+		{"12", "2025", false},
+	}
+	for _, tt := range tests {
+		err := validateCard("4242424242424242", tt.month, tt.year, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("expiry %s/%s: error = %v, want valid %v", tt.month, tt.year, err, tt.valid)
+		}
+	}
+}
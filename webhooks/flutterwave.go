@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// FlutterwaveVerifier compares the verif-hash header with the secret hash
+// configured on the Flutterwave dashboard
+type FlutterwaveVerifier struct {
+	secretHash string
+}
+
+func NewFlutterwaveVerifier(secretHash string) *FlutterwaveVerifier {
+	return &FlutterwaveVerifier{secretHash: secretHash}
+}
+
+type flutterwaveEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		ID     int64  `json:"id"`
+		TxRef  string `json:"tx_ref"`
+		Status string `json:"status"`
+	} `json:"data"`
+}
+
+func (v *FlutterwaveVerifier) Verify(header http.Header, body []byte) (*model.ProviderEvent, error) {
+	hash := header.Get("verif-hash")
+	if hash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(v.secretHash)) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	var event flutterwaveEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode flutterwave event: %w", err)
+	}
+
+	var status model.PaymentStatus
+	if event.Event == "charge.completed" {
+		switch event.Data.Status {
+		case "successful":
+			status = model.StatusCompleted
+		case "failed":
+			status = model.StatusFailed
+		}
+	}
+
+	// Flutterwave sends no event ID; a transaction only reaches each
+	// status once, so the pair identifies the notification
+	return &model.ProviderEvent{
+		EventID:   fmt.Sprintf("%s:%d:%s", event.Event, event.Data.ID, event.Data.Status),
+		Type:      event.Event,
+		Reference: event.Data.TxRef,
+		Status:    status,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+const flutterwaveCompleted = `{"event":"charge.completed","data":{"id":7,"tx_ref":"pay_1","status":"successful"}}`
+
+func TestFlutterwaveVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		hash  string
+		valid bool
+	}{
+		{"valid", "secret-hash", true},
+		{"other hash", "secret-hasi", false},
+		{"prefix of the hash", "secret", false},
+		{"missing header", "", false},
+	}
+
+	v := NewFlutterwaveVerifier("secret-hash")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.hash != "" {
+				header.Set("verif-hash", tt.hash)
+			}
+			event, err := v.Verify(header, []byte(flutterwaveCompleted))
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("Verify() error = %v, want %v", err, ErrInvalidSignature)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if event.EventID != "charge.completed:7:successful" || event.Reference != "pay_1" || event.Status != model.StatusCompleted {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestFlutterwaveVerifyWithoutSecret(t *testing.T) {
+	// An unset secret must not accept requests without the header
+	v := NewFlutterwaveVerifier("")
+	if _, err := v.Verify(http.Header{}, []byte(flutterwaveCompleted)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// PaystackVerifier checks x-paystack-signature, an HMAC-SHA512 of the body
+// under the account's secret key
+type PaystackVerifier struct {
+	secretKey string
+}
+
+func NewPaystackVerifier(secretKey string) *PaystackVerifier {
+	return &PaystackVerifier{secretKey: secretKey}
+}
+
+type paystackEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		ID          int64  `json:"id"`
+		Reference   string `json:"reference"`
+		Status      string `json:"status"`
+		Transaction struct {
+			Reference string `json:"reference"`
+		} `json:"transaction"`
+	} `json:"data"`
+}
+
+var paystackStatuses = map[string]model.PaymentStatus{
+	"charge.success":        model.StatusCompleted,
+	"charge.failed":         model.StatusFailed,
+	"charge.dispute.create": model.StatusDisputed,
+}
+
+func (v *PaystackVerifier) Verify(header http.Header, body []byte) (*model.ProviderEvent, error) {
+	signature, err := hex.DecodeString(header.Get("x-paystack-signature"))
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha512.New, []byte(v.secretKey))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event paystackEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode paystack event: %w", err)
+	}
+
+	// Disputes reference the disputed transaction
+	reference := event.Data.Reference
+	if reference == "" {
+		reference = event.Data.Transaction.Reference
+	}
+
+	return &model.ProviderEvent{
+		EventID:   fmt.Sprintf("%s:%d", event.Event, event.Data.ID),
+		Type:      event.Event,
+		Reference: reference,
+		Status:    paystackStatuses[event.Event],
+	}, nil
+}
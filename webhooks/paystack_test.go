@@ -0,0 +1,58 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+const paystackSuccess = `{"event":"charge.success","data":{"id":42,"reference":"pst-pay_1","status":"success"}}`
+
+func paystackSignature(key, body string) string {
+	mac := hmac.New(sha512.New, []byte(key))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPaystackVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{"valid", paystackSignature("sk_test", paystackSuccess), paystackSuccess, true},
+		{"tampered body", paystackSignature("sk_test", paystackSuccess), paystackSuccess + " ", false},
+		{"other key", paystackSignature("sk_other", paystackSuccess), paystackSuccess, false},
+		{"not hex", "zz", paystackSuccess, false},
+		{"missing header", "", paystackSuccess, false},
+	}
+
+	v := NewPaystackVerifier("sk_test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.signature != "" {
+				header.Set("x-paystack-signature", tt.signature)
+			}
+			event, err := v.Verify(header, []byte(tt.body))
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("Verify() error = %v, want %v", err, ErrInvalidSignature)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if event.EventID != "charge.success:42" || event.Reference != "pst-pay_1" || event.Status != model.StatusCompleted {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
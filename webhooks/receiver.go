@@ -0,0 +1,107 @@
+// Package webhooks receives payment provider notifications. Each provider's
+// Verifier authenticates the request and normalises it into a
+// model.ProviderEvent, which is stored before being applied to the payment.
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"github.com/thoraf20/payment-processor/repository"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown webhook provider")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// claimLease is how long a delivery may spend applying an event before a
+// redelivery assumes it crashed and takes the event over
+const claimLease = 5 * time.Minute
+
+// Verifier authenticates a provider's webhook and parses its body
+type Verifier interface {
+	Verify(header http.Header, body []byte) (*model.ProviderEvent, error)
+}
+
+// EventApplier moves payments according to provider events
+type EventApplier interface {
+	ApplyProviderEvent(ctx context.Context, event *model.ProviderEvent) error
+}
+
+type Receiver struct {
+	mu        sync.RWMutex
+	verifiers map[string]Verifier
+	events    repository.ProviderEventRepository
+	applier   EventApplier
+	logger    *zap.Logger
+}
+
+func NewReceiver(events repository.ProviderEventRepository, applier EventApplier, logger *zap.Logger) *Receiver {
+	return &Receiver{
+		verifiers: make(map[string]Verifier),
+		events:    events,
+		applier:   applier,
+		logger:    logger,
+	}
+}
+
+// Register enables webhooks for a provider. The provider name must match the
+// processor ID used in the router.
+func (r *Receiver) Register(provider string, verifier Verifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.verifiers[provider] = verifier
+}
+
+// Receive verifies, stores and applies a webhook. Redelivered events that
+// were already processed, or that another delivery is still applying, are
+// acknowledged without being applied again.
+func (r *Receiver) Receive(ctx context.Context, provider string, header http.Header, body []byte) error {
+	r.mu.RLock()
+	verifier, ok := r.verifiers[provider]
+	r.mu.RUnlock()
+	if !ok {
+		return ErrUnknownProvider
+	}
+
+	event, err := verifier.Verify(header, body)
+	if err != nil {
+		return err
+	}
+	event.Provider = provider
+	event.Payload = body
+	event.ReceivedAt = time.Now().UTC()
+
+	claimed, err := r.events.Claim(ctx, event, claimLease)
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+	if !claimed {
+		r.logger.Debug("Skipping duplicate webhook", zap.String("provider", provider), zap.String("event_id", event.EventID))
+		return nil
+	}
+
+	applyErr := r.applier.ApplyProviderEvent(ctx, event)
+	if errors.Is(applyErr, model.ErrInvalidTransition) || errors.Is(applyErr, repository.ErrPaymentNotFound) {
+		// Out-of-order or foreign events cannot succeed on redelivery either
+		r.logger.Warn("Ignoring webhook",
+			zap.String("provider", provider),
+			zap.String("event_id", event.EventID),
+			zap.String("type", event.Type),
+			zap.Error(applyErr),
+		)
+		applyErr = nil
+	}
+
+	if err := r.events.MarkProcessed(ctx, provider, event.EventID, applyErr); err != nil {
+		return fmt.Errorf("failed to mark event processed: %w", err)
+	}
+	return applyErr
+}
@@ -0,0 +1,152 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+	"go.uber.org/zap"
+)
+
+// memoryEvents follows the DbProviderEventRepository claim rules
+type memoryEvents struct {
+	mu     sync.Mutex
+	events map[string]*memoryEvent
+}
+
+type memoryEvent struct {
+	claimedAt time.Time
+	processed bool
+	err       error
+}
+
+func (m *memoryEvents) Claim(ctx context.Context, event *model.ProviderEvent, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := event.Provider + "/" + event.EventID
+	if e, ok := m.events[key]; ok {
+		stale := !e.processed && e.claimedAt.Before(time.Now().Add(-lease))
+		if e.err == nil && !stale {
+			return false, nil
+		}
+	}
+	m.events[key] = &memoryEvent{claimedAt: time.Now()}
+	return true, nil
+}
+
+func (m *memoryEvents) MarkProcessed(ctx context.Context, provider, eventID string, processingErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.events[provider+"/"+eventID]
+	e.processed, e.err = true, processingErr
+	return nil
+}
+
+// countingApplier counts applications, failing with the errors queued in
+// errs and waiting for release when it is set
+type countingApplier struct {
+	mu      sync.Mutex
+	applied int
+	errs    []error
+	started chan struct{}
+	release chan struct{}
+}
+
+func (a *countingApplier) ApplyProviderEvent(ctx context.Context, event *model.ProviderEvent) error {
+	a.mu.Lock()
+	a.applied++
+	var err error
+	if len(a.errs) > 0 {
+		err, a.errs = a.errs[0], a.errs[1:]
+	}
+	a.mu.Unlock()
+
+	if a.release != nil {
+		close(a.started)
+		<-a.release
+	}
+	return err
+}
+
+func newTestReceiver(applier EventApplier) *Receiver {
+	r := NewReceiver(&memoryEvents{events: make(map[string]*memoryEvent)}, applier, zap.NewNop())
+	r.Register("flutterwave", NewFlutterwaveVerifier("secret-hash"))
+	return r
+}
+
+func deliver(r *Receiver) error {
+	header := http.Header{}
+	header.Set("verif-hash", "secret-hash")
+	return r.Receive(context.Background(), "flutterwave", header, []byte(flutterwaveCompleted))
+}
+
+func TestReceiverAppliesEventOnce(t *testing.T) {
+	applier := &countingApplier{}
+	r := newTestReceiver(applier)
+
+	for i := 0; i < 2; i++ {
+		if err := deliver(r); err != nil {
+			t.Fatalf("delivery %d: Receive() error = %v", i+1, err)
+		}
+	}
+	if applier.applied != 1 {
+		t.Errorf("event applied %d times, want 1", applier.applied)
+	}
+}
+
+func TestReceiverRetriesFailedEvent(t *testing.T) {
+	applier := &countingApplier{errs: []error{errors.New("database unavailable")}}
+	r := newTestReceiver(applier)
+
+	if err := deliver(r); err == nil {
+		t.Fatal("Receive() succeeded, want the apply error so the provider redelivers")
+	}
+	if err := deliver(r); err != nil {
+		t.Fatalf("redelivery: Receive() error = %v", err)
+	}
+	if applier.applied != 2 {
+		t.Errorf("event applied %d times, want 2", applier.applied)
+	}
+}
+
+func TestReceiverSkipsEventBeingApplied(t *testing.T) {
+	applier := &countingApplier{started: make(chan struct{}), release: make(chan struct{})}
+	r := newTestReceiver(applier)
+
+	done := make(chan error)
+	go func() { done <- deliver(r) }()
+	<-applier.started
+
+	// A concurrent copy of the event is acknowledged without applying it
+	if err := deliver(r); err != nil {
+		t.Errorf("concurrent delivery: Receive() error = %v", err)
+	}
+	close(applier.release)
+	if err := <-done; err != nil {
+		t.Fatalf("first delivery: Receive() error = %v", err)
+	}
+	if applier.applied != 1 {
+		t.Errorf("event applied %d times, want 1", applier.applied)
+	}
+}
+
+func TestReceiverRejectsInvalidSignature(t *testing.T) {
+	applier := &countingApplier{}
+	r := newTestReceiver(applier)
+
+	header := http.Header{}
+	header.Set("verif-hash", "forged")
+	if err := r.Receive(context.Background(), "flutterwave", header, []byte(flutterwaveCompleted)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Receive() error = %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := r.Receive(context.Background(), "adyen", header, nil); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("Receive(unregistered) error = %v, want %v", err, ErrUnknownProvider)
+	}
+	if applier.applied != 0 {
+		t.Errorf("event applied %d times, want 0", applier.applied)
+	}
+}
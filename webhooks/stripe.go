@@ -0,0 +1,107 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+// DefaultStripeTolerance is how old a signed Stripe timestamp may be
+const DefaultStripeTolerance = 5 * time.Minute
+
+// StripeVerifier checks the Stripe-Signature header: an HMAC-SHA256 of
+// "timestamp.body" under the endpoint secret
+type StripeVerifier struct {
+	secret    string
+	tolerance time.Duration
+}
+
+func NewStripeVerifier(secret string, tolerance time.Duration) *StripeVerifier {
+	return &StripeVerifier{secret: secret, tolerance: tolerance}
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID            string `json:"id"`
+			Object        string `json:"object"`
+			PaymentIntent string `json:"payment_intent"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// stripeStatuses maps Stripe event types onto payment statuses
+var stripeStatuses = map[string]model.PaymentStatus{
+	"payment_intent.amount_capturable_updated": model.StatusAuthorized,
+	"payment_intent.succeeded":                 model.StatusCompleted,
+	"payment_intent.payment_failed":            model.StatusFailed,
+	"payment_intent.canceled":                  model.StatusVoided,
+	"charge.dispute.created":                   model.StatusDisputed,
+}
+
+func (v *StripeVerifier) Verify(header http.Header, body []byte) (*model.ProviderEvent, error) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return nil, fmt.Errorf("%w: malformed Stripe-Signature header", ErrInvalidSignature)
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > v.tolerance || age < -v.tolerance {
+		return nil, fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(v.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	valid := false
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrInvalidSignature
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode stripe event: %w", err)
+	}
+
+	// Disputes reference the PaymentIntent through the charge
+	reference := event.Data.Object.ID
+	if event.Data.Object.Object != "payment_intent" && event.Data.Object.PaymentIntent != "" {
+		reference = event.Data.Object.PaymentIntent
+	}
+
+	return &model.ProviderEvent{
+		EventID:   event.ID,
+		Type:      event.Type,
+		Reference: reference,
+		Status:    stripeStatuses[event.Type],
+	}, nil
+}
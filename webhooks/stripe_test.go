@@ -0,0 +1,74 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thoraf20/payment-processor/model"
+)
+
+const stripeDispute = `{"id":"evt_1","type":"charge.dispute.created","data":{"object":{"id":"dp_1","object":"dispute","payment_intent":"pi_1"}}}`
+
+func stripeSignature(secret string, at time.Time, body string) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write([]byte(body))
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripeVerify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{"valid", stripeSignature("whsec", now, stripeDispute), stripeDispute, true},
+		{"within tolerance", stripeSignature("whsec", now.Add(-4*time.Minute), stripeDispute), stripeDispute, true},
+		{
+			"one of several signatures",
+			stripeSignature("whsec", now, stripeDispute) + ",v1=" + hex.EncodeToString(make([]byte, 32)),
+			stripeDispute,
+			true,
+		},
+		{"tampered body", stripeSignature("whsec", now, stripeDispute), stripeDispute + " ", false},
+		{"other secret", stripeSignature("other", now, stripeDispute), stripeDispute, false},
+		{"too old", stripeSignature("whsec", now.Add(-6*time.Minute), stripeDispute), stripeDispute, false},
+		{"too far ahead", stripeSignature("whsec", now.Add(6*time.Minute), stripeDispute), stripeDispute, false},
+		{"no signature", "t=" + strconv.FormatInt(now.Unix(), 10), stripeDispute, false},
+		{"no timestamp", "v1=00", stripeDispute, false},
+		{"missing header", "", stripeDispute, false},
+	}
+
+	v := NewStripeVerifier("whsec", DefaultStripeTolerance)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.signature != "" {
+				header.Set("Stripe-Signature", tt.signature)
+			}
+			event, err := v.Verify(header, []byte(tt.body))
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("Verify() error = %v, want %v", err, ErrInvalidSignature)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			// Disputes reference the PaymentIntent through the charge
+			if event.EventID != "evt_1" || event.Reference != "pi_1" || event.Status != model.StatusDisputed {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}